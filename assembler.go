@@ -0,0 +1,670 @@
+package gmachine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxMacroDepth limits how deeply macro expansions may nest, which also
+// stops a macro that invokes itself from expanding forever.
+const MaxMacroDepth = 16
+
+// MaxProgramSize is the largest program, in words, the assembler will
+// produce, so that .space and .org can't exhaust the memory of the host.
+const MaxProgramSize = 1 << 20
+
+// Position identifies a line of assembly source.
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// AssemblyError reports a problem with the source token at Pos. When the
+// token came from a macro body, Expansion lists the call sites which
+// expanded it, innermost first.
+type AssemblyError struct {
+	Pos       Position
+	Expansion []Position
+	Err       error
+}
+
+func (e *AssemblyError) Error() string {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "%s: %v", e.Pos, e.Err)
+	for i, call := range e.Expansion {
+		// A macro which invokes itself repeats the same call site.
+		if i > 0 && call == e.Expansion[i-1] {
+			continue
+		}
+		fmt.Fprintf(&b, " (expanded from %s)", call)
+	}
+	return b.String()
+}
+
+func (e *AssemblyError) Unwrap() error {
+	return e.Err
+}
+
+type token struct {
+	text string
+	pos  Position
+	// line is the source line containing the token.
+	line      string
+	expansion []Position
+	// instance distinguishes the tokens of each macro expansion, which
+	// otherwise share the positions of the macro body.
+	instance int
+}
+
+func (t token) errorf(format string, args ...interface{}) error {
+	return t.wrap(fmt.Errorf(format, args...))
+}
+
+func (t token) wrap(err error) error {
+	return &AssemblyError{Pos: t.pos, Expansion: t.expansion, Err: err}
+}
+
+type macro struct {
+	params []string
+	body   []token
+}
+
+// fixup records a word which refers to a symbol not yet defined when it
+// was assembled.
+type fixup struct {
+	addr int
+	tok  token
+}
+
+type assembler struct {
+	words   []Word
+	origins []token
+	// instructions holds the address of each instruction assembled from a
+	// mnemonic, as opposed to data.
+	instructions []Word
+	constants    map[string]Word
+	labels       map[string]Word
+	macros       map[string]*macro
+	fixups       []fixup
+	expansions   int
+	// relocatable is set when assembling an object, whose labels are
+	// relative to wherever the linker places it.
+	relocatable bool
+	globals     map[string]token
+	externs     map[string]bool
+	relocations []Relocation
+	// maxSize is the largest program .space and .org may grow.
+	maxSize Word
+}
+
+func newAssembler() *assembler {
+	return &assembler{
+		words:     []Word{},
+		constants: predefinedConstants(),
+		labels:    map[string]Word{},
+		macros:    map[string]*macro{},
+		globals:   map[string]token{},
+		externs:   map[string]bool{},
+		maxSize:   MaxProgramSize,
+	}
+}
+
+// predefinedConstants returns a copy of PredefinedConstants, so that
+// constants defined by a program are scoped to one assembly run.
+func predefinedConstants() map[string]Word {
+	constants := make(map[string]Word, len(PredefinedConstants))
+	for name, word := range PredefinedConstants {
+		constants[name] = word
+	}
+	return constants
+}
+
+func AssembleData(token string) ([]Word, error) {
+	words := []Word{}
+	//fmt.Println(token, "is data")
+	switch {
+	case strings.HasPrefix(token, "\""):
+		token = strings.ReplaceAll(token, "\"", "")
+		for _, l := range token {
+			words = append(words, Word(l))
+		}
+	case strings.HasPrefix(token, "'"):
+		token = strings.ReplaceAll(token, "'", "")
+		for _, l := range token {
+			words = append(words, Word(l))
+		}
+	default:
+		for _, s := range strings.Fields(token) {
+			temp, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, Word(temp))
+		}
+
+	}
+	return words, nil
+}
+
+func AssembleOperand(constants map[string]Word, token string) (Word, error) {
+	if strings.HasPrefix(token, "[") {
+		return SETAM, nil
+	}
+	return EvalExpression(token, func(name string) (Word, bool) {
+		word, ok := constants[name]
+		return word, ok
+	})
+}
+
+// Assemble assembles source given as a slice of lines. A line may hold
+// a single token, or several, just as in a source file.
+func Assemble(code []string) ([]Word, error) {
+	tokens := []token{}
+	for i, text := range code {
+		lineTokens, err := lexLine("", i+1, text)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, lineTokens...)
+	}
+	return assemble(tokens)
+}
+
+func assemble(tokens []token) ([]Word, error) {
+	a := newAssembler()
+	err := a.assemble(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return a.words, nil
+}
+
+func (a *assembler) assemble(tokens []token) error {
+	tokens, err := a.defineMacros(tokens)
+	if err != nil {
+		return err
+	}
+	tokens, err = a.expand(tokens, 0)
+	if err != nil {
+		return err
+	}
+	err = a.encode(tokens)
+	if err != nil {
+		return err
+	}
+	return a.resolve()
+}
+
+// defineMacros removes each `.macro name params...` ... `.endm` block from
+// tokens and records it. The parameters are the rest of the .macro line.
+func (a *assembler) defineMacros(tokens []token) ([]token, error) {
+	out := []token{}
+	for pos := 0; pos < len(tokens); pos++ {
+		tok := tokens[pos]
+		switch tok.text {
+		case ".endm":
+			return nil, tok.errorf(".endm without .macro")
+		case ".macro":
+		default:
+			out = append(out, tok)
+			continue
+		}
+		header := restOfLine(tokens, pos)
+		if len(header) == 0 {
+			return nil, tok.errorf("missing macro name")
+		}
+		name := header[0].text
+		if _, ok := TranslateTable[strings.ToUpper(name)]; ok {
+			return nil, header[0].errorf("macro name %q is an instruction", name)
+		}
+		if _, ok := a.macros[name]; ok {
+			return nil, header[0].errorf("macro %q already defined", name)
+		}
+		m := &macro{}
+		for _, param := range header[1:] {
+			m.params = append(m.params, param.text)
+		}
+		pos += len(header)
+		for {
+			pos++
+			if pos >= len(tokens) {
+				return nil, tok.errorf("missing .endm for macro %q", name)
+			}
+			if tokens[pos].text == ".macro" {
+				return nil, tokens[pos].errorf("macro definitions cannot be nested")
+			}
+			if tokens[pos].text == ".endm" {
+				break
+			}
+			m.body = append(m.body, tokens[pos])
+		}
+		a.macros[name] = m
+	}
+	return out, nil
+}
+
+// expand replaces each macro invocation in tokens with the macro body. The
+// arguments are the rest of the line, one for each parameter.
+func (a *assembler) expand(tokens []token, depth int) ([]token, error) {
+	out := []token{}
+	for pos := 0; pos < len(tokens); pos++ {
+		call := tokens[pos]
+		m, ok := a.macros[call.text]
+		if !ok {
+			out = append(out, call)
+			continue
+		}
+		if depth >= MaxMacroDepth {
+			return nil, call.errorf("macro expansion nested deeper than %d", MaxMacroDepth)
+		}
+		args := restOfLine(tokens, pos)
+		if len(args) < len(m.params) {
+			return nil, call.errorf("missing argument for macro %q", call.text)
+		}
+		if len(args) > len(m.params) {
+			return nil, call.errorf("too many arguments for macro %q", call.text)
+		}
+		pos += len(args)
+		body, err := a.expand(a.instantiate(m, call, args), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, body...)
+	}
+	return out, nil
+}
+
+// instantiate returns a copy of the macro body with parameters replaced by
+// args. Labels defined in the body are renamed uniquely for this expansion,
+// so that a macro can be used more than once.
+func (a *assembler) instantiate(m *macro, call token, args []token) []token {
+	a.expansions++
+	suffix := fmt.Sprintf("@%d", a.expansions)
+	local := map[string]bool{}
+	for _, tok := range m.body {
+		if isLabel(tok.text) {
+			local[strings.TrimSuffix(tok.text, ":")] = true
+		}
+	}
+	params := map[string]string{}
+	for i, param := range m.params {
+		params[param] = args[i].text
+	}
+	rename := func(name string) string {
+		if arg, ok := params[name]; ok {
+			return "(" + arg + ")"
+		}
+		if local[name] {
+			return name + suffix
+		}
+		return name
+	}
+	expansion := append([]Position{call.pos}, call.expansion...)
+	body := make([]token, len(m.body))
+	for i, tok := range m.body {
+		text, ok := params[tok.text]
+		switch {
+		case ok:
+		case isLabel(tok.text):
+			text = rename(strings.TrimSuffix(tok.text, ":")) + ":"
+		default:
+			text = replaceIdentifiers(tok.text, rename)
+		}
+		body[i] = token{text: text, pos: tok.pos, line: tok.line, expansion: expansion, instance: a.expansions}
+	}
+	return body
+}
+
+func (a *assembler) encode(tokens []token) error {
+	for pos := 0; pos < len(tokens); pos++ {
+		tok := tokens[pos]
+		if isLabel(tok.text) {
+			name := strings.TrimSuffix(tok.text, ":")
+			if _, ok := a.labels[name]; ok {
+				return tok.errorf("label %q already defined", name)
+			}
+			if _, ok := a.constants[name]; ok {
+				return tok.errorf("label %q is already a constant", name)
+			}
+			a.labels[name] = Word(len(a.words))
+			continue
+		}
+		if strings.HasPrefix(tok.text, ".") {
+			args := restOfLine(tokens, pos)
+			err := a.directive(tok, args)
+			if err != nil {
+				return err
+			}
+			pos += len(args)
+			continue
+		}
+		instruction, ok := TranslateTable[strings.ToUpper(tok.text)]
+		if !ok {
+			err := a.data(tok)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		//fmt.Println(token, "is opcode")
+		a.instructions = append(a.instructions, Word(len(a.words)))
+		a.emit(tok, instruction.Opcode)
+		if instruction.Operands <= 0 {
+			continue
+		}
+		if pos+instruction.Operands >= len(tokens) {
+			return tok.wrap(errors.New("missing operand"))
+		}
+		for count := 0; count < instruction.Operands; count++ {
+			pos++
+			operand := tokens[pos]
+			//fmt.Println(operand, "is operand")
+			if _, ok := TranslateTable[strings.ToUpper(operand.text)]; ok {
+				return tok.wrap(errors.New("missing operand"))
+			}
+			if strings.HasPrefix(operand.text, "[") {
+				word, err := AssembleOperand(a.constants, operand.text)
+				if err != nil {
+					return operand.wrap(err)
+				}
+				a.words[len(a.words)-1] = word
+				continue
+			}
+			err := a.operand(operand)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// directive assembles a directive, whose arguments are the rest of its
+// line.
+func (a *assembler) directive(tok token, args []token) error {
+	switch tok.text {
+	case ".equ":
+		if len(args) < 2 {
+			return tok.errorf("usage: .equ NAME value")
+		}
+		name := args[0].text
+		if !isIdentifier(name) {
+			return args[0].errorf("invalid constant name %q", name)
+		}
+		if _, ok := a.constants[name]; ok {
+			return args[0].errorf("constant %q already defined", name)
+		}
+		if _, ok := a.labels[name]; ok {
+			return args[0].errorf("constant %q is already a label", name)
+		}
+		word, err := a.value(joinTokens(args[1:]))
+		if err != nil {
+			return err
+		}
+		a.constants[name] = word
+	case ".word":
+		if len(args) == 0 {
+			return tok.errorf("missing .word value")
+		}
+		for _, arg := range args {
+			err := a.data(arg)
+			if err != nil {
+				return err
+			}
+		}
+	case ".string", ".stringz":
+		if len(args) == 0 {
+			return tok.errorf("missing %s value", tok.text)
+		}
+		text := joinTokens(args).text
+		s, err := strconv.Unquote(text)
+		if err != nil || !strings.HasPrefix(text, "\"") {
+			return args[0].errorf("invalid string %s", text)
+		}
+		for _, r := range s {
+			a.emit(tok, Word(r))
+		}
+		if tok.text == ".stringz" {
+			a.emit(tok, 0)
+		}
+	case ".space":
+		if len(args) == 0 {
+			return tok.errorf("usage: .space n")
+		}
+		n, err := a.value(joinTokens(args))
+		if err != nil {
+			return err
+		}
+		if int64(n) < 0 {
+			return args[0].errorf("negative .space size %d", int64(n))
+		}
+		return a.space(tok, n)
+	case ".org":
+		if len(args) == 0 {
+			return tok.errorf("usage: .org addr")
+		}
+		addr, err := a.value(joinTokens(args))
+		if err != nil {
+			return err
+		}
+		if int64(addr) < int64(len(a.words)) {
+			return args[0].errorf(".org %d is before the current address %d", int64(addr), len(a.words))
+		}
+		return a.space(tok, addr-Word(len(a.words)))
+	case ".global", ".extern":
+		if len(args) == 0 {
+			return tok.errorf("usage: %s NAME...", tok.text)
+		}
+		for _, arg := range args {
+			if !isIdentifier(arg.text) {
+				return arg.errorf("invalid symbol name %q", arg.text)
+			}
+			if tok.text == ".global" {
+				a.globals[arg.text] = arg
+			} else {
+				a.externs[arg.text] = true
+			}
+		}
+	default:
+		return tok.errorf("unknown directive %s", tok.text)
+	}
+	return nil
+}
+
+// value returns the value of a token which must be known immediately,
+// because it only refers to numbers and already defined symbols.
+func (a *assembler) value(tok token) (Word, error) {
+	if a.relocatable {
+		word, relocation, err := a.relocate(tok)
+		if err != nil {
+			return 0, err
+		}
+		if relocation != nil {
+			return 0, tok.errorf("value %q depends on a relocatable address", tok.text)
+		}
+		return word, nil
+	}
+	word, err := EvalExpression(tok.text, a.lookup)
+	if err != nil {
+		return 0, tok.wrap(err)
+	}
+	return word, nil
+}
+
+func (a *assembler) data(tok token) error {
+	if strings.HasPrefix(tok.text, "\"") || isCharacters(tok.text) {
+		data, err := AssembleData(tok.text)
+		if err != nil {
+			return tok.wrap(err)
+		}
+		a.emit(tok, data...)
+		return nil
+	}
+	return a.expression(tok)
+}
+
+func (a *assembler) operand(tok token) error {
+	return a.expression(tok)
+}
+
+// expression assembles the value of an expression, leaving a fixup if it
+// refers to a symbol which has not been defined yet. In a relocatable
+// object every expression is fixed up, once all labels are known to
+// decide whether it needs relocating.
+func (a *assembler) expression(tok token) error {
+	word, err := EvalExpression(tok.text, a.lookup)
+	var undefined *UndefinedSymbolError
+	if errors.As(err, &undefined) || a.relocatable {
+		a.fixups = append(a.fixups, fixup{addr: len(a.words), tok: tok})
+		word, err = 0, nil
+	}
+	if err != nil {
+		return tok.wrap(err)
+	}
+	a.emit(tok, word)
+	return nil
+}
+
+// space emits n zero words, unless that would make the program larger than
+// maxSize.
+func (a *assembler) space(tok token, n Word) error {
+	if n > a.maxSize || Word(len(a.words)) > a.maxSize-n {
+		return tok.errorf("program would be larger than %d words", a.maxSize)
+	}
+	a.emit(tok, make([]Word, n)...)
+	return nil
+}
+
+// emit appends words to the program, recording the token they came from.
+func (a *assembler) emit(tok token, words ...Word) {
+	a.words = append(a.words, words...)
+	for range words {
+		a.origins = append(a.origins, tok)
+	}
+}
+
+func (a *assembler) lookup(name string) (Word, bool) {
+	if word, ok := a.labels[name]; ok {
+		return word, true
+	}
+	word, ok := a.constants[name]
+	return word, ok
+}
+
+func (a *assembler) resolve() error {
+	for _, f := range a.fixups {
+		if a.relocatable {
+			word, relocation, err := a.relocate(f.tok)
+			if err != nil {
+				return err
+			}
+			if relocation != nil {
+				relocation.Offset = Word(f.addr)
+				a.relocations = append(a.relocations, *relocation)
+			}
+			a.words[f.addr] = word
+			continue
+		}
+		word, err := EvalExpression(f.tok.text, a.lookup)
+		if err != nil {
+			return f.tok.wrap(err)
+		}
+		a.words[f.addr] = word
+	}
+	return nil
+}
+
+// joinTokens joins the texts of tokens from one line, separated by
+// spaces, into a single token at the position of the first.
+func joinTokens(tokens []token) token {
+	texts := make([]string, len(tokens))
+	for i, tok := range tokens {
+		texts[i] = tok.text
+	}
+	tok := tokens[0]
+	tok.text = strings.Join(texts, " ")
+	return tok
+}
+
+// restOfLine returns the tokens following tokens[pos] on the same line.
+func restOfLine(tokens []token, pos int) []token {
+	end := pos + 1
+	for end < len(tokens) && tokens[end].pos == tokens[pos].pos && tokens[end].instance == tokens[pos].instance {
+		end++
+	}
+	return tokens[pos+1 : end]
+}
+
+func isLabel(text string) bool {
+	return strings.HasSuffix(text, ":") && isIdentifier(strings.TrimSuffix(text, ":"))
+}
+
+func isIdentifier(text string) bool {
+	for i, r := range text {
+		switch {
+		case unicode.IsLetter(r), r == '_':
+		case i > 0 && isIdentifierRune(r):
+		default:
+			return false
+		}
+	}
+	return text != ""
+}
+
+// isCharacters reports whether text is a single quoted run of several
+// characters, such as 'abc', which assembles to one word per character,
+// rather than an expression such as 'A'+'B'.
+func isCharacters(text string) bool {
+	if len(text) <= 3 || !strings.HasPrefix(text, "'") || !strings.HasSuffix(text, "'") {
+		return false
+	}
+	return !strings.ContainsAny(text[1:len(text)-1], "'\\")
+}
+
+func AssembleFromFile(path string) ([]Word, error) {
+	return AssembleFiles(path)
+}
+
+// AssembleFiles assembles several source files together into one program,
+// in the order given. Labels and constants are shared between the files.
+func AssembleFiles(paths ...string) ([]Word, error) {
+	tokens, err := readFiles(hostSources{}, paths)
+	if err != nil {
+		return nil, err
+	}
+	words, err := assemble(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
+// AssembleFromText assembles source held in a string, which can't include
+// files.
+func AssembleFromText(text string) ([]Word, error) {
+	return AssembleFromReader(strings.NewReader(text))
+}
+
+func AssembleFromFileToBinary(inPath, outPath string) error {
+	data, err := AssembleFromFile(inPath)
+	if err != nil {
+		return err
+	}
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	return WriteWords(outFile, data)
+}
@@ -0,0 +1,179 @@
+// Package brainfuck translates Brainfuck programs to G-machine assembly.
+//
+// The tape is the memory after the program, starting at the first word
+// past its end, and I points at the current cell. Cells are machine words,
+// starting at zero, and don't wrap at 256. The . command writes the
+// current cell to standard output and , reads a character from standard
+// input into it, giving 0 at the end of the input. Every other character
+// is a comment.
+//
+// Runs of + and -, and of > and <, are each translated to a single
+// addition, and the loop [-] or [+] to clearing the cell.
+package brainfuck
+
+import (
+	"fmt"
+	"gmachine"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Error reports a problem with the source at Pos.
+type Error struct {
+	Pos gmachine.Position
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// command is a Brainfuck command, and the line it is on.
+type command struct {
+	op   byte
+	line int
+}
+
+type translator struct {
+	name   string
+	out    strings.Builder
+	labels int
+	// add and move are the pending changes to the current cell and to
+	// the pointer, from a run of commands not yet translated.
+	add, move int
+}
+
+func (t *translator) errorf(line int, format string, args ...interface{}) error {
+	return &Error{Pos: gmachine.Position{File: t.name, Line: line}, Err: fmt.Errorf(format, args...)}
+}
+
+func (t *translator) emit(format string, args ...interface{}) {
+	fmt.Fprintf(&t.out, "    "+format+"\n", args...)
+}
+
+func (t *translator) label(name string) {
+	fmt.Fprintf(&t.out, "%s:\n", name)
+}
+
+// flush translates the pending run of commands.
+func (t *translator) flush() {
+	if t.add != 0 {
+		t.emit("SETA [I]")
+		t.emit("ADDA %d", t.add)
+		t.emit("STORE")
+	}
+	if t.move != 0 {
+		t.emit("ADDI %d", t.move)
+	}
+	t.add, t.move = 0, 0
+}
+
+// Translate translates the Brainfuck program read from r, named name in
+// errors, and returns its assembly source.
+func Translate(name string, r io.Reader) ([]byte, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	commands := []command{}
+	line := 1
+	for _, c := range src {
+		switch c {
+		case '\n':
+			line++
+		case '+', '-', '>', '<', '.', ',', '[', ']':
+			commands = append(commands, command{op: c, line: line})
+		}
+	}
+	t := &translator{name: name}
+	t.label("start")
+	t.emit("SETI tape")
+	// loops holds the label number and the line of each open loop.
+	type loop struct{ label, line int }
+	loops := []loop{}
+	for i := 0; i < len(commands); i++ {
+		c := commands[i]
+		switch c.op {
+		case '+', '-':
+			if t.move != 0 {
+				t.flush()
+			}
+			if c.op == '+' {
+				t.add++
+			} else {
+				t.add--
+			}
+			continue
+		case '>', '<':
+			if c.op == '>' {
+				t.move++
+			} else {
+				t.move--
+			}
+			continue
+		}
+		t.flush()
+		switch c.op {
+		case '.':
+			t.emit("SETA [I]")
+			t.emit("BIOS IOWRITE, STDOUT")
+		case ',':
+			t.emit("BIOS IOREAD, STDIN")
+			t.emit("STORE")
+		case '[':
+			if i+2 < len(commands) && commands[i+2].op == ']' && (commands[i+1].op == '-' || commands[i+1].op == '+') {
+				t.emit("SETA 0")
+				t.emit("STORE")
+				i += 2
+				break
+			}
+			t.labels++
+			loops = append(loops, loop{label: t.labels, line: c.line})
+			t.label(fmt.Sprintf("L%d", t.labels))
+			t.emit("SETA [I]")
+			t.emit("CMPA 0")
+			t.emit("JEQ L%d_body", t.labels)
+			t.emit("JUMP L%d_end", t.labels)
+			t.label(fmt.Sprintf("L%d_body", t.labels))
+		case ']':
+			if len(loops) == 0 {
+				return nil, t.errorf(c.line, "unmatched ]")
+			}
+			n := loops[len(loops)-1].label
+			loops = loops[:len(loops)-1]
+			t.emit("JUMP L%d", n)
+			t.label(fmt.Sprintf("L%d_end", n))
+		}
+	}
+	if len(loops) > 0 {
+		return nil, t.errorf(loops[len(loops)-1].line, "unmatched [")
+	}
+	t.flush()
+	t.emit("HALT")
+	t.out.WriteString("\n# The tape runs from here to the end of memory.\n")
+	t.label("tape")
+	return []byte(t.out.String()), nil
+}
+
+// TranslateProgram translates the Brainfuck program read from r and
+// assembles it. The assembly source is named after name, with its
+// extension replaced by .gasm, in the program's debug info.
+func TranslateProgram(name string, r io.Reader) (*gmachine.Program, error) {
+	source, err := Translate(name, r)
+	if err != nil {
+		return nil, err
+	}
+	return gmachine.AssembleProgramFromReader(AssemblyName(name), strings.NewReader(string(source)))
+}
+
+// AssemblyName returns the name of the assembly source translated from
+// the Brainfuck source file name.
+func AssemblyName(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".gasm"
+}
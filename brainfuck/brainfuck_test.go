@@ -0,0 +1,139 @@
+package brainfuck_test
+
+import (
+	"bytes"
+	"errors"
+	"gmachine"
+	"gmachine/brainfuck"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// run translates and runs a program, returning its output.
+func run(t *testing.T, name, source, stdin string) (string, error) {
+	t.Helper()
+	program, err := brainfuck.TranslateProgram(name, strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	g.Debug = program.DebugInfo()
+	g.Stdin = strings.NewReader(stdin)
+	out := &bytes.Buffer{}
+	g.Stdout = out
+	err = g.RunProgram(program.Words)
+	return out.String(), err
+}
+
+// TestGolden runs each program in testdata, with the .in file of the same
+// name as its input, if there is one, and checks its output against the
+// .golden file.
+func TestGolden(t *testing.T) {
+	t.Parallel()
+	paths, err := filepath.Glob("testdata/*.bf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no programs in testdata")
+	}
+	for _, path := range paths {
+		base := strings.TrimSuffix(path, ".bf")
+		source, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdin, err := ioutil.ReadFile(base + ".in")
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		want, err := ioutil.ReadFile(base + ".golden")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := run(t, path, string(source), string(stdin))
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+		if string(want) != got {
+			t.Errorf("%s: want output %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	t.Parallel()
+	got, err := brainfuck.Translate("runs.bf", strings.NewReader("+++ comment -- >>\n><< [-] ,[.+>]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `start:
+    SETI tape
+    SETA [I]
+    ADDA 1
+    STORE
+    ADDI 1
+    SETA 0
+    STORE
+    BIOS IOREAD, STDIN
+    STORE
+L1:
+    SETA [I]
+    CMPA 0
+    JEQ L1_body
+    JUMP L1_end
+L1_body:
+    SETA [I]
+    BIOS IOWRITE, STDOUT
+    SETA [I]
+    ADDA 1
+    STORE
+    ADDI 1
+    JUMP L1
+L1_end:
+    HALT
+
+# The tape runs from here to the end of memory.
+tape:
+`
+	if !cmp.Equal(want, string(got)) {
+		t.Error(cmp.Diff(want, string(got)))
+	}
+}
+
+func TestTranslateErrors(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc   string
+		source string
+		want   string
+	}{
+		{desc: "Unmatched ]", source: "+\n+]", want: "bad.bf:2: unmatched ]"},
+		{desc: "Unmatched [", source: "[\n[-]\n[", want: "bad.bf:3: unmatched ["},
+	}
+	for _, tC := range testCases {
+		_, err := brainfuck.Translate("bad.bf", strings.NewReader(tC.source))
+		var bfErr *brainfuck.Error
+		if !errors.As(err, &bfErr) {
+			t.Errorf("%s: want *brainfuck.Error, got %v", tC.desc, err)
+			continue
+		}
+		if tC.want != err.Error() {
+			t.Errorf("%s: want error %q, got %q", tC.desc, tC.want, err)
+		}
+	}
+}
+
+func TestTapeFault(t *testing.T) {
+	t.Parallel()
+	_, err := run(t, "far.bf", "+[>+]", "")
+	var fault *gmachine.Fault
+	if !errors.As(err, &fault) || !strings.HasPrefix(fault.Location, "far.gasm:") {
+		t.Errorf("want fault in far.gasm, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package gmachine
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Clock is the source of wall-clock time and sleeping for the CLOCK port.
+// Tests can substitute a fake to keep programs deterministic.
+type Clock interface {
+	Now() time.Time
+	Sleep(time.Duration)
+}
+
+// MaxSleep is the longest a program may sleep at once.
+const MaxSleep = time.Minute
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// clockOperation performs a clock BIOS operation. ClockTime sets A to the
+// wall-clock time in milliseconds since the Unix epoch, ClockCycles sets A
+// to the number of instructions executed so far, and ClockSleep pauses for
+// the number of milliseconds in A, failing with ErrorInvalid if that is more
+// than MaxSleep.
+func (g *GMachine) clockOperation(operation Word) {
+	if g.Clock == nil {
+		g.E = ErrorNoDevice
+		return
+	}
+	switch operation {
+	case ClockTime:
+		g.A = Word(g.Clock.Now().UnixNano() / int64(time.Millisecond))
+	case ClockCycles:
+		g.A = g.Cycles
+	case ClockSleep:
+		if g.A > Word(MaxSleep/time.Millisecond) {
+			g.E = ErrorInvalid
+			return
+		}
+		g.Clock.Sleep(time.Duration(g.A) * time.Millisecond)
+	default:
+		g.E = ErrorInvalid
+		return
+	}
+	g.E = ErrorNone
+}
+
+// randomOperation performs a random number BIOS operation. RandomSeed
+// reseeds the generator from A, and RandomNext sets A to the next
+// pseudo-random word.
+func (g *GMachine) randomOperation(operation Word) {
+	if g.Rand == nil {
+		g.E = ErrorNoDevice
+		return
+	}
+	switch operation {
+	case RandomSeed:
+		g.Rand.Seed(int64(g.A))
+	case RandomNext:
+		g.A = Word(g.Rand.Uint64())
+	default:
+		g.E = ErrorInvalid
+		return
+	}
+	g.E = ErrorNone
+}
+
+func newRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gmachine"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+)
+
+const usage = `Usage:
+  disk create [-blocks n] image
+  disk info image
+  disk dump image block
+  disk put image block file
+`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 3 {
+		log.Fatal(usage)
+	}
+	var err error
+	switch os.Args[1] {
+	case "create":
+		err = create(os.Args[2:])
+	case "info":
+		err = info(os.Args[2])
+	case "dump":
+		if len(os.Args) != 4 {
+			log.Fatal(usage)
+		}
+		err = dump(os.Args[2], os.Args[3])
+	case "put":
+		if len(os.Args) != 5 {
+			log.Fatal(usage)
+		}
+		err = put(os.Args[2], os.Args[3], os.Args[4])
+	default:
+		log.Fatal(usage)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	blocks := fs.Int("blocks", 16, "number of blocks in the image")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	return gmachine.CreateDisk(fs.Arg(0), *blocks)
+}
+
+func info(path string) error {
+	disk, err := gmachine.OpenDisk(path)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+	fmt.Printf("%s: %d blocks of %d words\n", path, disk.Blocks(), gmachine.BlockSize)
+	return nil
+}
+
+// dump prints a block as rows of words followed by their printable
+// characters.
+func dump(path, block string) error {
+	disk, err := openAt(path, block)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+	buf := make([]gmachine.Word, gmachine.BlockSize)
+	if err := disk.ReadBlock(buf); err != nil {
+		return err
+	}
+	for row := 0; row < len(buf); row += 8 {
+		chars := []byte{}
+		fmt.Printf("%04d:", row)
+		for _, word := range buf[row : row+8] {
+			fmt.Printf(" %016x", uint64(word))
+			c := byte('.')
+			if word >= 32 && word < 127 {
+				c = byte(word)
+			}
+			chars = append(chars, c)
+		}
+		fmt.Printf("  %s\n", chars)
+	}
+	return nil
+}
+
+// put copies a host file into the image starting at the given block, one
+// byte per word, zero-padding the last block.
+func put(path, block, dataPath string) error {
+	data, err := ioutil.ReadFile(dataPath)
+	if err != nil {
+		return err
+	}
+	disk, err := openAt(path, block)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+	for len(data) > 0 {
+		buf := make([]gmachine.Word, gmachine.BlockSize)
+		for i := 0; i < len(buf) && len(data) > 0; i++ {
+			buf[i] = gmachine.Word(data[0])
+			data = data[1:]
+		}
+		if err := disk.WriteBlock(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func openAt(path, block string) (*gmachine.Disk, error) {
+	n, err := strconv.Atoi(block)
+	if err != nil {
+		return nil, err
+	}
+	disk, err := gmachine.OpenDisk(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := disk.Seek(gmachine.Word(n)); err != nil {
+		disk.Close()
+		return nil, err
+	}
+	return disk, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"gmachine"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) > 1 && os.Args[1] == "vet" {
+		os.Exit(vet(os.Args[2:]))
+	}
+	outPath := flag.String("o", "", "output file, or - for stdout (default: first source file with a .gbin or .gobj extension, or stdout when reading stdin)")
+	object := flag.Bool("c", false, "emit a relocatable object for glink instead of a binary")
+	listPath := flag.String("l", "", "also write a listing of addresses, words and source lines")
+	mapPath := flag.String("m", "", "also write a symbol map, for run -map")
+	debug := flag.Bool("g", false, "embed source lines and symbols in the binary, for faults and traces")
+	flag.Usage = func() {
+		log.Print("Usage: gasm [-c] [-g] [-o out.gbin] [-l out.lst] [-m out.map] file.gasm... | -")
+		log.Print("       gasm vet file.gasm... | -")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ext := ".gbin"
+	if *object {
+		ext = ".gobj"
+	}
+	stdin := flag.Arg(0) == "-"
+	if stdin && flag.NArg() > 1 {
+		log.Fatal("cannot assemble stdin together with other files")
+	}
+	if *outPath == "" {
+		first := flag.Arg(0)
+		*outPath = strings.TrimSuffix(first, filepath.Ext(first)) + ext
+		if stdin {
+			*outPath = "-"
+		}
+	}
+	if *object {
+		if *listPath != "" || *mapPath != "" || *debug {
+			log.Fatal("listings, symbol maps and debug info are only available for binaries")
+		}
+		if stdin {
+			log.Fatal("objects can only be assembled from files")
+		}
+		obj, err := gmachine.AssembleObject(flag.Args()...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		write(*outPath, func(w io.Writer) error {
+			return gmachine.WriteObject(w, obj)
+		})
+		return
+	}
+	program, err := assemble(flag.Args(), stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var info *gmachine.DebugInfo
+	if *debug {
+		info = program.DebugInfo()
+	}
+	write(*outPath, func(w io.Writer) error {
+		return gmachine.WriteBinary(w, program.Words, info)
+	})
+	if *listPath != "" {
+		write(*listPath, program.WriteListing)
+	}
+	if *mapPath != "" {
+		write(*mapPath, func(w io.Writer) error {
+			_, err := program.Symbols.WriteTo(w)
+			return err
+		})
+	}
+}
+
+func assemble(paths []string, stdin bool) (*gmachine.Program, error) {
+	if stdin {
+		return gmachine.AssembleProgramFromReader("<stdin>", os.Stdin)
+	}
+	return gmachine.AssembleProgram(paths...)
+}
+
+// write writes an output file, or stdout if path is -.
+func write(path string, writeTo func(io.Writer) error) {
+	if path == "-" {
+		if err := writeTo(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = writeTo(outFile)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// vet reports likely mistakes in a program, returning the exit status: 1
+// if there were any, or 2 if the program could not be assembled.
+func vet(args []string) int {
+	flags := flag.NewFlagSet("vet", flag.ExitOnError)
+	flags.Usage = func() {
+		log.Print("Usage: gasm vet file.gasm... | -")
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	stdin := flags.Arg(0) == "-"
+	if stdin && flags.NArg() > 1 {
+		log.Print("cannot vet stdin together with other files")
+		return 2
+	}
+	program, err := assemble(flags.Args(), stdin)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	diagnostics := gmachine.Vet(program)
+	for _, d := range diagnostics {
+		log.Print(d)
+	}
+	if len(diagnostics) > 0 {
+		return 1
+	}
+	return 0
+}
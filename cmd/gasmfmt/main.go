@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"gmachine"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var (
+	list  = flag.Bool("l", false, "list files whose formatting differs from gasmfmt's")
+	write = flag.Bool("w", false, "write result to (source) file instead of stdout")
+)
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		log.Print("Usage: gasmfmt [-l] [-w] [path ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		if *write {
+			log.Fatal("cannot use -w with standard input")
+		}
+		if err := process("<standard input>", os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	failed := false
+	for _, root := range flag.Args() {
+		// Files named explicitly are formatted whatever their extension;
+		// in directories, only .gasm files are.
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || (path != root && filepath.Ext(path) != ".gasm") {
+				return nil
+			}
+			return processFile(path)
+		})
+		if err != nil {
+			log.Print(err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(2)
+	}
+}
+
+func processFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return process(path, file)
+}
+
+// process formats the source read from r, reporting or writing the result
+// as the flags ask.
+func process(path string, r io.Reader) error {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	out, err := gmachine.Format(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if *list && !bytes.Equal(src, out) {
+		fmt.Println(path)
+	}
+	if *write {
+		if bytes.Equal(src, out) {
+			return nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, out, info.Mode().Perm())
+	}
+	if !*list {
+		_, err = os.Stdout.Write(out)
+	}
+	return err
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"gmachine/lsp"
+	"log"
+	"os"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) > 1 {
+		log.Fatal("Usage: gasmls\n\ngasmls is a language server for .gasm files, speaking LSP on stdin and stdout.")
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
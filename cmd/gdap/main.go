@@ -0,0 +1,17 @@
+package main
+
+import (
+	"gmachine/dap"
+	"log"
+	"os"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) > 1 {
+		log.Fatal("Usage: gdap\n\ngdap is a debug adapter for G-machine programs, speaking DAP on stdin and stdout.")
+	}
+	if err := dap.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gmachine"
+	"gmachine/gdb"
+	"log"
+	"os"
+)
+
+func main() {
+	log.SetFlags(0)
+	addr := flag.String("addr", "localhost:1234", "TCP address to listen on for gdb")
+	diskPath := flag.String("disk", "", "disk image to attach as the DISK device")
+	root := flag.String("root", "", "host directory the program may access through the FILE port")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("Usage: gdbstub [-addr host:port] [-disk image] [-root dir] [gbin file]\n\nConnect with gdb-multiarch: target remote localhost:1234")
+	}
+	g := gmachine.New()
+	if err := load(g, flag.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+	g.FileRoot = *root
+	defer g.CloseFiles()
+	if *diskPath != "" {
+		disk, err := gmachine.OpenDisk(*diskPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer disk.Close()
+		g.Disk = disk
+	}
+	log.Printf("waiting for gdb on %s", *addr)
+	if err := gdb.NewServer(g).ListenAndServe(*addr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// load copies a binary into memory, keeping its debug info for faults.
+func load(g *gmachine.GMachine, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	words, debug, err := gmachine.ReadBinary(file)
+	if err != nil {
+		return err
+	}
+	if len(words) > len(g.Memory) {
+		return fmt.Errorf("program of %d words does not fit in %d words of memory", len(words), len(g.Memory))
+	}
+	copy(g.Memory, words)
+	g.Debug = debug
+	return nil
+}
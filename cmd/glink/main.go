@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"gmachine"
+	"log"
+	"os"
+)
+
+func main() {
+	log.SetFlags(0)
+	outPath := flag.String("o", "a.gbin", "output binary")
+	flag.Usage = func() {
+		log.Print("Usage: glink [-o out.gbin] file.gobj...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	objects := []*gmachine.Object{}
+	for _, path := range flag.Args() {
+		obj, err := readObject(path)
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		objects = append(objects, obj)
+	}
+	words, err := gmachine.Link(objects...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	outFile, err := os.Create(*outPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer outFile.Close()
+	if err := gmachine.WriteWords(outFile, words); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readObject(path string) (*gmachine.Object, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return gmachine.ReadObject(file)
+}
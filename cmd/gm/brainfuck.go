@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"gmachine"
+	"gmachine/brainfuck"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+func translateBrainfuck(args []string) {
+	flags := flag.NewFlagSet("gm brainfuck", flag.ExitOnError)
+	outPath := flags.String("o", "", "output file: assembly source, or a binary if it ends in .gbin, or - for stdout (default: the source file with a .gasm extension)")
+	debug := flags.Bool("g", false, "embed the assembly source lines and symbols in a binary")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: gm brainfuck [-g] [-o out.gasm|out.gbin] program.bf")
+	}
+	path := flags.Arg(0)
+	if *outPath == "" {
+		*outPath = brainfuck.AssemblyName(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	source, err := brainfuck.Translate(path, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !strings.HasSuffix(*outPath, ".gbin") {
+		writeFile(*outPath, func(w io.Writer) error {
+			_, err := w.Write(source)
+			return err
+		})
+		return
+	}
+	program, err := gmachine.AssembleProgramFromReader(brainfuck.AssemblyName(path), bytes.NewReader(source))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var info *gmachine.DebugInfo
+	if *debug {
+		info = program.DebugInfo()
+	}
+	writeFile(*outPath, func(w io.Writer) error {
+		return gmachine.WriteBinary(w, program.Words, info)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"gmachine"
+	"gmachine/compiler"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+func compile(args []string) {
+	flags := flag.NewFlagSet("gm compile", flag.ExitOnError)
+	outPath := flags.String("o", "", "output file: assembly source, or a binary if it ends in .gbin, or - for stdout (default: the source file with a .gasm extension)")
+	debug := flags.Bool("g", false, "embed the assembly source lines and symbols in a binary")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: gm compile [-g] [-o out.gasm|out.gbin] program.g")
+	}
+	path := flags.Arg(0)
+	if *outPath == "" {
+		*outPath = compiler.AssemblyName(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	source, err := compiler.Compile(path, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !strings.HasSuffix(*outPath, ".gbin") {
+		writeFile(*outPath, func(w io.Writer) error {
+			_, err := w.Write(source)
+			return err
+		})
+		return
+	}
+	program, err := gmachine.AssembleProgramFromReader(compiler.AssemblyName(path), bytes.NewReader(source))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var info *gmachine.DebugInfo
+	if *debug {
+		info = program.DebugInfo()
+	}
+	writeFile(*outPath, func(w io.Writer) error {
+		return gmachine.WriteBinary(w, program.Words, info)
+	})
+}
+
+// writeFile writes an output file, or stdout if path is -.
+func writeFile(path string, writeTo func(io.Writer) error) {
+	if path == "-" {
+		if err := writeTo(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	outFile, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = writeTo(outFile)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gmachine"
+	"gmachine/tui"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func debug(args []string) {
+	flags := flag.NewFlagSet("gm debug", flag.ExitOnError)
+	stdin := flags.String("stdin", "", "file to give the program as standard input")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: gm debug [-stdin file] program.gasm|program.gbin")
+	}
+	g := gmachine.New()
+	size, err := load(g, flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	g.Stdin = strings.NewReader("")
+	if *stdin != "" {
+		file, err := os.Open(*stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		g.Stdin = file
+	}
+	d := tui.New(g, size)
+	if rows, cols, err := terminalSize(); err == nil {
+		d.Width, d.Height = cols, rows
+	}
+	restore, err := rawMode()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = d.Run(os.Stdin, os.Stdout)
+	restore()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// load assembles a source file or reads a binary into memory, returning
+// its size.
+func load(g *gmachine.GMachine, path string) (int, error) {
+	var words []gmachine.Word
+	if strings.HasSuffix(path, ".gbin") {
+		file, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		words, g.Debug, err = gmachine.ReadBinary(file)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		program, err := gmachine.AssembleProgram(path)
+		if err != nil {
+			return 0, err
+		}
+		words, g.Debug = program.Words, program.DebugInfo()
+	}
+	if len(words) > len(g.Memory) {
+		return 0, fmt.Errorf("program of %d words does not fit in %d words of memory", len(words), len(g.Memory))
+	}
+	copy(g.Memory, words)
+	return len(words), nil
+}
+
+// stty runs stty on the terminal with the given arguments.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// rawMode puts the terminal into raw mode, so that keys are read as they
+// are pressed, returning a function which restores it.
+func rawMode() (restore func(), err error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("gm debug needs a terminal: %v", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty(state) }, nil
+}
+
+func terminalSize() (rows, cols int, err error) {
+	size, err := stty("size")
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = fmt.Sscan(size, &rows, &cols)
+	return rows, cols, err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"gmachine/forth"
+	"io"
+	"log"
+	"os"
+)
+
+func runForth(args []string) {
+	flags := flag.NewFlagSet("gm forth", flag.ExitOnError)
+	flags.Parse(args)
+	inputs := []io.Reader{}
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		inputs = append(inputs, file)
+	}
+	inputs = append(inputs, os.Stdin)
+	g, err := forth.New(io.MultiReader(inputs...))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
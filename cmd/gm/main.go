@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// commands are the subcommands of gm, each given its own arguments.
+var commands = map[string]func(args []string){
+	"serve":     serve,
+	"runner":    runJobs,
+	"debug":     debug,
+	"compile":   compile,
+	"forth":     runForth,
+	"brainfuck": translateBrainfuck,
+}
+
+const usage = `Usage: gm command [arguments]
+
+The commands are:
+
+	serve      serve the web playground and its JSON API
+	runner     serve a JSON API which runs jobs for CI and other tools
+	debug      debug a program in a full-screen terminal UI
+	compile    compile a program written in G to assembly or a binary
+	forth      run Forth source files, then Forth read from stdin
+	brainfuck  translate a Brainfuck program to assembly or a binary`
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		log.Fatalf("gm: unknown command %q\n\n%s", os.Args[1], usage)
+	}
+	command(os.Args[2:])
+}
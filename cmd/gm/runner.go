@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"gmachine/runner"
+	"log"
+	"net/http"
+)
+
+func runJobs(args []string) {
+	flags := flag.NewFlagSet("gm runner", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8081", "TCP address to serve the job API on")
+	limits := runner.DefaultLimits
+	flags.IntVar(&limits.Concurrency, "concurrency", limits.Concurrency, "most jobs to run at once")
+	flags.IntVar(&limits.MemorySize, "memory", limits.MemorySize, "largest memory, in words, a job may ask for")
+	flags.IntVar(&limits.MaxSteps, "steps", limits.MaxSteps, "most instructions a job may execute")
+	flags.DurationVar(&limits.Timeout, "timeout", limits.Timeout, "longest a job may run")
+	flags.IntVar(&limits.MaxOutput, "output", limits.MaxOutput, "most bytes kept from each of a job's stdout and stderr")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		log.Fatal("Usage: gm runner [-addr host:port] [-concurrency n] [-memory words] [-steps n] [-timeout d] [-output bytes]")
+	}
+	log.Printf("running jobs posted to http://%s/v1/run", *addr)
+	log.Fatal(http.ListenAndServe(*addr, runner.NewServer(limits)))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"gmachine/playground"
+	"log"
+	"net/http"
+)
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("gm serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "TCP address to serve the playground on")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		log.Fatal("Usage: gm serve [-addr host:port]")
+	}
+	log.Printf("serving the playground at http://%s/", *addr)
+	log.Fatal(http.ListenAndServe(*addr, playground.Handler()))
+}
@@ -1,14 +1,58 @@
 package main
 
 import (
+	"flag"
 	"gmachine"
 	"log"
 	"os"
 )
 
 func main() {
-	if len(os.Args) == 1 {
-		log.Fatal("Usage: run [gbin file]\n")
+	diskPath := flag.String("disk", "", "disk image to attach as the DISK device")
+	root := flag.String("root", "", "host directory the program may access through the FILE port")
+	mapPath := flag.String("map", "", "symbol map from gasm -m, to show addresses symbolically")
+	trace := flag.Bool("trace", false, "trace each instruction to stderr")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("Usage: run [-disk image] [-root dir] [-map file] [-trace] [gbin file]\n")
 	}
-	gmachine.RunCLI(os.Args[1])
+	if err := run(*diskPath, *root, *mapPath, *trace, flag.Arg(0)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run executes the binary at path, returning rather than exiting on an
+// error so that the disk and any open files are closed first.
+func run(diskPath, root, mapPath string, trace bool, path string) error {
+	g := gmachine.New()
+	if mapPath != "" {
+		symbols, err := readSymbolMap(mapPath)
+		if err != nil {
+			return err
+		}
+		g.Symbols = symbols
+	}
+	if trace {
+		g.Trace = os.Stderr
+	}
+	g.FileRoot = root
+	defer g.CloseFiles()
+	if diskPath != "" {
+		disk, err := gmachine.OpenDisk(diskPath)
+		if err != nil {
+			return err
+		}
+		defer disk.Close()
+		g.Disk = disk
+	}
+	return g.ExecuteBinary(path)
+}
+
+func readSymbolMap(path string) (*gmachine.SymbolMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return gmachine.ReadSymbolMap(file)
 }
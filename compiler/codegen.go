@@ -0,0 +1,658 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The generated code computes each expression into A, and keeps a stack
+// in memory above the program, with I pointing at the word on top. The
+// left operand of a binary operator and the arguments of a call are pushed
+// on the stack, and so are local variables, which are found at offsets
+// from I known when the code is compiled. A function's frame holds its
+// arguments, then its return address, then its local variables and the
+// values pushed while computing.
+
+// printInt writes A to standard output in decimal. It finds the digits
+// from the last, as remainders which it makes positive by multiplying by
+// the sign, so that the most negative number prints too.
+const printInt = `
+rt_printint:
+    SAVE rt_t
+    SETAI
+    SAVE rt_sp
+    SETA 1
+    SAVE rt_sign
+    SETI rt_zero
+    LOAD rt_t
+    LESS
+    JEQ rt_printint_digits
+    SETA '-'
+    BIOS IOWRITE, STDOUT
+    SETA -1
+    SAVE rt_sign
+rt_printint_digits:
+    SETI rt_digits_end
+rt_printint_digit:
+    DECI
+    SETA 10
+    STORE
+    LOAD rt_t
+    DIVM
+    SAVE rt_q
+    LOAD rt_t
+    MODM
+    STORE
+    LOAD rt_sign
+    MULM
+    ADDA '0'
+    STORE
+    LOAD rt_q
+    SAVE rt_t
+    CMPA 0
+    JEQ rt_printint_digit
+rt_printint_write:
+    SETA [I]
+    BIOS IOWRITE, STDOUT
+    INCI
+    CMPI rt_digits_end
+    JEQ rt_printint_write
+    LOAD rt_sp
+    SETIA
+    RETN
+rt_q: .word 0
+rt_sign: .word 0
+rt_zero: .word 0
+rt_digits: .space 20
+rt_digits_end:
+`
+
+// printString writes the zero-terminated string at A to standard output.
+const printString = `
+rt_prints:
+    SAVE rt_t
+    SETAI
+    SAVE rt_sp
+    LOAD rt_t
+    SETIA
+rt_prints_loop:
+    SETA [I]
+    CMPA 0
+    JEQ rt_prints_char
+    LOAD rt_sp
+    SETIA
+    RETN
+rt_prints_char:
+    BIOS IOWRITE, STDOUT
+    INCI
+    JUMP rt_prints_loop
+`
+
+// rt_t holds the value being returned from a function, or printed, and
+// rt_sp the stack pointer while a routine uses I.
+const runtimeData = `
+rt_t: .word 0
+rt_sp: .word 0
+`
+
+// builtins gives the number of arguments each built-in function takes,
+// or -1 for print, which takes any number.
+var builtins = map[string]int{
+	"print": -1,
+	"putc":  1,
+	"getc":  0,
+}
+
+func isBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// loop records the labels of a loop being compiled, for break and
+// continue, and the depth of the stack outside it.
+type loop struct {
+	start, end string
+	depth      int
+}
+
+type generator struct {
+	c *compiler
+	// out holds the program, and code the code being generated, which is
+	// added to it a function at a time.
+	out, code strings.Builder
+	labels    int
+	funcs     map[string]*function
+	globals   map[string]bool
+	strs      map[string]string
+	printInt  bool
+	// The state of the function being compiled: the number of its
+	// parameters; depth, the number of words on the stack above its return
+	// address; and the scopes of its variables, innermost last, which give
+	// their addresses relative to its first argument.
+	params int
+	depth  int
+	scopes []map[string]int
+	loops  []loop
+	ret    string
+}
+
+func newGenerator(c *compiler) *generator {
+	return &generator{
+		c:       c,
+		funcs:   map[string]*function{},
+		globals: map[string]bool{},
+		strs:    map[string]string{},
+	}
+}
+
+func (g *generator) emit(format string, args ...interface{}) {
+	fmt.Fprintf(&g.code, "    "+format+"\n", args...)
+}
+
+func (g *generator) label(name string) {
+	fmt.Fprintf(&g.code, "%s:\n", name)
+}
+
+func (g *generator) newLabel() string {
+	g.labels++
+	return fmt.Sprintf("L%d", g.labels)
+}
+
+func (g *generator) comment(line int) {
+	fmt.Fprintf(&g.code, "    # %s:%d\n", g.c.name, line)
+}
+
+// push pushes A on the stack.
+func (g *generator) push() {
+	g.emit("INCI")
+	g.emit("STORE")
+	g.depth++
+}
+
+// drop removes n words from the stack.
+func (g *generator) drop(n int) {
+	if n > 0 {
+		g.emit("ADDI %d", -n)
+	}
+}
+
+func (g *generator) program(prog *program) error {
+	for _, v := range prog.globals {
+		if g.globals[v.name] {
+			return g.c.errorf(v.line, "%s already declared", v.name)
+		}
+		g.globals[v.name] = true
+	}
+	for _, f := range prog.funcs {
+		if isBuiltin(f.name) {
+			return g.c.errorf(f.line, "%s is a built-in function", f.name)
+		}
+		if g.funcs[f.name] != nil || g.globals[f.name] {
+			return g.c.errorf(f.line, "%s already declared", f.name)
+		}
+		g.funcs[f.name] = f
+	}
+	main := g.funcs["main"]
+	if main == nil {
+		return g.c.errorf(1, "missing function main")
+	}
+	if len(main.params) > 0 {
+		return g.c.errorf(main.line, "main must have no parameters")
+	}
+	fmt.Fprintf(&g.out, "# Compiled from %s.\n", g.c.name)
+	g.label("start")
+	g.emit("SETI rt_stack-1")
+	data := strings.Builder{}
+	for _, v := range prog.globals {
+		if n, ok := v.value.(*numberExpr); ok || v.value == nil {
+			value := int64(0)
+			if ok {
+				value = n.value
+			}
+			fmt.Fprintf(&data, "var_%s: .word %d\n", v.name, value)
+			continue
+		}
+		fmt.Fprintf(&data, "var_%s: .word 0\n", v.name)
+		g.comment(v.line)
+		if err := g.expr(v.value); err != nil {
+			return err
+		}
+		g.emit("SAVE var_%s", v.name)
+	}
+	g.emit("CALL fn_main")
+	g.emit("HALT")
+	g.flush()
+	for _, f := range prog.funcs {
+		if err := g.function(f); err != nil {
+			return err
+		}
+	}
+	if g.printInt {
+		g.out.WriteString(printInt)
+	}
+	if len(g.strs) > 0 {
+		g.out.WriteString(printString)
+	}
+	g.out.WriteString(runtimeData)
+	strs := make([]string, 0, len(g.strs))
+	for s := range g.strs {
+		strs = append(strs, s)
+	}
+	sort.Slice(strs, func(i, j int) bool {
+		return g.strs[strs[i]] < g.strs[strs[j]]
+	})
+	for _, s := range strs {
+		fmt.Fprintf(&g.out, "%s: .stringz %s\n", g.strs[s], strconv.Quote(s))
+	}
+	g.out.WriteString(data.String())
+	g.out.WriteString("rt_stack:\n")
+	return nil
+}
+
+// flush adds the code generated so far to the program.
+func (g *generator) flush() {
+	g.out.WriteString("\n")
+	g.out.WriteString(g.code.String())
+	g.code.Reset()
+}
+
+// function compiles a function, which pushes its return address on entry,
+// and on return drops it and its arguments from the stack.
+func (g *generator) function(f *function) error {
+	g.params = len(f.params)
+	g.depth = 0
+	g.ret = g.newLabel()
+	scope := map[string]int{}
+	for i, param := range f.params {
+		if _, ok := scope[param]; ok {
+			return g.c.errorf(f.line, "duplicate parameter %s", param)
+		}
+		scope[param] = i
+	}
+	g.comment(f.line)
+	g.label("fn_" + f.name)
+	g.emit("SETAN")
+	g.emit("INCI")
+	g.emit("STORE")
+	if err := g.block(f.body, scope); err != nil {
+		return err
+	}
+	g.emit("SETA 0")
+	g.label(g.ret)
+	g.emit("SAVE rt_t")
+	g.emit("SETA [I]")
+	g.emit("SETNA")
+	g.drop(g.params + 1)
+	g.emit("LOAD rt_t")
+	g.emit("RETN")
+	g.flush()
+	return nil
+}
+
+// block compiles a block, with its local variables in scope, and drops
+// them from the stack at its end. The body of a function shares the scope
+// of its parameters.
+func (g *generator) block(stmts []stmt, scope map[string]int) error {
+	g.scopes = append(g.scopes, scope)
+	depth := g.depth
+	for _, s := range stmts {
+		if err := g.stmt(s); err != nil {
+			return err
+		}
+	}
+	g.drop(g.depth - depth)
+	g.depth = depth
+	g.scopes = g.scopes[:len(g.scopes)-1]
+	return nil
+}
+
+func (g *generator) stmt(s stmt) error {
+	switch s := s.(type) {
+	case *varStmt:
+		g.comment(s.line)
+		scope := g.scopes[len(g.scopes)-1]
+		if _, ok := scope[s.name]; ok {
+			return g.c.errorf(s.line, "%s already declared", s.name)
+		}
+		if s.value == nil {
+			g.emit("SETA 0")
+		} else if err := g.expr(s.value); err != nil {
+			return err
+		}
+		g.push()
+		scope[s.name] = g.params + g.depth
+	case *assignStmt:
+		g.comment(s.line)
+		if err := g.expr(s.value); err != nil {
+			return err
+		}
+		return g.store(s.name, s.line)
+	case *ifStmt:
+		g.comment(s.line)
+		then, els, end := g.newLabel(), g.newLabel(), g.newLabel()
+		if err := g.branch(s.cond, then, els); err != nil {
+			return err
+		}
+		g.label(then)
+		if err := g.block(s.then, map[string]int{}); err != nil {
+			return err
+		}
+		g.emit("JUMP %s", end)
+		g.label(els)
+		if err := g.block(s.els, map[string]int{}); err != nil {
+			return err
+		}
+		g.label(end)
+	case *whileStmt:
+		g.comment(s.line)
+		l := loop{start: g.newLabel(), end: g.newLabel(), depth: g.depth}
+		body := g.newLabel()
+		g.label(l.start)
+		if err := g.branch(s.cond, body, l.end); err != nil {
+			return err
+		}
+		g.label(body)
+		g.loops = append(g.loops, l)
+		err := g.block(s.body, map[string]int{})
+		g.loops = g.loops[:len(g.loops)-1]
+		if err != nil {
+			return err
+		}
+		g.emit("JUMP %s", l.start)
+		g.label(l.end)
+	case *returnStmt:
+		g.comment(s.line)
+		if s.value == nil {
+			g.emit("SETA 0")
+		} else if err := g.expr(s.value); err != nil {
+			return err
+		}
+		g.drop(g.depth)
+		g.emit("JUMP %s", g.ret)
+	case *breakStmt, *continueStmt:
+		if len(g.loops) == 0 {
+			line, keyword := 0, "break"
+			switch s := s.(type) {
+			case *breakStmt:
+				line = s.line
+			case *continueStmt:
+				line, keyword = s.line, "continue"
+			}
+			return g.c.errorf(line, "%s outside loop", keyword)
+		}
+		l := g.loops[len(g.loops)-1]
+		g.drop(g.depth - l.depth)
+		if _, ok := s.(*breakStmt); ok {
+			g.emit("JUMP %s", l.end)
+		} else {
+			g.emit("JUMP %s", l.start)
+		}
+	case *exprStmt:
+		g.comment(s.line)
+		if call, ok := s.x.(*callExpr); ok {
+			return g.call(call, true)
+		}
+		return g.expr(s.x)
+	}
+	return nil
+}
+
+// branch compiles a condition, jumping to then if it is true and to els
+// if not.
+func (g *generator) branch(cond expr, then, els string) error {
+	if err := g.expr(cond); err != nil {
+		return err
+	}
+	g.emit("CMPA 0")
+	g.emit("JEQ %s", then)
+	g.emit("JUMP %s", els)
+	return nil
+}
+
+// variable finds a variable, returning its offset from I if it is local.
+func (g *generator) variable(name string, line int) (offset int, local bool, err error) {
+	for i := len(g.scopes) - 1; i >= 0; i-- {
+		if addr, ok := g.scopes[i][name]; ok {
+			return addr - g.params - g.depth, true, nil
+		}
+	}
+	switch {
+	case g.globals[name]:
+		return 0, false, nil
+	case g.funcs[name] != nil || isBuiltin(name):
+		return 0, false, g.c.errorf(line, "%s is a function", name)
+	}
+	return 0, false, g.c.errorf(line, "undefined: %s", name)
+}
+
+func (g *generator) load(name string, line int) error {
+	offset, local, err := g.variable(name, line)
+	switch {
+	case err != nil:
+		return err
+	case !local:
+		g.emit("LOAD var_%s", name)
+	case offset == 0:
+		g.emit("SETA [I]")
+	default:
+		g.emit("PEEK %d", offset)
+	}
+	return nil
+}
+
+func (g *generator) store(name string, line int) error {
+	offset, local, err := g.variable(name, line)
+	switch {
+	case err != nil:
+		return err
+	case !local:
+		g.emit("SAVE var_%s", name)
+	case offset == 0:
+		g.emit("STORE")
+	default:
+		g.emit("POKE %d", offset)
+	}
+	return nil
+}
+
+func (g *generator) expr(x expr) error {
+	switch x := x.(type) {
+	case *numberExpr:
+		g.emit("SETA %d", x.value)
+	case *stringExpr:
+		return g.c.errorf(x.line, "string %s can only be printed", strconv.Quote(x.value))
+	case *nameExpr:
+		return g.load(x.name, x.line)
+	case *callExpr:
+		return g.call(x, false)
+	case *unaryExpr:
+		if err := g.expr(x.x); err != nil {
+			return err
+		}
+		if x.op == "!" {
+			g.emit("CMPA 0")
+			g.flag(true)
+			break
+		}
+		g.emit("INCI")
+		g.emit("STORE")
+		g.emit("SETA 0")
+		g.emit("SUBM")
+		g.emit("DECI")
+	case *binaryExpr:
+		return g.binary(x)
+	}
+	return nil
+}
+
+// flag sets A to 1 if Z is set and to 0 if not, or the other way round
+// if set is false.
+func (g *generator) flag(set bool) {
+	end := g.newLabel()
+	if set {
+		g.emit("SETA 0")
+		g.emit("JEQ %s", end)
+		g.emit("SETA 1")
+	} else {
+		g.emit("SETA 1")
+		g.emit("JEQ %s", end)
+		g.emit("SETA 0")
+	}
+	g.label(end)
+}
+
+var arithmetic = map[string]string{
+	"+": "ADDM",
+	"-": "SUBM",
+	"*": "MULM",
+	"/": "DIVM",
+	"%": "MODM",
+}
+
+// binary compiles a binary operator, pushing the left operand while it
+// computes the right.
+func (g *generator) binary(x *binaryExpr) error {
+	if x.op == "&&" || x.op == "||" {
+		return g.logical(x)
+	}
+	if err := g.expr(x.x); err != nil {
+		return err
+	}
+	if n, ok := x.y.(*numberExpr); ok && (x.op == "+" || x.op == "-") {
+		value := n.value
+		if x.op == "-" {
+			value = -value
+		}
+		g.emit("ADDA %d", value)
+		return nil
+	}
+	g.push()
+	if err := g.expr(x.y); err != nil {
+		return err
+	}
+	// > and <= compare the other way round, as y < x.
+	if x.op != ">" && x.op != "<=" {
+		g.emit("XCHG")
+	}
+	switch x.op {
+	case "==", "!=":
+		g.emit("CMPM")
+	case "<", ">", "<=", ">=":
+		g.emit("LESS")
+	default:
+		g.emit(arithmetic[x.op])
+	}
+	g.emit("DECI")
+	g.depth--
+	switch x.op {
+	case "==", "<", ">":
+		g.flag(true)
+	case "!=", "<=", ">=":
+		g.flag(false)
+	}
+	return nil
+}
+
+// logical compiles && and ||, which only evaluate their right operand when
+// the left doesn't decide the result.
+func (g *generator) logical(x *binaryExpr) error {
+	right, end := g.newLabel(), g.newLabel()
+	if err := g.expr(x.x); err != nil {
+		return err
+	}
+	g.emit("CMPA 0")
+	if x.op == "&&" {
+		// A false left operand leaves A at 0, the result.
+		g.emit("JEQ %s", right)
+		g.emit("JUMP %s", end)
+		g.label(right)
+		if err := g.expr(x.y); err != nil {
+			return err
+		}
+		g.emit("CMPA 0")
+		g.flag(false)
+		g.label(end)
+		return nil
+	}
+	g.emit("JEQ %s", right)
+	if err := g.expr(x.y); err != nil {
+		return err
+	}
+	g.emit("CMPA 0")
+	g.flag(false)
+	g.emit("JUMP %s", end)
+	g.label(right)
+	g.emit("SETA 1")
+	g.label(end)
+	return nil
+}
+
+// call compiles a call, pushing the arguments for the function to drop.
+// The built-in functions print and putc have no value, so they can only
+// be called as statements.
+func (g *generator) call(x *callExpr, statement bool) error {
+	if arity, ok := builtins[x.name]; ok {
+		if arity >= 0 && len(x.args) != arity {
+			return g.c.errorf(x.line, "%s takes %d arguments, not %d", x.name, arity, len(x.args))
+		}
+		if x.name != "getc" && !statement {
+			return g.c.errorf(x.line, "%s has no value", x.name)
+		}
+	}
+	switch x.name {
+	case "getc":
+		g.emit("BIOS IOREAD, STDIN")
+		return nil
+	case "putc":
+		if err := g.expr(x.args[0]); err != nil {
+			return err
+		}
+		g.emit("BIOS IOWRITE, STDOUT")
+		return nil
+	case "print":
+		for _, arg := range x.args {
+			if s, ok := arg.(*stringExpr); ok {
+				g.emit("SETA %s", g.str(s.value))
+				g.emit("CALL rt_prints")
+				continue
+			}
+			if err := g.expr(arg); err != nil {
+				return err
+			}
+			g.printInt = true
+			g.emit("CALL rt_printint")
+		}
+		return nil
+	}
+	f := g.funcs[x.name]
+	if f == nil {
+		if _, _, err := g.variable(x.name, x.line); err == nil {
+			return g.c.errorf(x.line, "%s is not a function", x.name)
+		}
+		return g.c.errorf(x.line, "undefined: %s", x.name)
+	}
+	if len(x.args) != len(f.params) {
+		return g.c.errorf(x.line, "%s takes %d arguments, not %d", x.name, len(f.params), len(x.args))
+	}
+	for _, arg := range x.args {
+		if err := g.expr(arg); err != nil {
+			return err
+		}
+		g.push()
+	}
+	g.emit("CALL fn_%s", x.name)
+	g.depth -= len(x.args)
+	return nil
+}
+
+// str returns the label of a string literal.
+func (g *generator) str(s string) string {
+	label, ok := g.strs[s]
+	if !ok {
+		label = fmt.Sprintf("str%d", len(g.strs))
+		g.strs[s] = label
+	}
+	return label
+}
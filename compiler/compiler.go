@@ -0,0 +1,115 @@
+// Package compiler compiles programs written in G, a small C-like
+// language, to G-machine assembly.
+//
+// A program is a sequence of global variable declarations and functions,
+// and runs by calling main:
+//
+//	var count = 0;
+//
+//	func fib(n) {
+//	    if (n < 2) {
+//	        return n;
+//	    }
+//	    return fib(n - 1) + fib(n - 2);
+//	}
+//
+//	func main() {
+//	    var i = 0;
+//	    while (i < 10) {
+//	        print("fib(", i, ") = ", fib(i), "\n");
+//	        i = i + 1;
+//	    }
+//	}
+//
+// Every value is a 64-bit signed integer. Variables are declared with var,
+// and local variables are scoped to their block. The statements are
+// assignment, if and else, while, break, continue, return and calls. The
+// operators are, from the loosest binding, ||, &&, == and !=, the
+// comparisons < <= > >=, + and -, * / and %, and the unary - and !. The &&
+// and || operators evaluate their right operand only when they need to,
+// and like the comparisons give 1 for true and 0 for false; if and while
+// take any non-zero value as true. Numbers are written in decimal, in hex
+// with 0x, or as characters such as 'a' or '\n'. Comments run from // to
+// the end of the line.
+//
+// Three functions are built in. print writes each of its arguments, which
+// are numbers in decimal or string literals, to standard output. putc
+// writes a character to standard output, and getc reads one from standard
+// input, giving 0 at the end of the input.
+//
+// Functions are called with their arguments on a stack, which grows up
+// from the end of the program through the rest of memory, and may be
+// recursive. A program that runs out of stack faults when it passes the
+// end of memory.
+package compiler
+
+import (
+	"fmt"
+	"gmachine"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Error reports a problem with the source at Pos.
+type Error struct {
+	Pos gmachine.Position
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type compiler struct {
+	name string
+}
+
+func (c *compiler) errorf(line int, format string, args ...interface{}) error {
+	return &Error{Pos: gmachine.Position{File: c.name, Line: line}, Err: fmt.Errorf(format, args...)}
+}
+
+// Compile compiles the G program read from r, named name in errors, and
+// returns its assembly source.
+func Compile(name string, r io.Reader) ([]byte, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{name: name}
+	tokens, err := c.lex(string(src))
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{c: c, tokens: tokens}
+	prog, err := p.program()
+	if err != nil {
+		return nil, err
+	}
+	g := newGenerator(c)
+	if err := g.program(prog); err != nil {
+		return nil, err
+	}
+	return []byte(g.out.String()), nil
+}
+
+// CompileProgram compiles the G program read from r and assembles it. The
+// assembly source is named after name, with its extension replaced by
+// .gasm, in the program's debug info.
+func CompileProgram(name string, r io.Reader) (*gmachine.Program, error) {
+	source, err := Compile(name, r)
+	if err != nil {
+		return nil, err
+	}
+	return gmachine.AssembleProgramFromReader(AssemblyName(name), strings.NewReader(string(source)))
+}
+
+// AssemblyName returns the name of the assembly source compiled from the
+// G source file name.
+func AssemblyName(name string) string {
+	return strings.TrimSuffix(name, ".g") + ".gasm"
+}
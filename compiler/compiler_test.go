@@ -0,0 +1,234 @@
+package compiler_test
+
+import (
+	"bytes"
+	"errors"
+	"gmachine"
+	"gmachine/compiler"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// run compiles and runs a program, returning its output.
+func run(t *testing.T, name, source, stdin string) (string, error) {
+	t.Helper()
+	program, err := compiler.CompileProgram(name, strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	g.Debug = program.DebugInfo()
+	g.Stdin = strings.NewReader(stdin)
+	out := &bytes.Buffer{}
+	g.Stdout = out
+	err = g.RunProgram(program.Words)
+	return out.String(), err
+}
+
+func TestCompile(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc   string
+		source string
+		stdin  string
+		want   string
+	}{
+		{
+			desc:   "Hello",
+			source: `func main() { print("Hello, world\n"); }`,
+			want:   "Hello, world\n",
+		},
+		{
+			desc: "Arithmetic",
+			source: `func main() {
+				print(1 + 2 * 3, " ", (1 + 2) * 3, " ", 10 - 4 - 3, " ", 7 / 2, " ", -7 / 2, " ", 7 % -3, " ", -(2 - 5));
+			}`,
+			want: "7 9 3 3 -3 1 3",
+		},
+		{
+			desc: "Comparisons and logic",
+			source: `func main() {
+				print(1 < 2, 2 < 1, 2 <= 2, 3 <= 2, 3 > 2, 2 > 2, 2 >= 2, 1 >= 2, -1 < 0);
+				print(" ", 1 == 1, 1 != 1, !0, !7, 2 && 3, 2 && 0, 0 || 0, 0 || 4);
+			}`,
+			want: "101010101 10101001",
+		},
+		{
+			desc: "Extreme numbers",
+			source: `func main() {
+				print(0, " ", 9223372036854775807, " ", -9223372036854775807 - 1, " ", 0x7f, " ", 'A');
+			}`,
+			want: "0 9223372036854775807 -9223372036854775808 127 65",
+		},
+		{
+			desc: "Recursion",
+			source: `func fib(n) {
+				if (n < 2) {
+					return n;
+				}
+				return fib(n - 1) + fib(n - 2);
+			}
+			func main() { print(fib(15)); }`,
+			want: "610",
+		},
+		{
+			desc: "Arguments",
+			source: `func sub(a, b, c) { var d = a - b; return d - c; }
+			func main() { print(sub(10, sub(5, 2, 1), 3)); }`,
+			want: "5",
+		},
+		{
+			desc: "Loops",
+			source: `func main() {
+				var i = 0;
+				while (1) {
+					i = i + 1;
+					if (i > 9) {
+						break;
+					}
+					var j = i % 3;
+					if (j == 0) {
+						continue;
+					}
+					print(i);
+				}
+			}`,
+			want: "124578",
+		},
+		{
+			desc: "Else if",
+			source: `func sign(n) {
+				if (n < 0) {
+					return -1;
+				} else if (n == 0) {
+					return 0;
+				} else {
+					return 1;
+				}
+			}
+			func main() { print(sign(-5), sign(0), sign(5)); }`,
+			want: "-101",
+		},
+		{
+			desc: "Scopes",
+			source: `var x = 1;
+			func main() {
+				print(x);
+				var x = 2;
+				if (x) {
+					var x = 3;
+					print(x);
+				}
+				print(x);
+			}`,
+			want: "132",
+		},
+		{
+			desc: "Globals",
+			source: `var calls;
+			var base = twice(21);
+			func twice(n) { calls = calls + 1; return n * 2; }
+			func main() { print(base, " ", twice(base), " ", calls); }`,
+			want: "42 84 2",
+		},
+		{
+			desc: "Short circuit",
+			source: `func say(n) { print(n); return n; }
+			func main() {
+				var a = say(0) && say(1);
+				var b = say(2) || say(3);
+				print(" ", a, b);
+			}`,
+			want: "02 01",
+		},
+		{
+			desc: "Echo input",
+			source: `func main() {
+				var c = getc();
+				while (c) {
+					if (c >= 'a' && c <= 'z') {
+						c = c - 'a' + 'A';
+					}
+					putc(c);
+					c = getc();
+				}
+			}`,
+			stdin: "Hello, G!\n",
+			want:  "HELLO, G!\n",
+		},
+	}
+	for _, tC := range testCases {
+		got, err := run(t, "main.g", tC.source, tC.stdin)
+		if err != nil {
+			t.Errorf("%s: %v", tC.desc, err)
+		}
+		if tC.want != got {
+			t.Errorf("%s: want output %q, got %q", tC.desc, tC.want, got)
+		}
+	}
+}
+
+func TestCompileFile(t *testing.T) {
+	t.Parallel()
+	source, err := ioutil.ReadFile("testdata/primes.g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := run(t, "testdata/primes.g", string(source), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2 3 5 7 11 13 17 19 23 29 31 37 41 43 47 53 59 61 67 71 73 79 83 89 97\n25 primes\n"
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestCompileFault(t *testing.T) {
+	t.Parallel()
+	_, err := run(t, "div.g", "func main() {\n var zero = 0;\n print(1 / zero);\n}", "")
+	var fault *gmachine.Fault
+	if !errors.As(err, &fault) || fault.Reason != "division by zero" || !strings.HasPrefix(fault.Location, "div.gasm:") {
+		t.Errorf("want division by zero fault in div.gasm, got %v", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc   string
+		source string
+		want   string
+	}{
+		{desc: "No main", source: "func f() {}", want: "main.g:1: missing function main"},
+		{desc: "Main parameters", source: "func main(x) {}", want: "main.g:1: main must have no parameters"},
+		{desc: "Syntax", source: "func main() {\n  print(1 +);\n}", want: `main.g:2: expected expression, found )`},
+		{desc: "Missing brace", source: "func main() {\n", want: `main.g:2: expected "}", found end of file`},
+		{desc: "Keyword as name", source: "func main() { var while; }", want: "main.g:1: expected name, found while"},
+		{desc: "Bad character", source: "func main() { @ }", want: `main.g:1: unexpected character '@'`},
+		{desc: "Unterminated string", source: "func main() {\n print(\"hi);\n}", want: `main.g:2: unterminated " quote`},
+		{desc: "Undefined variable", source: "func main() {\n\n x = 1;\n}", want: "main.g:3: undefined: x"},
+		{desc: "Undefined function", source: "func main() { f(); }", want: "main.g:1: undefined: f"},
+		{desc: "Not a function", source: "var v; func main() { v(); }", want: "main.g:1: v is not a function"},
+		{desc: "Function as variable", source: "func main() { print(main); }", want: "main.g:1: main is a function"},
+		{desc: "Wrong arguments", source: "func f(a, b) {} func main() { f(1); }", want: "main.g:1: f takes 2 arguments, not 1"},
+		{desc: "Builtin arguments", source: "func main() { putc(); }", want: "main.g:1: putc takes 1 arguments, not 0"},
+		{desc: "Builtin value", source: "func main() { var x = print(1); }", want: "main.g:1: print has no value"},
+		{desc: "String value", source: `func main() { var s = "hi"; }`, want: `main.g:1: string "hi" can only be printed`},
+		{desc: "Redeclared", source: "func main() { var a; var a; }", want: "main.g:1: a already declared"},
+		{desc: "Parameter redeclared", source: "func f(a) { var a; } func main() {}", want: "main.g:1: a already declared"},
+		{desc: "Duplicate parameter", source: "func f(a, a) {} func main() {}", want: "main.g:1: duplicate parameter a"},
+		{desc: "Duplicate function", source: "func main() {}\nfunc main() {}", want: "main.g:2: main already declared"},
+		{desc: "Builtin redefined", source: "func getc() {} func main() {}", want: "main.g:1: getc is a built-in function"},
+		{desc: "Break outside loop", source: "func main() { break; }", want: "main.g:1: break outside loop"},
+		{desc: "Continue outside loop", source: "func main() { continue; }", want: "main.g:1: continue outside loop"},
+	}
+	for _, tC := range testCases {
+		_, err := compiler.Compile("main.g", strings.NewReader(tC.source))
+		var compileErr *compiler.Error
+		if !errors.As(err, &compileErr) || tC.want != err.Error() {
+			t.Errorf("%s: want error %q, got %v", tC.desc, tC.want, err)
+		}
+	}
+}
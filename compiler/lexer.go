@@ -0,0 +1,121 @@
+package compiler
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	// text is the token as written, except for strings, where it is the
+	// unquoted value.
+	text  string
+	value int64
+	line  int
+}
+
+// puncts are the operators and punctuation, longest first.
+var puncts = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "=", "(", ")", "{", "}", ",", ";",
+}
+
+// lex splits source into tokens, ending with a tokenEOF.
+func (c *compiler) lex(src string) ([]token, error) {
+	tokens := []token{}
+	line := 1
+	for i := 0; i < len(src); {
+		r := rune(src[i])
+		switch {
+		case r == '\n':
+			line++
+			i++
+			continue
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		}
+		start := i
+		switch {
+		case isIdentStart(r):
+			for i < len(src) && (isIdentStart(rune(src[i])) || isDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: src[start:i], line: line})
+		case isDigit(r):
+			for i < len(src) && (isIdentStart(rune(src[i])) || isDigit(rune(src[i]))) {
+				i++
+			}
+			value, err := strconv.ParseInt(src[start:i], 0, 64)
+			if err != nil {
+				return nil, c.errorf(line, "invalid number %s", src[start:i])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: src[start:i], value: value, line: line})
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(src) && src[end] != src[i] && src[end] != '\n' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) || src[end] != src[i] {
+				return nil, c.errorf(line, "unterminated %c quote", r)
+			}
+			i = end + 1
+			text := src[start:i]
+			if r == '"' {
+				s, err := strconv.Unquote(text)
+				if err != nil {
+					return nil, c.errorf(line, "invalid string %s", text)
+				}
+				tokens = append(tokens, token{kind: tokenString, text: s, line: line})
+				break
+			}
+			value, _, tail, err := strconv.UnquoteChar(text[1:len(text)-1], '\'')
+			if err != nil || tail != "" {
+				return nil, c.errorf(line, "invalid character %s", text)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, value: int64(value), line: line})
+		default:
+			for _, punct := range puncts {
+				if strings.HasPrefix(src[i:], punct) {
+					i += len(punct)
+					tokens = append(tokens, token{kind: tokenPunct, text: punct, line: line})
+					break
+				}
+			}
+			if i == start {
+				r, _ := utf8.DecodeRuneInString(src[i:])
+				return nil, c.errorf(line, "unexpected character %q", r)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, text: "end of file", line: line}), nil
+}
+
+// Identifiers are ASCII, so that they can be used in assembler labels.
+func isIdentStart(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_'
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
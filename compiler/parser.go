@@ -0,0 +1,421 @@
+package compiler
+
+import "strconv"
+
+type program struct {
+	globals []*varStmt
+	funcs   []*function
+}
+
+type function struct {
+	name   string
+	params []string
+	body   []stmt
+	line   int
+}
+
+type stmt interface{}
+
+type varStmt struct {
+	name string
+	// value is nil when the variable is declared without one, and starts
+	// at zero.
+	value expr
+	line  int
+}
+
+type assignStmt struct {
+	name  string
+	value expr
+	line  int
+}
+
+type ifStmt struct {
+	cond      expr
+	then, els []stmt
+	line      int
+}
+
+type whileStmt struct {
+	cond expr
+	body []stmt
+	line int
+}
+
+type returnStmt struct {
+	// value is nil for a bare return, which returns zero.
+	value expr
+	line  int
+}
+
+type breakStmt struct {
+	line int
+}
+
+type continueStmt struct {
+	line int
+}
+
+type exprStmt struct {
+	x    expr
+	line int
+}
+
+type expr interface{}
+
+type numberExpr struct {
+	value int64
+}
+
+type stringExpr struct {
+	value string
+	line  int
+}
+
+type nameExpr struct {
+	name string
+	line int
+}
+
+type callExpr struct {
+	name string
+	args []expr
+	line int
+}
+
+type unaryExpr struct {
+	op   string
+	x    expr
+	line int
+}
+
+type binaryExpr struct {
+	op   string
+	x, y expr
+	line int
+}
+
+// precedence gives the binding strength of each binary operator.
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+var keywords = map[string]bool{
+	"var": true, "func": true, "if": true, "else": true, "while": true,
+	"return": true, "break": true, "continue": true,
+}
+
+type parser struct {
+	c      *compiler
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// is reports whether the next token is the punctuation or keyword text.
+func (p *parser) is(text string) bool {
+	tok := p.peek()
+	return (tok.kind == tokenPunct || tok.kind == tokenIdent) && tok.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.is(text) {
+		return p.unexpected("expected " + strconv.Quote(text))
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	tok := p.peek()
+	found := tok.text
+	if tok.kind == tokenString {
+		found = strconv.Quote(tok.text)
+	}
+	return p.c.errorf(tok.line, "%s, found %s", want, found)
+}
+
+func (p *parser) name() (string, error) {
+	tok := p.peek()
+	if tok.kind != tokenIdent || keywords[tok.text] {
+		return "", p.unexpected("expected name")
+	}
+	p.next()
+	return tok.text, nil
+}
+
+func (p *parser) program() (*program, error) {
+	prog := &program{}
+	for p.peek().kind != tokenEOF {
+		switch {
+		case p.is("var"):
+			v, err := p.varStmt()
+			if err != nil {
+				return nil, err
+			}
+			prog.globals = append(prog.globals, v)
+		case p.is("func"):
+			f, err := p.function()
+			if err != nil {
+				return nil, err
+			}
+			prog.funcs = append(prog.funcs, f)
+		default:
+			return nil, p.unexpected("expected var or func")
+		}
+	}
+	return prog, nil
+}
+
+func (p *parser) function() (*function, error) {
+	f := &function{line: p.next().line}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.is(")") {
+		if len(f.params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		param, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		f.params = append(f.params, param)
+	}
+	p.next()
+	if f.body, err = p.block(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) block() ([]stmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	stmts := []stmt{}
+	for !p.is("}") {
+		if p.peek().kind == tokenEOF {
+			return nil, p.unexpected(`expected "}"`)
+		}
+		s, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+	p.next()
+	return stmts, nil
+}
+
+func (p *parser) stmt() (stmt, error) {
+	line := p.peek().line
+	switch {
+	case p.is("var"):
+		return p.varStmt()
+	case p.is("if"):
+		return p.ifStmt()
+	case p.is("while"):
+		p.next()
+		cond, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &whileStmt{cond: cond, body: body, line: line}, nil
+	case p.is("return"):
+		p.next()
+		s := &returnStmt{line: line}
+		if !p.is(";") {
+			value, err := p.expr(1)
+			if err != nil {
+				return nil, err
+			}
+			s.value = value
+		}
+		return s, p.expect(";")
+	case p.is("break"):
+		p.next()
+		return &breakStmt{line: line}, p.expect(";")
+	case p.is("continue"):
+		p.next()
+		return &continueStmt{line: line}, p.expect(";")
+	}
+	if p.peek().kind == tokenIdent && p.tokens[p.pos+1].text == "=" {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		p.next()
+		value, err := p.expr(1)
+		if err != nil {
+			return nil, err
+		}
+		return &assignStmt{name: name, value: value, line: line}, p.expect(";")
+	}
+	x, err := p.expr(1)
+	if err != nil {
+		return nil, err
+	}
+	return &exprStmt{x: x, line: line}, p.expect(";")
+}
+
+func (p *parser) varStmt() (*varStmt, error) {
+	v := &varStmt{line: p.next().line}
+	var err error
+	if v.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.is("=") {
+		p.next()
+		if v.value, err = p.expr(1); err != nil {
+			return nil, err
+		}
+	}
+	return v, p.expect(";")
+}
+
+func (p *parser) ifStmt() (*ifStmt, error) {
+	s := &ifStmt{line: p.next().line}
+	var err error
+	if s.cond, err = p.condition(); err != nil {
+		return nil, err
+	}
+	if s.then, err = p.block(); err != nil {
+		return nil, err
+	}
+	if !p.is("else") {
+		return s, nil
+	}
+	p.next()
+	if p.is("if") {
+		elseIf, err := p.ifStmt()
+		if err != nil {
+			return nil, err
+		}
+		s.els = []stmt{elseIf}
+		return s, nil
+	}
+	s.els, err = p.block()
+	return s, err
+}
+
+// condition parses the parenthesized condition of an if or while.
+func (p *parser) condition() (expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	cond, err := p.expr(1)
+	if err != nil {
+		return nil, err
+	}
+	return cond, p.expect(")")
+}
+
+// expr parses an expression whose binary operators bind at least as
+// strongly as min.
+func (p *parser) expr(min int) (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		prec, ok := precedence[tok.text]
+		if tok.kind != tokenPunct || !ok || prec < min {
+			return x, nil
+		}
+		p.next()
+		y, err := p.expr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: tok.text, x: x, y: y, line: tok.line}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	tok := p.peek()
+	if p.is("-") || p.is("!") {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if n, ok := x.(*numberExpr); ok && tok.text == "-" {
+			return &numberExpr{value: -n.value}, nil
+		}
+		return &unaryExpr{op: tok.text, x: x, line: tok.line}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokenNumber:
+		p.next()
+		return &numberExpr{value: tok.value}, nil
+	case tokenString:
+		p.next()
+		return &stringExpr{value: tok.text, line: tok.line}, nil
+	case tokenIdent:
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is("(") {
+			return &nameExpr{name: name, line: tok.line}, nil
+		}
+		p.next()
+		call := &callExpr{name: name, line: tok.line}
+		for !p.is(")") {
+			if len(call.args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.expr(1)
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		p.next()
+		return call, nil
+	}
+	if p.is("(") {
+		p.next()
+		x, err := p.expr(1)
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	}
+	return nil, p.unexpected("expected expression")
+}
@@ -0,0 +1,32 @@
+// Prints the primes below 100, found by trial division.
+
+var found = 0;
+
+func prime(n) {
+    if (n < 2) {
+        return 0;
+    }
+    var d = 2;
+    while (d * d <= n) {
+        if (n % d == 0) {
+            return 0;
+        }
+        d = d + 1;
+    }
+    return 1;
+}
+
+func main() {
+    var n = 2;
+    while (n < 100) {
+        if (prime(n)) {
+            if (found > 0) {
+                putc(' ');
+            }
+            print(n);
+            found = found + 1;
+        }
+        n = n + 1;
+    }
+    print("\n", found, " primes\n");
+}
@@ -0,0 +1,402 @@
+// Package dap implements a Debug Adapter Protocol server, so that editors
+// such as VS Code can debug G-machine programs: launching them, stopping at
+// breakpoints set on source lines, stepping, and inspecting registers and
+// memory.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gmachine"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// threadID identifies the machine's single thread of execution.
+const threadID = 1
+
+// maxMessageSize is the largest message accepted from the client.
+const maxMessageSize = 1 << 20
+
+// Server is a debug adapter communicating over a pair of streams, such as
+// stdin and stdout, with the protocol's Content-Length framing.
+type Server struct {
+	in *bufio.Reader
+
+	// writeMu guards out and seq, since events are also sent while the
+	// program runs.
+	writeMu sync.Mutex
+	out     io.Writer
+	seq     int
+
+	// mu guards the debugging session, which a running program updates
+	// from another goroutine.
+	mu      sync.Mutex
+	session *session
+	// breakpoints holds the lines of the breakpoints requested for each
+	// source file, by absolute path.
+	breakpoints map[string][]int
+	// pause is set to ask a running program to stop.
+	pause   int32
+	running sync.WaitGroup
+}
+
+// NewServer returns a server which reads requests from r and writes
+// responses and events to w.
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		in:          bufio.NewReader(r),
+		out:         w,
+		breakpoints: map[string][]int{},
+	}
+}
+
+// Serve handles requests until the client disconnects, or the input ends.
+func (s *Server) Serve() error {
+	defer s.stop()
+	for {
+		req, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		body, after, err := s.handle(req)
+		resp := &message{
+			Type:       "response",
+			RequestSeq: req.Seq,
+			Command:    req.Command,
+			Success:    err == nil,
+			Body:       body,
+		}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		if err := s.send(resp); err != nil {
+			return err
+		}
+		if after != nil {
+			after()
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+func (s *Server) read() (*message, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %v", err)
+	}
+	if length < 0 || length > maxMessageSize {
+		return nil, fmt.Errorf("invalid Content-Length: %d is not between 0 and %d", length, maxMessageSize)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *Server) send(msg *message) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	msg.Seq = s.seq
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *Server) event(name string, body interface{}) {
+	s.send(&message{Type: "event", Event: name, Body: body})
+}
+
+// outputWriter collects a program's output, to be forwarded to the client
+// as output events once the instruction writing it is done.
+type outputWriter struct {
+	output   *[]OutputEvent
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	*w.output = append(*w.output, OutputEvent{Category: w.category, Output: string(p)})
+	return len(p), nil
+}
+
+var errNotLaunched = errors.New("no program has been launched")
+
+// handle carries out a request, returning the body of the response and,
+// optionally, a function to call once the response has been sent.
+func (s *Server) handle(req *message) (interface{}, func(), error) {
+	decode := func(args interface{}) error {
+		if len(req.Arguments) == 0 {
+			return nil
+		}
+		return json.Unmarshal(req.Arguments, args)
+	}
+	switch req.Command {
+	case "initialize":
+		return Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsTerminateRequest:         true,
+		}, nil, nil
+	case "launch":
+		args := LaunchArguments{}
+		if err := decode(&args); err != nil {
+			return nil, nil, err
+		}
+		sess, err := s.launch(args)
+		if err != nil {
+			return nil, nil, err
+		}
+		// A new launch replaces any program already being debugged.
+		s.stop()
+		s.mu.Lock()
+		s.session = sess
+		s.mu.Unlock()
+		return nil, func() { s.event("initialized", nil) }, nil
+	case "setBreakpoints":
+		args := SetBreakpointsArguments{}
+		if err := decode(&args); err != nil {
+			return nil, nil, err
+		}
+		return s.setBreakpoints(args), nil, nil
+	case "setExceptionBreakpoints":
+		return map[string]interface{}{}, nil, nil
+	case "configurationDone":
+		s.mu.Lock()
+		sess := s.session
+		s.mu.Unlock()
+		if sess == nil {
+			return nil, nil, errNotLaunched
+		}
+		if sess.stopOnEntry {
+			return nil, func() { s.stopped("entry", "") }, nil
+		}
+		// resume checks for breakpoints after each instruction, so one on
+		// the entry instruction has to be checked before running.
+		s.mu.Lock()
+		atBreakpoint := sess.breakpoints[sess.g.P]
+		s.mu.Unlock()
+		if atBreakpoint {
+			return nil, func() { s.stopped("breakpoint", "") }, nil
+		}
+		return nil, func() { s.resume(nil) }, nil
+	case "threads":
+		return map[string]interface{}{
+			"threads": []Thread{{ID: threadID, Name: "G-machine"}},
+		}, nil, nil
+	case "continue":
+		if err := s.checkStopped(); err != nil {
+			return nil, nil, err
+		}
+		return map[string]interface{}{"allThreadsContinued": true}, func() { s.resume(nil) }, nil
+	case "next", "stepIn", "stepOut":
+		if err := s.checkStopped(); err != nil {
+			return nil, nil, err
+		}
+		s.mu.Lock()
+		until := s.session.stepCondition(req.Command)
+		s.mu.Unlock()
+		return nil, func() { s.resume(until) }, nil
+	case "pause":
+		atomic.StoreInt32(&s.pause, 1)
+		return nil, nil, nil
+	case "stackTrace":
+		if err := s.checkStopped(); err != nil {
+			return nil, nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		frames := s.session.stackTrace()
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil, nil
+	case "scopes":
+		return map[string]interface{}{
+			"scopes": []Scope{
+				{Name: "Registers", VariablesReference: registersReference},
+				{Name: "Memory", VariablesReference: memoryReference, Expensive: true},
+			},
+		}, nil, nil
+	case "variables":
+		if err := s.checkStopped(); err != nil {
+			return nil, nil, err
+		}
+		args := VariablesArguments{}
+		if err := decode(&args); err != nil {
+			return nil, nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return map[string]interface{}{"variables": s.session.variables(args.VariablesReference)}, nil, nil
+	case "terminate":
+		s.stop()
+		return nil, func() { s.event("terminated", nil) }, nil
+	case "disconnect":
+		s.stop()
+		return nil, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// checkStopped reports an error unless a launched program is stopped.
+func (s *Server) checkStopped() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.session == nil:
+		return errNotLaunched
+	case s.session.running:
+		return errors.New("the program is running")
+	case s.session.finished:
+		return errors.New("the program has finished")
+	}
+	return nil
+}
+
+// resume runs the program in the background until it reaches a
+// breakpoint, is paused, finishes, or until returns true after one of its
+// instructions. A nil until runs the program freely.
+func (s *Server) resume(until func(pc gmachine.Word) bool) {
+	s.mu.Lock()
+	sess := s.session
+	if sess == nil || sess.running || sess.finished {
+		s.mu.Unlock()
+		return
+	}
+	if sess.fault != nil {
+		// A faulting instruction can't be resumed, so end the program.
+		sess.finished = true
+		s.mu.Unlock()
+		s.exited(sess)
+		return
+	}
+	sess.running = true
+	s.mu.Unlock()
+	atomic.StoreInt32(&s.pause, 0)
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		for {
+			reason, description, finished := s.step(sess, until)
+			if reason == "" && !finished {
+				continue
+			}
+			s.mu.Lock()
+			sess.running = false
+			sess.finished = finished
+			s.mu.Unlock()
+			if finished {
+				s.exited(sess)
+				return
+			}
+			s.stopped(reason, description)
+			return
+		}
+	}()
+}
+
+// step executes one instruction of a running program, returning the
+// reason to stop, if any, or whether the program has finished.
+func (s *Server) step(sess *session, until func(pc gmachine.Word) bool) (reason, description string, finished bool) {
+	if atomic.LoadInt32(&s.pause) != 0 {
+		return "pause", "", false
+	}
+	s.mu.Lock()
+	defer func() {
+		output := sess.output
+		sess.output = nil
+		s.mu.Unlock()
+		for _, out := range output {
+			s.event("output", out)
+		}
+	}()
+	pc := sess.g.P
+	running, err := sess.g.Step()
+	switch {
+	case err != nil:
+		sess.fault = err
+		return "exception", err.Error(), false
+	case !running:
+		return "", "", true
+	case sess.breakpoints[sess.g.P]:
+		return "breakpoint", "", false
+	case until != nil && until(pc):
+		return "step", "", false
+	}
+	return "", "", false
+}
+
+// exited reports that the program has ended, with exit code 0 if it
+// halted and 1 if it faulted.
+func (s *Server) exited(sess *session) {
+	s.mu.Lock()
+	code := 0
+	if sess.fault != nil {
+		code = 1
+	}
+	s.mu.Unlock()
+	s.event("exited", ExitedEvent{ExitCode: code})
+	s.event("terminated", nil)
+}
+
+func (s *Server) stopped(reason, description string) {
+	s.event("stopped", StoppedEvent{
+		Reason:            reason,
+		Description:       description,
+		Text:              description,
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+	})
+}
+
+// stop pauses a running program and waits for it to stop.
+func (s *Server) stop() {
+	atomic.StoreInt32(&s.pause, 1)
+	s.running.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.session != nil {
+		s.session.finished = true
+		s.session.close()
+	}
+}
+
+// setBreakpoints replaces the breakpoints of a source file, reporting
+// which could be placed on code.
+func (s *Server) setBreakpoints(args SetBreakpointsArguments) map[string]interface{} {
+	path := absPath(args.Source.Path)
+	lines := []int{}
+	for _, bp := range args.Breakpoints {
+		lines = append(lines, bp.Line)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakpoints[path] = lines
+	results := make([]Breakpoint, len(lines))
+	for i, line := range lines {
+		results[i] = Breakpoint{Line: line, Message: "no program has been launched yet"}
+	}
+	if s.session != nil {
+		results = s.session.setBreakpoints(s.breakpoints, path)
+	}
+	return map[string]interface{}{"breakpoints": results}
+}
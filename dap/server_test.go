@@ -0,0 +1,400 @@
+package dap_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"gmachine/dap"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const program = "../testdata/dap/prog.gasm"
+
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client drives a server through pipes, collecting the events it sends.
+type client struct {
+	t      *testing.T
+	w      *io.PipeWriter
+	r      *bufio.Reader
+	seq    int
+	events []message
+	// out holds the program output received so far.
+	out  strings.Builder
+	done chan error
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		err := dap.NewServer(inR, outW).Serve()
+		outW.Close()
+		c.done <- err
+	}()
+	t.Cleanup(func() {
+		inW.Close()
+		go io.Copy(io.Discard, outR)
+		if err := <-c.done; err != nil {
+			t.Error(err)
+		}
+	})
+	return c
+}
+
+func (c *client) read() message {
+	c.t.Helper()
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatal(err)
+	}
+	msg := message{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	if msg.Event == "output" {
+		out := dap.OutputEvent{}
+		json.Unmarshal(msg.Body, &out)
+		c.out.WriteString(out.Output)
+	}
+	return msg
+}
+
+// request sends a request and returns its response, decoding the body
+// into result if it isn't nil.
+func (c *client) request(command string, args interface{}, result interface{}) message {
+	c.t.Helper()
+	c.seq++
+	body, err := json.Marshal(map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": args,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	// Write without blocking, since the server may be sending events
+	// which must be read first.
+	go fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	for {
+		msg := c.read()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg.RequestSeq != c.seq {
+			c.t.Fatalf("want response to request %d, got %+v", c.seq, msg)
+		}
+		if result != nil && msg.Success {
+			if err := json.Unmarshal(msg.Body, result); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return msg
+	}
+}
+
+// event returns the next event with the given name, skipping others.
+func (c *client) event(name string) message {
+	c.t.Helper()
+	for {
+		var msg message
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.read()
+		}
+		if msg.Event == name {
+			return msg
+		}
+	}
+}
+
+// output returns the program output in the events received so far.
+func (c *client) output() string {
+	return c.out.String()
+}
+
+// launch starts the test program with breakpoints on the given lines.
+func (c *client) launch(stopOnEntry bool, lines ...int) []dap.Breakpoint {
+	c.t.Helper()
+	if resp := c.request("initialize", map[string]interface{}{"adapterID": "gmachine"}, nil); !resp.Success {
+		c.t.Fatal(resp.Message)
+	}
+	if resp := c.request("launch", dap.LaunchArguments{Program: program, StopOnEntry: stopOnEntry}, nil); !resp.Success {
+		c.t.Fatal(resp.Message)
+	}
+	c.event("initialized")
+	breakpoints := []dap.SourceBreakpoint{}
+	for _, line := range lines {
+		breakpoints = append(breakpoints, dap.SourceBreakpoint{Line: line})
+	}
+	abs, _ := filepath.Abs(program)
+	result := struct{ Breakpoints []dap.Breakpoint }{}
+	c.request("setBreakpoints", dap.SetBreakpointsArguments{
+		Source:      dap.Source{Path: abs},
+		Breakpoints: breakpoints,
+	}, &result)
+	c.request("configurationDone", nil, nil)
+	return result.Breakpoints
+}
+
+func (c *client) stopped() dap.StoppedEvent {
+	c.t.Helper()
+	ev := dap.StoppedEvent{}
+	json.Unmarshal(c.event("stopped").Body, &ev)
+	return ev
+}
+
+func (c *client) stack() []dap.StackFrame {
+	c.t.Helper()
+	result := struct{ StackFrames []dap.StackFrame }{}
+	resp := c.request("stackTrace", map[string]interface{}{"threadId": 1}, &result)
+	if !resp.Success {
+		c.t.Fatal(resp.Message)
+	}
+	return result.StackFrames
+}
+
+func (c *client) line() int {
+	c.t.Helper()
+	return c.stack()[0].Line
+}
+
+func (c *client) registers() map[string]string {
+	c.t.Helper()
+	result := struct{ Variables []dap.Variable }{}
+	c.request("variables", dap.VariablesArguments{VariablesReference: 1}, &result)
+	registers := map[string]string{}
+	for _, v := range result.Variables {
+		registers[v.Name] = v.Value
+	}
+	return registers
+}
+
+func TestRunToCompletion(t *testing.T) {
+	t.Parallel()
+	c := newClient(t)
+	c.launch(false)
+	exited := dap.ExitedEvent{ExitCode: -1}
+	json.Unmarshal(c.event("exited").Body, &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("want exit code 0, got %d", exited.ExitCode)
+	}
+	c.event("terminated")
+	if want, got := "Hi", c.output(); want != got {
+		t.Errorf("want output %q, got %q", want, got)
+	}
+	c.request("disconnect", nil, nil)
+}
+
+func TestBreakpoints(t *testing.T) {
+	t.Parallel()
+	c := newClient(t)
+	breakpoints := c.launch(false, 10, 20)
+	if len(breakpoints) != 2 || !breakpoints[0].Verified || breakpoints[0].Line != 10 {
+		t.Fatalf("want verified breakpoint at line 10, got %+v", breakpoints)
+	}
+	if breakpoints[1].Verified {
+		t.Errorf("want breakpoint past the end unverified, got %+v", breakpoints[1])
+	}
+	for _, want := range []string{"72", "105"} {
+		if ev := c.stopped(); ev.Reason != "breakpoint" {
+			t.Fatalf("want stop at breakpoint, got %+v", ev)
+		}
+		frames := c.stack()
+		if len(frames) != 2 || frames[0].Line != 10 || frames[0].Name != "more" || frames[1].Line != 3 {
+			t.Errorf("want frames at more and its caller, got %+v", frames)
+		}
+		if got := c.registers()["A"]; want != got {
+			t.Errorf("want A=%s, got %s", want, got)
+		}
+		c.request("continue", map[string]interface{}{"threadId": 1}, nil)
+	}
+	c.event("terminated")
+}
+
+func TestBreakpointOnEntry(t *testing.T) {
+	t.Parallel()
+	c := newClient(t)
+	breakpoints := c.launch(false, 1)
+	if len(breakpoints) != 1 || !breakpoints[0].Verified || breakpoints[0].Line != 2 {
+		t.Fatalf("want verified breakpoint at line 2, got %+v", breakpoints)
+	}
+	if ev := c.stopped(); ev.Reason != "breakpoint" {
+		t.Fatalf("want stop at breakpoint, got %+v", ev)
+	}
+	if got := c.line(); got != 2 {
+		t.Errorf("want stop at line 2, got %d", got)
+	}
+	c.request("continue", map[string]interface{}{"threadId": 1}, nil)
+	c.event("terminated")
+	if want, got := "Hi", c.output(); want != got {
+		t.Errorf("want output %q, got %q", want, got)
+	}
+}
+
+func TestRelaunch(t *testing.T) {
+	t.Parallel()
+	c := newClient(t)
+	c.launch(false, 10)
+	if ev := c.stopped(); ev.Reason != "breakpoint" {
+		t.Fatalf("want stop at breakpoint, got %+v", ev)
+	}
+	c.launch(true)
+	if ev := c.stopped(); ev.Reason != "entry" {
+		t.Fatalf("want new program stopped on entry, got %+v", ev)
+	}
+	if got := c.line(); got != 2 {
+		t.Errorf("want new program at line 2, got %d", got)
+	}
+	c.request("continue", map[string]interface{}{"threadId": 1}, nil)
+	exited := dap.ExitedEvent{ExitCode: -1}
+	json.Unmarshal(c.event("exited").Body, &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("want exit code 0, got %d", exited.ExitCode)
+	}
+	c.event("terminated")
+	if want, got := "Hi", c.output(); want != got {
+		t.Errorf("want output %q, got %q", want, got)
+	}
+}
+
+func TestInvalidContentLength(t *testing.T) {
+	t.Parallel()
+	for _, length := range []string{"-1", "1099511627776", "lots"} {
+		in := strings.NewReader("Content-Length: " + length + "\r\n\r\n{}")
+		err := dap.NewServer(in, io.Discard).Serve()
+		if err == nil || !strings.Contains(err.Error(), "invalid Content-Length") {
+			t.Errorf("%s: want invalid Content-Length error, got %v", length, err)
+		}
+	}
+}
+
+func TestStepping(t *testing.T) {
+	t.Parallel()
+	c := newClient(t)
+	c.launch(true)
+	if ev := c.stopped(); ev.Reason != "entry" {
+		t.Fatalf("want stop on entry, got %+v", ev)
+	}
+	steps := []struct {
+		command  string
+		wantLine int
+	}{
+		{command: "next", wantLine: 3},
+		{command: "next", wantLine: 4},
+	}
+	for _, step := range steps {
+		c.request(step.command, map[string]interface{}{"threadId": 1}, nil)
+		c.stopped()
+		if got := c.line(); step.wantLine != got {
+			t.Errorf("after %s: want line %d, got %d", step.command, step.wantLine, got)
+		}
+	}
+	if want, got := "Hi", c.output(); want != got {
+		t.Errorf("want output %q after stepping over the call, got %q", want, got)
+	}
+}
+
+func TestStepInAndOut(t *testing.T) {
+	t.Parallel()
+	c := newClient(t)
+	c.launch(true)
+	c.stopped()
+	steps := []struct {
+		command  string
+		wantLine int
+	}{
+		{command: "stepIn", wantLine: 3},
+		{command: "stepIn", wantLine: 6},
+		{command: "stepIn", wantLine: 7},
+		{command: "stepOut", wantLine: 4},
+	}
+	for _, step := range steps {
+		c.request(step.command, map[string]interface{}{"threadId": 1}, nil)
+		if ev := c.stopped(); ev.Reason != "step" {
+			t.Fatalf("want step stop, got %+v", ev)
+		}
+		if got := c.line(); step.wantLine != got {
+			t.Errorf("after %s: want line %d, got %d", step.command, step.wantLine, got)
+		}
+	}
+}
+
+func TestMemoryVariables(t *testing.T) {
+	t.Parallel()
+	c := newClient(t)
+	c.launch(true)
+	c.stopped()
+	scopes := struct{ Scopes []dap.Scope }{}
+	c.request("scopes", map[string]interface{}{"frameId": 1}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[1].Name != "Memory" {
+		t.Fatalf("want registers and memory scopes, got %+v", scopes.Scopes)
+	}
+	blocks := struct{ Variables []dap.Variable }{}
+	c.request("variables", dap.VariablesArguments{VariablesReference: scopes.Scopes[1].VariablesReference}, &blocks)
+	if len(blocks.Variables) != 16 {
+		t.Fatalf("want 16 memory blocks, got %d", len(blocks.Variables))
+	}
+	words := struct{ Variables []dap.Variable }{}
+	c.request("variables", dap.VariablesArguments{VariablesReference: blocks.Variables[0].VariablesReference}, &words)
+	if got := words.Variables[0]; got.Name != "0000 start" || got.Value != "13" {
+		t.Errorf("want first word SETI labelled start, got %+v", got)
+	}
+}
+
+func TestFault(t *testing.T) {
+	t.Parallel()
+	c := newClient(t)
+	c.request("initialize", nil, nil)
+	resp := c.request("launch", dap.LaunchArguments{Program: "../testdata/listing/count.gasm"}, nil)
+	if !resp.Success {
+		t.Fatal(resp.Message)
+	}
+	c.request("configurationDone", nil, nil)
+	ev := c.stopped()
+	want := "fault at ../testdata/listing/count.gasm:14 (bad): invalid opcode 99"
+	if ev.Reason != "exception" || ev.Description != want {
+		t.Errorf("want exception %q, got %+v", want, ev)
+	}
+	c.request("continue", map[string]interface{}{"threadId": 1}, nil)
+	exited := dap.ExitedEvent{}
+	json.Unmarshal(c.event("exited").Body, &exited)
+	if exited.ExitCode != 1 {
+		t.Errorf("want exit code 1, got %d", exited.ExitCode)
+	}
+}
+
+func TestLaunchErrors(t *testing.T) {
+	t.Parallel()
+	c := newClient(t)
+	if resp := c.request("launch", dap.LaunchArguments{Program: "nonexistent.gasm"}, nil); resp.Success {
+		t.Error("want launch of missing program to fail")
+	}
+	if resp := c.request("continue", nil, nil); resp.Success {
+		t.Error("want continue before launch to fail")
+	}
+}
@@ -0,0 +1,274 @@
+package dap
+
+import (
+	"fmt"
+	"gmachine"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// References of the variable containers shown by the scopes request.
+// Memory is divided into blocks, each with its own reference starting at
+// memoryBlockReference.
+const (
+	registersReference   = 1
+	memoryReference      = 2
+	memoryBlockReference = 1000
+	memoryBlockSize      = 64
+)
+
+// session is a program being debugged.
+type session struct {
+	g           *gmachine.GMachine
+	debug       *gmachine.DebugInfo
+	disk        *gmachine.Disk
+	stopOnEntry bool
+	// breakpoints holds the addresses at which to stop.
+	breakpoints map[gmachine.Word]bool
+	running     bool
+	finished    bool
+	// fault is the fault which stopped the program, if any.
+	fault error
+	// output holds the output of the instruction being executed.
+	output []OutputEvent
+}
+
+// launch loads a program onto a new machine, with its output forwarded to
+// the client.
+func (s *Server) launch(args LaunchArguments) (*session, error) {
+	if args.Program == "" {
+		return nil, fmt.Errorf("launch: missing program")
+	}
+	var words []gmachine.Word
+	var debug *gmachine.DebugInfo
+	if strings.HasSuffix(args.Program, ".gbin") {
+		file, err := os.Open(args.Program)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		words, debug, err = gmachine.ReadBinary(file)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		program, err := gmachine.AssembleProgram(args.Program)
+		if err != nil {
+			return nil, err
+		}
+		words, debug = program.Words, program.DebugInfo()
+	}
+	g := gmachine.New()
+	if len(words) > len(g.Memory) {
+		return nil, fmt.Errorf("program of %d words does not fit in %d words of memory", len(words), len(g.Memory))
+	}
+	copy(g.Memory, words)
+	g.Debug = debug
+	g.Stdin = strings.NewReader("")
+	g.FileRoot = args.Root
+	sess := &session{
+		g:           g,
+		debug:       debug,
+		stopOnEntry: args.StopOnEntry,
+		breakpoints: map[gmachine.Word]bool{},
+	}
+	g.Stdout = outputWriter{output: &sess.output, category: "stdout"}
+	g.Stderr = outputWriter{output: &sess.output, category: "stderr"}
+	if args.Disk != "" {
+		disk, err := gmachine.OpenDisk(args.Disk)
+		if err != nil {
+			return nil, err
+		}
+		g.Disk = disk
+		sess.disk = disk
+	}
+	s.mu.Lock()
+	sess.setBreakpoints(s.breakpoints, "")
+	s.mu.Unlock()
+	return sess, nil
+}
+
+func (sess *session) close() {
+	sess.g.CloseFiles()
+	if sess.disk != nil {
+		sess.disk.Close()
+		sess.disk = nil
+	}
+}
+
+// setBreakpoints places the requested breakpoints on the first address of
+// each line, or of the next line with code, returning the results for the
+// source file at path.
+func (sess *session) setBreakpoints(requested map[string][]int, path string) []Breakpoint {
+	results := []Breakpoint{}
+	sess.breakpoints = map[gmachine.Word]bool{}
+	for file, lines := range requested {
+		for _, line := range lines {
+			bp := Breakpoint{Line: line, Message: "no code at or after this line"}
+			if addr, at, ok := sess.lineAddress(file, line); ok {
+				sess.breakpoints[addr] = true
+				bp = Breakpoint{Verified: true, Line: at}
+			}
+			if file == path {
+				bp.Source = &Source{Name: filepath.Base(path), Path: path}
+				results = append(results, bp)
+			}
+		}
+	}
+	return results
+}
+
+// lineAddress returns the first address assembled from the given line of
+// a source file, or from the nearest line after it.
+func (sess *session) lineAddress(path string, line int) (gmachine.Word, int, bool) {
+	if sess.debug == nil {
+		return 0, 0, false
+	}
+	found := false
+	var best gmachine.DebugLine
+	for _, l := range sess.debug.Lines {
+		if absPath(l.Pos.File) != path || l.Pos.Line < line {
+			continue
+		}
+		if !found || l.Pos.Line < best.Pos.Line || (l.Pos.Line == best.Pos.Line && l.Addr < best.Addr) {
+			best = l
+			found = true
+		}
+	}
+	return best.Addr, best.Pos.Line, found
+}
+
+func (sess *session) line(addr gmachine.Word) (gmachine.Position, bool) {
+	if sess.debug == nil {
+		return gmachine.Position{}, false
+	}
+	return sess.debug.Line(addr)
+}
+
+// stepCondition returns the condition for a step request to stop, given
+// the address of each instruction executed. Without debug info, steps are
+// by instruction rather than by line.
+func (sess *session) stepCondition(command string) func(pc gmachine.Word) bool {
+	g := sess.g
+	start, _ := sess.line(g.P)
+	changedLine := func() bool {
+		if sess.debug == nil {
+			return true
+		}
+		pos, ok := sess.line(g.P)
+		return ok && pos != start
+	}
+	switch command {
+	case "stepIn":
+		return func(gmachine.Word) bool {
+			return changedLine()
+		}
+	case "next":
+		// Returns holds the return addresses of the calls being stepped
+		// over.
+		returns := []gmachine.Word{}
+		return func(pc gmachine.Word) bool {
+			if g.Memory[pc] == gmachine.CALL && g.P != pc+2 {
+				returns = append(returns, pc+2)
+			}
+			if n := len(returns); n > 0 && g.P == returns[n-1] {
+				returns = returns[:n-1]
+			}
+			return len(returns) == 0 && changedLine()
+		}
+	}
+	// stepOut runs until the current routine returns.
+	return func(pc gmachine.Word) bool {
+		return g.Memory[pc] == gmachine.RETN
+	}
+}
+
+func (sess *session) frame(id int, addr gmachine.Word) StackFrame {
+	frame := StackFrame{
+		ID:                          id,
+		Name:                        fmt.Sprintf("P=%d", addr),
+		Column:                      1,
+		InstructionPointerReference: fmt.Sprintf("%d", addr),
+	}
+	if sess.debug != nil && sess.debug.Symbols != nil {
+		if name := sess.debug.Symbols.Lookup(addr); name != "" {
+			frame.Name = name
+		}
+	}
+	if pos, ok := sess.line(addr); ok {
+		path := absPath(pos.File)
+		frame.Source = &Source{Name: filepath.Base(path), Path: path}
+		frame.Line = pos.Line
+	}
+	return frame
+}
+
+// stackTrace returns the current instruction and, inside a routine, the
+// CALL which it will return to, since the machine keeps a single return
+// address in N.
+func (sess *session) stackTrace() []StackFrame {
+	frames := []StackFrame{sess.frame(1, sess.g.P)}
+	if sess.g.N >= 2 {
+		frames = append(frames, sess.frame(2, sess.g.N-2))
+	}
+	return frames
+}
+
+func (sess *session) variables(ref int) []Variable {
+	g := sess.g
+	word := func(name string, w gmachine.Word) Variable {
+		return Variable{Name: name, Value: fmt.Sprintf("%d", w)}
+	}
+	switch {
+	case ref == registersReference:
+		return []Variable{
+			word("A", g.A),
+			word("I", g.I),
+			word("N", g.N),
+			word("P", g.P),
+			word("E", g.E),
+			{Name: "Z", Value: fmt.Sprintf("%t", g.FlagZ)},
+			word("V", g.V),
+			word("M", g.M),
+			{Name: "interrupts", Value: fmt.Sprintf("%t", g.FlagI)},
+			word("cycles", g.Cycles),
+		}
+	case ref == memoryReference:
+		vars := []Variable{}
+		for start := 0; start < len(g.Memory); start += memoryBlockSize {
+			end := start + memoryBlockSize
+			if end > len(g.Memory) {
+				end = len(g.Memory)
+			}
+			vars = append(vars, Variable{
+				Name:               fmt.Sprintf("%04d-%04d", start, end-1),
+				Value:              fmt.Sprintf("%d words", end-start),
+				VariablesReference: memoryBlockReference + start/memoryBlockSize,
+			})
+		}
+		return vars
+	case ref >= memoryBlockReference:
+		start := (ref - memoryBlockReference) * memoryBlockSize
+		vars := []Variable{}
+		for addr := start; addr < start+memoryBlockSize && addr < len(g.Memory); addr++ {
+			name := fmt.Sprintf("%04d", addr)
+			if sess.debug != nil && sess.debug.Symbols != nil {
+				if label := sess.debug.Symbols.Lookup(gmachine.Word(addr)); label != "" && !strings.Contains(label, "+") {
+					name += " " + label
+				}
+			}
+			vars = append(vars, word(name, g.Memory[addr]))
+		}
+		return vars
+	}
+	return []Variable{}
+}
+
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
@@ -0,0 +1,123 @@
+package gmachine
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// binaryMagic identifies a binary carrying debug info. Binaries without it
+// are plain sequences of words.
+var binaryMagic = []byte("GBIN\x00\x00\x00\x01")
+
+// DebugInfo relates the words of a binary back to the source, so that
+// faults and traces can show where an address came from.
+type DebugInfo struct {
+	Lines   []DebugLine
+	Symbols *SymbolMap
+}
+
+// DebugLine records that the Len words starting at Addr were assembled
+// from the source line at Pos.
+type DebugLine struct {
+	Addr Word
+	Len  Word
+	Pos  Position
+}
+
+// Line returns the source position of the word at addr.
+func (d *DebugInfo) Line(addr Word) (Position, bool) {
+	i := sort.Search(len(d.Lines), func(i int) bool {
+		return d.Lines[i].Addr+d.Lines[i].Len > addr
+	})
+	if i == len(d.Lines) || d.Lines[i].Addr > addr {
+		return Position{}, false
+	}
+	return d.Lines[i].Pos, true
+}
+
+// DebugInfo returns the debug info for the program, built from its listing
+// and symbol map.
+func (p *Program) DebugInfo() *DebugInfo {
+	d := &DebugInfo{Symbols: p.Symbols}
+	for _, line := range p.Listing {
+		d.Lines = append(d.Lines, DebugLine{
+			Addr: line.Addr,
+			Len:  Word(len(line.Words)),
+			Pos:  line.Pos,
+		})
+	}
+	return d
+}
+
+// WriteBinary writes a program as a binary. Without debug info this is the
+// plain format written by WriteWords; otherwise the binary starts with a
+// magic number, followed by the code and then the debug info, all as
+// big-endian words, with strings stored as a length and bytes.
+func WriteBinary(w io.Writer, words []Word, debug *DebugInfo) error {
+	if debug == nil {
+		return WriteWords(w, words)
+	}
+	ow := &objectWriter{w: w}
+	ow.bytes(binaryMagic)
+	ow.word(Word(len(words)))
+	for _, word := range words {
+		ow.word(word)
+	}
+	ow.word(Word(len(debug.Lines)))
+	for _, line := range debug.Lines {
+		ow.word(line.Addr)
+		ow.word(line.Len)
+		ow.string(line.Pos.File)
+		ow.word(Word(line.Pos.Line))
+	}
+	symbols := []Symbol{}
+	if debug.Symbols != nil {
+		symbols = debug.Symbols.Symbols
+	}
+	ow.word(Word(len(symbols)))
+	for _, sym := range symbols {
+		ow.string(sym.Name)
+		ow.word(sym.Value)
+		var flags Word
+		if sym.Label {
+			flags |= 1
+		}
+		ow.word(flags)
+	}
+	return ow.err
+}
+
+// ReadBinary reads a binary written by WriteBinary, returning its debug
+// info, or nil for a plain binary.
+func ReadBinary(r io.Reader) ([]Word, *DebugInfo, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.HasPrefix(data, binaryMagic) {
+		words, err := ReadWords(bytes.NewReader(data))
+		return words, nil, err
+	}
+	or := &objectReader{r: bytes.NewReader(data[len(binaryMagic):])}
+	words := or.words()
+	debug := &DebugInfo{Lines: []DebugLine{}}
+	for n := or.count(); len(debug.Lines) < n && or.err == nil; {
+		line := DebugLine{Addr: or.word(), Len: or.word()}
+		line.Pos.File = or.string()
+		line.Pos.Line = int(or.word())
+		debug.Lines = append(debug.Lines, line)
+	}
+	symbols := []Symbol{}
+	for n := or.count(); len(symbols) < n && or.err == nil; {
+		sym := Symbol{Name: or.string(), Value: or.word()}
+		sym.Label = or.word()&1 != 0
+		symbols = append(symbols, sym)
+	}
+	if or.err != nil {
+		return nil, nil, or.err
+	}
+	debug.Symbols = newSymbolMap(symbols)
+	return words, debug, nil
+}
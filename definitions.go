@@ -0,0 +1,71 @@
+package gmachine
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// Kinds of Definition.
+const (
+	DefinitionLabel = iota
+	DefinitionConstant
+	DefinitionMacro
+)
+
+// Definition is a label, constant or macro defined in source, found by
+// Definitions. Line is 1-based, and Col is the 0-based byte offset of the
+// name in the line. Detail is the value expression of a constant, or the
+// parameters of a macro.
+type Definition struct {
+	Name   string
+	Kind   int
+	Line   int
+	Col    int
+	Detail string
+}
+
+// Definitions scans source for the labels, constants and macros which it
+// defines. It doesn't assemble the source, so it works on incomplete
+// programs, as editors need; lines which can't be lexed are skipped.
+func Definitions(src []byte) []Definition {
+	defs := []Definition{}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		fields, _, err := splitLine(text)
+		if err != nil {
+			continue
+		}
+		cols := make([]int, len(fields))
+		offset := 0
+		for i, field := range fields {
+			cols[i] = offset + strings.Index(text[offset:], field)
+			offset = cols[i] + len(field)
+		}
+		for i, field := range fields {
+			def := Definition{Line: line}
+			switch {
+			case isLabel(field):
+				def.Name = strings.TrimSuffix(field, ":")
+				def.Kind = DefinitionLabel
+				def.Col = cols[i]
+			case (field == ".equ" || field == ".macro") && i+1 < len(fields):
+				def.Name = fields[i+1]
+				def.Kind = DefinitionConstant
+				if field == ".macro" {
+					def.Kind = DefinitionMacro
+				}
+				def.Col = cols[i+1]
+				def.Detail = strings.Join(fields[i+2:], " ")
+			default:
+				continue
+			}
+			defs = append(defs, def)
+			if def.Kind != DefinitionLabel {
+				break
+			}
+		}
+	}
+	return defs
+}
@@ -0,0 +1,41 @@
+package gmachine
+
+import (
+	"fmt"
+	"strings"
+)
+
+var mnemonics = map[Word]string{}
+
+func init() {
+	for name, instruction := range TranslateTable {
+		mnemonics[instruction.Opcode] = name
+	}
+}
+
+// Disassemble returns the assembly text of the instruction at addr in
+// memory, and the number of words it occupies. Words which are not valid
+// opcodes disassemble as data.
+func Disassemble(memory []Word, addr Word) (string, int) {
+	if addr >= Word(len(memory)) {
+		return "", 0
+	}
+	opcode := memory[addr]
+	if opcode == SETAM {
+		return "SETA [I]", 1
+	}
+	name, ok := mnemonics[opcode]
+	if !ok {
+		return fmt.Sprintf(".word %d", opcode), 1
+	}
+	operands := TranslateTable[name].Operands
+	text := []string{name}
+	for i := 1; i <= operands; i++ {
+		if addr+Word(i) >= Word(len(memory)) {
+			text = append(text, "?")
+			continue
+		}
+		text = append(text, fmt.Sprintf("%d", memory[addr+Word(i)]))
+	}
+	return strings.Join(text, " "), 1 + operands
+}
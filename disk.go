@@ -0,0 +1,158 @@
+package gmachine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// BlockSize is the number of words transferred by a single disk read or
+// write. Disk images store each word as 8 big-endian bytes, so a block
+// occupies 512 bytes of the image file.
+const BlockSize = 64
+
+const blockBytes = BlockSize * 8
+
+var ErrInvalidBlock = errors.New("invalid block number")
+
+var ErrShortBuffer = errors.New("buffer is smaller than a block")
+
+// Disk is a virtual block storage device backed by a disk image file.
+type Disk struct {
+	file   *os.File
+	blocks Word
+	block  Word
+}
+
+// CreateDisk creates a zero-filled disk image at path holding the given
+// number of blocks.
+func CreateDisk(path string, blocks int) error {
+	if blocks <= 0 {
+		return fmt.Errorf("invalid number of blocks %d", blocks)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Truncate(int64(blocks) * blockBytes)
+}
+
+// OpenDisk opens an existing disk image for reading and writing.
+func OpenDisk(path string) (*Disk, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size()%blockBytes != 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s: size %d is not a multiple of the block size %d", path, info.Size(), blockBytes)
+	}
+	return &Disk{
+		file:   file,
+		blocks: Word(info.Size() / blockBytes),
+	}, nil
+}
+
+// Blocks returns the number of blocks in the disk image.
+func (d *Disk) Blocks() Word {
+	return d.blocks
+}
+
+// Block returns the block which the next read or write will transfer.
+func (d *Disk) Block() Word {
+	return d.block
+}
+
+// Seek sets the block which the next read or write will transfer.
+func (d *Disk) Seek(block Word) error {
+	if block >= d.blocks {
+		return ErrInvalidBlock
+	}
+	d.block = block
+	return nil
+}
+
+// ReadBlock reads the current block into buf, which must hold BlockSize
+// words, and advances to the next block.
+func (d *Disk) ReadBlock(buf []Word) error {
+	if len(buf) < BlockSize {
+		return ErrShortBuffer
+	}
+	if d.block >= d.blocks {
+		return ErrInvalidBlock
+	}
+	rawBytes := make([]byte, blockBytes)
+	_, err := d.file.ReadAt(rawBytes, int64(d.block)*blockBytes)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for i := range buf[:BlockSize] {
+		buf[i] = Word(binary.BigEndian.Uint64(rawBytes[i*8:]))
+	}
+	d.block++
+	return nil
+}
+
+// WriteBlock writes the first BlockSize words of buf to the current block,
+// and advances to the next block.
+func (d *Disk) WriteBlock(buf []Word) error {
+	if len(buf) < BlockSize {
+		return ErrShortBuffer
+	}
+	if d.block >= d.blocks {
+		return ErrInvalidBlock
+	}
+	rawBytes := make([]byte, blockBytes)
+	for i, word := range buf[:BlockSize] {
+		binary.BigEndian.PutUint64(rawBytes[i*8:], uint64(word))
+	}
+	_, err := d.file.WriteAt(rawBytes, int64(d.block)*blockBytes)
+	if err != nil {
+		return err
+	}
+	d.block++
+	return nil
+}
+
+// Close closes the underlying disk image file.
+func (d *Disk) Close() error {
+	return d.file.Close()
+}
+
+// diskOperation performs a disk BIOS operation. The block number for
+// DiskSeek is taken from A, and reads and writes transfer BlockSize words
+// starting at the memory address in I. The result is reported in E.
+func (g *GMachine) diskOperation(operation Word) {
+	if g.Disk == nil {
+		g.E = ErrorNoDevice
+		return
+	}
+	var err error
+	switch operation {
+	case DiskSeek:
+		err = g.Disk.Seek(g.A)
+	case DiskRead, DiskWrite:
+		if g.I > Word(len(g.Memory)) || Word(len(g.Memory))-g.I < BlockSize {
+			g.E = ErrorInvalid
+			return
+		}
+		buf := g.Memory[g.I : g.I+BlockSize]
+		if operation == DiskRead {
+			err = g.Disk.ReadBlock(buf)
+		} else {
+			err = g.Disk.WriteBlock(buf)
+		}
+	default:
+		g.E = ErrorInvalid
+		return
+	}
+	g.E = errorCode(err)
+}
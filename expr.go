@@ -0,0 +1,284 @@
+package gmachine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrOverflow       = errors.New("arithmetic overflow")
+	ErrDivisionByZero = errors.New("division by zero")
+)
+
+// UndefinedSymbolError reports a reference to a symbol which is neither a
+// label nor a constant.
+type UndefinedSymbolError struct {
+	Name string
+}
+
+func (e *UndefinedSymbolError) Error() string {
+	return fmt.Sprintf("undefined symbol %q", e.Name)
+}
+
+// binaryOperators lists the binary operators by precedence, loosest first,
+// as in Go and C.
+var binaryOperators = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+type exprParser struct {
+	text   string
+	pos    int
+	lookup func(name string) (Word, bool)
+}
+
+// EvalExpression evaluates a constant expression such as `(END-START)/2`
+// or `'A'|0x80`. Operands are numbers in any Go integer literal syntax,
+// character literals, and symbols resolved with lookup. Arithmetic is
+// signed 64-bit, and overflow is an error.
+func EvalExpression(text string, lookup func(name string) (Word, bool)) (Word, error) {
+	p := &exprParser{text: text, lookup: lookup}
+	value, err := p.parse(0)
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.text) {
+		return 0, fmt.Errorf("unexpected %q in expression %q", p.text[p.pos:], text)
+	}
+	return Word(value), nil
+}
+
+func (p *exprParser) parse(level int) (int64, error) {
+	if level == len(binaryOperators) {
+		return p.unary()
+	}
+	left, err := p.parse(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.operator(binaryOperators[level])
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.parse(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		left, err = apply(op, left, right)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// operator consumes and returns the next token if it is one of ops.
+func (p *exprParser) operator(ops []string) string {
+	p.skipSpace()
+	for _, op := range ops {
+		if !strings.HasPrefix(p.text[p.pos:], op) {
+			continue
+		}
+		p.pos += len(op)
+		return op
+	}
+	return ""
+}
+
+func (p *exprParser) unary() (int64, error) {
+	switch op := p.operator([]string{"-", "+", "~"}); op {
+	case "-":
+		value, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		if value == math.MinInt64 {
+			return 0, ErrOverflow
+		}
+		return -value, nil
+	case "+":
+		return p.unary()
+	case "~":
+		value, err := p.unary()
+		return ^value, err
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (int64, error) {
+	p.skipSpace()
+	if p.pos >= len(p.text) {
+		return 0, fmt.Errorf("missing operand in expression %q", p.text)
+	}
+	start := p.pos
+	r := rune(p.text[p.pos])
+	switch {
+	case r == '(':
+		p.pos++
+		value, err := p.parse(0)
+		if err != nil {
+			return 0, err
+		}
+		if p.operator([]string{")"}) == "" {
+			return 0, fmt.Errorf("missing ) in expression %q", p.text)
+		}
+		return value, nil
+	case r == '\'':
+		value, _, tail, err := strconv.UnquoteChar(p.text[p.pos+1:], '\'')
+		if err != nil || !strings.HasPrefix(tail, "'") {
+			return 0, fmt.Errorf("invalid character literal in expression %q", p.text)
+		}
+		p.pos = len(p.text) - len(tail) + 1
+		return int64(value), nil
+	case unicode.IsDigit(r):
+		for p.pos < len(p.text) && isIdentifierRune(rune(p.text[p.pos])) {
+			p.pos++
+		}
+		return parseNumber(p.text[start:p.pos])
+	case unicode.IsLetter(r) || r == '_':
+		for p.pos < len(p.text) && isIdentifierRune(rune(p.text[p.pos])) {
+			p.pos++
+		}
+		name := p.text[start:p.pos]
+		word, ok := p.lookup(name)
+		if !ok {
+			return 0, &UndefinedSymbolError{Name: name}
+		}
+		return int64(word), nil
+	}
+	return 0, fmt.Errorf("unexpected %q in expression %q", p.text[p.pos:], p.text)
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.text) && unicode.IsSpace(rune(p.text[p.pos])) {
+		p.pos++
+	}
+}
+
+// parseNumber parses a literal in any Go integer syntax. Literals too big
+// for int64 but within uint64 keep their bit pattern.
+func parseNumber(text string) (int64, error) {
+	value, err := strconv.ParseInt(text, 0, 64)
+	if err == nil {
+		return value, nil
+	}
+	unsigned, uerr := strconv.ParseUint(text, 0, 64)
+	if uerr == nil {
+		return int64(unsigned), nil
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, ErrOverflow
+	}
+	return 0, fmt.Errorf("invalid number %q", text)
+}
+
+func apply(op string, left, right int64) (int64, error) {
+	switch op {
+	case "|":
+		return left | right, nil
+	case "^":
+		return left ^ right, nil
+	case "&":
+		return left & right, nil
+	case "<<", ">>":
+		if right < 0 || right > 63 {
+			return 0, fmt.Errorf("invalid shift count %d", right)
+		}
+		if op == ">>" {
+			return left >> uint(right), nil
+		}
+		if left != left<<uint(right)>>uint(right) {
+			return 0, ErrOverflow
+		}
+		return left << uint(right), nil
+	case "+":
+		sum := left + right
+		if (right > 0 && sum < left) || (right < 0 && sum > left) {
+			return 0, ErrOverflow
+		}
+		return sum, nil
+	case "-":
+		difference := left - right
+		if (right > 0 && difference > left) || (right < 0 && difference < left) {
+			return 0, ErrOverflow
+		}
+		return difference, nil
+	case "*":
+		if left == 0 || right == 0 {
+			return 0, nil
+		}
+		product := left * right
+		if product/right != left || (left == -1 && right == math.MinInt64) || (right == -1 && left == math.MinInt64) {
+			return 0, ErrOverflow
+		}
+		return product, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, ErrDivisionByZero
+		}
+		if left == math.MinInt64 && right == -1 {
+			return 0, ErrOverflow
+		}
+		if op == "/" {
+			return left / right, nil
+		}
+		return left % right, nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", op)
+}
+
+// replaceIdentifiers returns text with each identifier outside character
+// and string literals replaced by the result of replace.
+func replaceIdentifiers(text string, replace func(name string) string) string {
+	b := strings.Builder{}
+	for pos := 0; pos < len(text); {
+		r := rune(text[pos])
+		switch {
+		case r == '\'' || r == '"':
+			end := pos + 1
+			for end < len(text) && rune(text[end]) != r {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(text) {
+				end++
+			}
+			b.WriteString(text[pos:end])
+			pos = end
+		case unicode.IsLetter(r) || r == '_':
+			end := pos
+			for end < len(text) && isIdentifierRune(rune(text[end])) {
+				end++
+			}
+			b.WriteString(replace(text[pos:end]))
+			pos = end
+		case unicode.IsDigit(r):
+			end := pos
+			for end < len(text) && isIdentifierRune(rune(text[end])) {
+				end++
+			}
+			b.WriteString(text[pos:end])
+			pos = end
+		default:
+			b.WriteByte(text[pos])
+			pos++
+		}
+	}
+	return b.String()
+}
+
+func isIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '@' || r == '.'
+}
@@ -0,0 +1,76 @@
+package gmachine
+
+import (
+	"fmt"
+	"io"
+)
+
+// Fault reports an instruction which the machine could not execute. Run
+// stops at the first fault.
+type Fault struct {
+	// P is the address of the faulting instruction, and Location is that
+	// address formatted with Location.
+	P        Word
+	Location string
+	Reason   string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("fault at %s: %s", f.Location, f.Reason)
+}
+
+// fault aborts the current instruction. Run recovers the fault and returns
+// it as an error.
+func (g *GMachine) fault(format string, args ...interface{}) {
+	panic(&Fault{
+		P:        g.pc,
+		Location: g.Location(g.pc),
+		Reason:   fmt.Sprintf(format, args...),
+	})
+}
+
+// load returns the word at addr, faulting if it is outside memory.
+func (g *GMachine) load(addr Word) Word {
+	if addr >= Word(len(g.Memory)) {
+		g.fault("memory address %d out of range", addr)
+	}
+	return g.Memory[addr]
+}
+
+// store writes word to addr, faulting if it is outside memory.
+func (g *GMachine) store(addr, word Word) {
+	if addr >= Word(len(g.Memory)) {
+		g.fault("memory address %d out of range", addr)
+	}
+	g.Memory[addr] = word
+}
+
+// Location formats an address for faults and traces. It shows the source
+// line when debug info is loaded, or otherwise the address itself, followed
+// by the symbolic form when there is one: "prog.gasm:12 (loop+3)" rather
+// than "P=17".
+func (g *GMachine) Location(addr Word) string {
+	location := fmt.Sprintf("P=%d", addr)
+	symbols := g.Symbols
+	if g.Debug != nil {
+		if pos, ok := g.Debug.Line(addr); ok {
+			location = pos.String()
+		}
+		if symbols == nil {
+			symbols = g.Debug.Symbols
+		}
+	}
+	if symbols != nil {
+		if name := symbols.Lookup(addr); name != "" {
+			location += fmt.Sprintf(" (%s)", name)
+		}
+	}
+	return location
+}
+
+// trace writes the instruction about to execute, and the registers, to
+// w.
+func (g *GMachine) trace(w io.Writer) {
+	text, _ := Disassemble(g.Memory, g.pc)
+	fmt.Fprintf(w, "%-24s %-20s A=%d I=%d N=%d E=%d Z=%t\n", g.Location(g.pc)+":", text, g.A, g.I, g.N, g.E, g.FlagZ)
+}
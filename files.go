@@ -0,0 +1,172 @@
+package gmachine
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Modes for FileOpen, passed in the A register.
+const (
+	FileModeRead = iota
+	FileModeWrite
+	FileModeAppend
+)
+
+// firstFileDescriptor is the lowest descriptor handed out by FileOpen;
+// the lower numbers mirror the standard ports.
+const firstFileDescriptor = 3
+
+var ErrOutsideRoot = errors.New("path is outside the file root")
+
+// fileOperation performs a file BIOS operation. FileOpen takes the
+// zero-terminated path from memory at I and the mode from A, and returns
+// the new descriptor in A. The other operations take the descriptor from A:
+// FileRead reads one byte into memory at I, FileWrite writes the word at I
+// as one byte, and FileClose closes the descriptor. The result is reported
+// in E.
+func (g *GMachine) fileOperation(operation Word) {
+	if g.FileRoot == "" {
+		g.E = ErrorNoDevice
+		return
+	}
+	if operation == FileOpen {
+		g.fileOpen()
+		return
+	}
+	file, ok := g.files[g.A]
+	if !ok {
+		g.E = ErrorBadDescriptor
+		return
+	}
+	if operation != FileClose && g.I >= Word(len(g.Memory)) {
+		g.E = ErrorInvalid
+		return
+	}
+	var err error
+	switch operation {
+	case FileRead:
+		b := make([]byte, 1)
+		_, err = io.ReadFull(file, b)
+		if err == nil {
+			g.Memory[g.I] = Word(b[0])
+		}
+	case FileWrite:
+		_, err = file.Write([]byte{byte(g.Memory[g.I])})
+	case FileClose:
+		delete(g.files, g.A)
+		err = file.Close()
+	default:
+		g.E = ErrorInvalid
+		return
+	}
+	g.E = errorCode(err)
+}
+
+func (g *GMachine) fileOpen() {
+	name, ok := g.readString(g.I)
+	if !ok {
+		g.E = ErrorInvalid
+		return
+	}
+	path, err := g.sandboxPath(name)
+	if err != nil {
+		g.E = errorCode(err)
+		return
+	}
+	var flag int
+	switch g.A {
+	case FileModeRead:
+		flag = os.O_RDONLY
+	case FileModeWrite:
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case FileModeAppend:
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	default:
+		g.E = ErrorInvalid
+		return
+	}
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		g.E = errorCode(err)
+		return
+	}
+	if g.files == nil {
+		g.files = map[Word]*os.File{}
+	}
+	fd := Word(firstFileDescriptor)
+	for g.files[fd] != nil {
+		fd++
+	}
+	g.files[fd] = file
+	g.A = fd
+	g.E = ErrorNone
+}
+
+// sandboxPath resolves a program-supplied path against FileRoot, refusing
+// anything that would escape it, including through symbolic links.
+func (g *GMachine) sandboxPath(name string) (string, error) {
+	root, err := filepath.EvalSymlinks(g.FileRoot)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(root, filepath.Clean(string(filepath.Separator)+name))
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		// A file that doesn't exist yet may be created, but not through a
+		// dangling symbolic link, which could point anywhere.
+		if _, err := os.Lstat(path); err == nil {
+			return "", ErrOutsideRoot
+		}
+		dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return "", err
+		}
+		resolved = filepath.Join(dir, filepath.Base(path))
+	} else if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrOutsideRoot
+	}
+	return resolved, nil
+}
+
+// readString reads a zero-terminated string from memory starting at addr.
+func (g *GMachine) readString(addr Word) (string, bool) {
+	b := strings.Builder{}
+	for ; addr < Word(len(g.Memory)); addr++ {
+		if g.Memory[addr] == 0 {
+			return b.String(), true
+		}
+		b.WriteRune(rune(g.Memory[addr]))
+	}
+	return "", false
+}
+
+// CloseFiles closes every file the program left open.
+func (g *GMachine) CloseFiles() {
+	for fd, file := range g.files {
+		file.Close()
+		delete(g.files, fd)
+	}
+}
+
+func errorCode(err error) Word {
+	switch {
+	case err == nil:
+		return ErrorNone
+	case err == io.EOF, err == io.ErrUnexpectedEOF:
+		return ErrorEOF
+	case errors.Is(err, ErrInvalidBlock):
+		return ErrorInvalid
+	case errors.Is(err, ErrOutsideRoot), os.IsPermission(err):
+		return ErrorPermission
+	case os.IsNotExist(err):
+		return ErrorNotFound
+	}
+	return ErrorIO
+}
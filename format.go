@@ -0,0 +1,235 @@
+package gmachine
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Columns of the canonical source layout: labels start in column zero,
+// everything else is indented to the code column, and operands start
+// formatIndent columns further on.
+const formatIndent = 8
+
+// formatLine is one output line: a label, or code, or both, and possibly
+// a comment.
+type formatLine struct {
+	label   string
+	fields  []string
+	comment string
+	// indented is set for comment-only lines which were indented in the
+	// source.
+	indented bool
+}
+
+// Format reformats assembly source in the canonical style: labels in
+// column zero, one instruction per line with its mnemonic in upper case,
+// operands aligned and separated by commas, and trailing comments aligned
+// within each block of lines. Comments and single blank lines between
+// blocks are kept.
+func Format(src []byte) ([]byte, error) {
+	blocks := [][]formatLine{}
+	block := []formatLine{}
+	// missing counts the operands which the last instruction still needs,
+	// to be taken from the start of the next line.
+	missing := 0
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		fields, comment, err := splitLine(text)
+		if err != nil {
+			return nil, token{pos: Position{Line: line}, line: text}.wrap(err)
+		}
+		if len(fields) == 0 && comment == "" {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+				block = []formatLine{}
+			}
+			missing = 0
+			continue
+		}
+		if missing > 0 && len(block) > 0 {
+			last := &block[len(block)-1]
+			n := 0
+			for n < missing && n < len(fields) && isOperand(fields[n]) {
+				n++
+			}
+			if n > 0 && last.comment == "" {
+				last.fields = append(last.fields, fields[:n]...)
+				fields = fields[n:]
+				missing -= n
+			}
+			if len(fields) == 0 {
+				last.comment = comment
+				continue
+			}
+		}
+		var lines []formatLine
+		lines, missing = formatStatements(fields)
+		if len(lines) == 0 {
+			indented := strings.TrimLeftFunc(text, unicode.IsSpace) != text
+			lines = append(lines, formatLine{indented: indented})
+		}
+		lines[len(lines)-1].comment = comment
+		block = append(block, lines...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+	out := &bytes.Buffer{}
+	for i, block := range blocks {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		writeBlock(out, block)
+	}
+	return out.Bytes(), nil
+}
+
+// formatStatements splits the fields of one source line into labels and
+// statements, giving each statement a line of its own. A label shares the
+// line of the statement following it if it fits before the code column.
+// It also returns the number of operands missing from the last statement,
+// which the assembler would take from the next line.
+func formatStatements(fields []string) ([]formatLine, int) {
+	lines := []formatLine{}
+	label := ""
+	for pos := 0; pos < len(fields); {
+		if isLabel(fields[pos]) {
+			if label != "" {
+				lines = append(lines, formatLine{label: label})
+			}
+			label = fields[pos]
+			pos++
+			continue
+		}
+		end := statementEnd(fields, pos)
+		if utf8.RuneCountInString(label) >= formatIndent {
+			lines = append(lines, formatLine{label: label})
+			label = ""
+		}
+		lines = append(lines, formatLine{label: label, fields: fields[pos:end]})
+		label = ""
+		pos = end
+	}
+	if label != "" {
+		lines = append(lines, formatLine{label: label})
+		return lines, 0
+	}
+	missing := 0
+	if len(lines) > 0 {
+		last := lines[len(lines)-1].fields
+		if instruction, ok := TranslateTable[strings.ToUpper(last[0])]; ok {
+			missing = instruction.Operands + 1 - len(last)
+		}
+	}
+	return lines, missing
+}
+
+// isOperand reports whether a field can be an operand, rather than a label
+// or a mnemonic starting a new statement.
+func isOperand(field string) bool {
+	_, mnemonic := TranslateTable[strings.ToUpper(field)]
+	return !mnemonic && !isLabel(field)
+}
+
+// statementEnd returns the index just past the statement starting at
+// fields[pos]. An instruction takes as many operands as it needs, and a
+// directive takes the rest of the line. Anything else, such as data or a
+// macro call, runs until the next instruction or label.
+func statementEnd(fields []string, pos int) int {
+	if instruction, ok := TranslateTable[strings.ToUpper(fields[pos])]; ok {
+		end := pos + 1 + instruction.Operands
+		if end > len(fields) {
+			end = len(fields)
+		}
+		return end
+	}
+	if strings.HasPrefix(fields[pos], ".") {
+		return len(fields)
+	}
+	end := pos + 1
+	for end < len(fields) && isOperand(fields[end]) {
+		end++
+	}
+	return end
+}
+
+// formatStatement formats one statement, upper-casing mnemonics and the
+// [I] operand. Operands of instructions, and lists of words and symbols,
+// are separated by commas. The fields of data and macro calls are all
+// alike, so they are simply separated by spaces.
+func formatStatement(fields []string) string {
+	op := fields[0]
+	separator := " "
+	switch {
+	case op == ".word", op == ".global", op == ".extern":
+		separator = ", "
+	case strings.HasPrefix(op, "."):
+	default:
+		if _, ok := TranslateTable[strings.ToUpper(op)]; !ok {
+			return strings.Join(fields, " ")
+		}
+		op = strings.ToUpper(op)
+		separator = ", "
+	}
+	if len(fields) == 1 {
+		return op
+	}
+	args := make([]string, len(fields)-1)
+	for i, arg := range fields[1:] {
+		if strings.HasPrefix(arg, "[") {
+			arg = strings.ToUpper(arg)
+		}
+		args[i] = arg
+	}
+	return pad(op, formatIndent) + strings.Join(args, separator)
+}
+
+// pad pads text with spaces to the given width, adding at least one.
+func pad(text string, width int) string {
+	padding := width - utf8.RuneCountInString(text)
+	if padding < 1 {
+		padding = 1
+	}
+	return text + strings.Repeat(" ", padding)
+}
+
+// writeBlock writes a block of lines, aligning trailing comments one
+// column after the longest line of code.
+func writeBlock(out *bytes.Buffer, block []formatLine) {
+	texts := make([]string, len(block))
+	width := 0
+	for i, line := range block {
+		text := line.label
+		if len(line.fields) > 0 {
+			text = pad(text, formatIndent) + formatStatement(line.fields)
+		}
+		if text == "" && line.indented {
+			text = strings.Repeat(" ", formatIndent)
+		}
+		texts[i] = text
+		if len(line.fields) > 0 && line.comment != "" && utf8.RuneCountInString(text) > width {
+			width = utf8.RuneCountInString(text)
+		}
+	}
+	for i, line := range block {
+		text := texts[i]
+		if line.comment != "" {
+			switch {
+			case strings.TrimSpace(text) == "":
+			case len(line.fields) > 0:
+				text = pad(text, width+1)
+			default:
+				text += " "
+			}
+			text += line.comment
+		}
+		out.WriteString(text + "\n")
+	}
+}
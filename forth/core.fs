@@ -0,0 +1,66 @@
+: ['] ' [ ' LITERAL , ] ; IMMEDIATE
+: RECURSE LATEST @ DUP 2 + @ + 3 + , ; IMMEDIATE
+
+: IF ['] 0BRANCH , HERE 0 , ; IMMEDIATE
+: THEN HERE SWAP ! ; IMMEDIATE
+: ELSE ['] BRANCH , HERE 0 , SWAP HERE SWAP ! ; IMMEDIATE
+: BEGIN HERE ; IMMEDIATE
+: UNTIL ['] 0BRANCH , , ; IMMEDIATE
+: AGAIN ['] BRANCH , , ; IMMEDIATE
+: WHILE ['] 0BRANCH , HERE 0 , SWAP ; IMMEDIATE
+: REPEAT ['] BRANCH , , HERE SWAP ! ; IMMEDIATE
+
+: 0= 0 = ;
+: \ BEGIN KEY DUP 10 = SWAP 0= OR UNTIL ; IMMEDIATE
+: ( BEGIN KEY DUP 41 = SWAP 0= OR UNTIL ; IMMEDIATE
+
+\ The core words not built into the kernel, defined in Forth itself. The
+\ host feeds this file to the kernel before its own input.
+
+: DO ( limit start -- ) ['] (DO) , HERE ; IMMEDIATE
+: LOOP ['] (LOOP) , ['] 0BRANCH , , ['] UNLOOP , ; IMMEDIATE
+
+: TRUE -1 ;
+: FALSE 0 ;
+: > SWAP < ;
+: <> = 0= ;
+: 0<> 0= 0= ;
+: 0< 0 < ;
+: 0> 0 > ;
+: 1+ 1 + ;
+: 1- 1 - ;
+: NEGATE 0 SWAP - ;
+: INVERT -1 XOR ;
+: ABS DUP 0< IF NEGATE THEN ;
+: NIP SWAP DROP ;
+: TUCK SWAP OVER ;
+: ?DUP DUP IF DUP THEN ;
+: 2DUP OVER OVER ;
+: 2DROP DROP DROP ;
+: MIN 2DUP > IF SWAP THEN DROP ;
+: MAX 2DUP < IF SWAP THEN DROP ;
+: /MOD ( a b -- rem quot ) 2DUP MOD ROT ROT / ;
+
+: VARIABLE CREATE 0 , ;
+: +! ( n addr -- ) DUP @ ROT + SWAP ! ;
+
+: CR 10 EMIT ;
+: SPACE 32 EMIT ;
+: SPACES ( n -- ) BEGIN DUP 0> WHILE SPACE 1- REPEAT DROP ;
+: TYPE ( addr len -- ) BEGIN DUP WHILE SWAP DUP @ EMIT 1+ SWAP 1- REPEAT 2DROP ;
+: (.) ( u -- ) 10 /MOD ?DUP IF RECURSE THEN 48 + EMIT ;
+\ . prints the last digit of a negative number separately, as the most
+\ negative number can't be negated.
+: . ( n -- ) DUP 0< IF 45 EMIT 10 /MOD NEGATE ?DUP IF (.) THEN NEGATE THEN (.) SPACE ;
+: .S ( -- ) 60 EMIT DEPTH (.) 62 EMIT SPACE
+    DEPTH BEGIN DUP WHILE DUP PICK . 1- REPEAT DROP ;
+
+\ ." prints the text up to the next ", or compiles code to print it.
+: ." STATE @ IF
+        ['] LITSTRING , HERE 0 ,
+        BEGIN KEY DUP 34 <> OVER 0<> AND WHILE , REPEAT DROP
+        HERE OVER - 1- SWAP !
+        ['] TYPE ,
+    ELSE
+        BEGIN KEY DUP 34 <> OVER 0<> AND WHILE EMIT REPEAT DROP
+    THEN ; IMMEDIATE
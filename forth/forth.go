@@ -0,0 +1,76 @@
+// Package forth runs Forth on the G-machine.
+//
+// The kernel, in kernel.gasm, is a threaded-code Forth written in G-machine
+// assembly, with its dictionary and data and return stacks in memory, and
+// console I/O through the BIOS. It provides the primitive words and the
+// outer interpreter; the host bootstraps the rest of the core words, such
+// as IF, DO and ." , by feeding the kernel the Forth source in core.fs
+// ahead of the program's own input:
+//
+//	: SQUARE DUP * ;
+//	5 SQUARE .             \ prints 25
+//	: STARS 0 DO 42 EMIT LOOP ;
+//	3 STARS CR             \ prints ***
+//
+// Cells and characters are 64-bit words, and numbers are read and printed
+// in decimal; a number too large for a cell isn't read as one. Names are
+// not case-sensitive. A word which is neither defined nor a number is
+// reported with a ?, and empties the stacks and discards any definition in
+// progress, as do stack underflow and overflow, which every primitive
+// checks for, and running out of dictionary space. The machine halts at
+// the end of the input, or on BYE.
+package forth
+
+import (
+	_ "embed"
+	"errors"
+	"gmachine"
+	"io"
+	"strings"
+)
+
+// MemSize is the number of words of memory given to the machine, enough
+// for the kernel, the core words and a dictionary of several thousand
+// words more.
+const MemSize = 16384
+
+//go:embed kernel.gasm
+var kernel string
+
+//go:embed core.fs
+var core string
+
+// Kernel assembles the kernel.
+func Kernel() (*gmachine.Program, error) {
+	return gmachine.AssembleProgramFromReader("kernel.gasm", strings.NewReader(kernel))
+}
+
+// New returns a machine with the kernel loaded, ready to run, which reads
+// the core words and then stdin.
+func New(stdin io.Reader) (*gmachine.GMachine, error) {
+	program, err := Kernel()
+	if err != nil {
+		return nil, err
+	}
+	end, ok := program.Symbols.Find("mem_end")
+	if !ok {
+		return nil, errors.New("kernel has no mem_end")
+	}
+	g := gmachine.New()
+	g.Memory = make([]gmachine.Word, MemSize)
+	copy(g.Memory, program.Words)
+	g.Memory[end.Value] = MemSize
+	g.Debug = program.DebugInfo()
+	g.Stdin = io.MultiReader(strings.NewReader(core), stdin)
+	return g, nil
+}
+
+// Run interprets the Forth source read from r, writing its output to w.
+func Run(r io.Reader, w io.Writer) error {
+	g, err := New(r)
+	if err != nil {
+		return err
+	}
+	g.Stdout = w
+	return g.Run()
+}
@@ -0,0 +1,103 @@
+package forth_test
+
+import (
+	"bytes"
+	"errors"
+	"gmachine"
+	"gmachine/forth"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// run interprets source, returning its output.
+func run(t *testing.T, source string) (string, error) {
+	t.Helper()
+	out := &bytes.Buffer{}
+	err := forth.Run(strings.NewReader(source), out)
+	return out.String(), err
+}
+
+func TestForth(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc   string
+		source string
+		want   string
+	}{
+		{desc: "Arithmetic", source: "1 2 + . 3 4 * . 10 4 - . -7 2 / . -7 2 MOD . 7 NEGATE ABS .", want: "3 12 6 -3 -1 7 "},
+		{desc: "Extreme numbers", source: "0 . 9223372036854775807 . -9223372036854775807 . -9223372036854775808 .", want: "0 9223372036854775807 -9223372036854775807 -9223372036854775808 "},
+		{desc: "Negative numbers", source: "-1 . -10 . -123 . 0 1- .", want: "-1 -10 -123 -1 "},
+		{desc: "Stack", source: "1 2 SWAP . . 1 2 OVER . . . 1 2 3 ROT . . . 5 DUP . . 1 2 DROP . DEPTH .", want: "1 2 1 2 1 1 3 2 5 5 1 0 "},
+		{desc: "Show stack", source: "1 2 3 .S", want: "<3> 1 2 3 "},
+		{desc: "Comparisons", source: "1 2 < . 2 1 < . 2 1 > . 2 2 = . 1 2 <> . 0 0= . -3 0< .", want: "-1 0 -1 -1 -1 -1 -1 "},
+		{desc: "Bitwise", source: "12 10 AND . 12 10 OR . 12 10 XOR . 0 INVERT .", want: "8 14 6 -1 "},
+		{desc: "Characters", source: "72 EMIT 105 EMIT CR", want: "Hi\n"},
+		{desc: "Strings", source: `." Hello, " : GREET ." world!" CR ; GREET`, want: "Hello, world!\n"},
+		{desc: "Comments", source: "( a comment ) 1 . \\ to the end of the line\n2 .", want: "1 2 "},
+		{desc: "Colon definitions", source: ": SQUARE DUP * ; : CUBE DUP SQUARE * ; 3 SQUARE . 3 CUBE .", want: "9 27 "},
+		{desc: "Case-insensitive names", source: ": square dup * ; 4 Square .", want: "16 "},
+		{desc: "Redefinition", source: ": X 1 ; : X X 1+ ; X .", want: "2 "},
+		{desc: "If", source: ": SIGN DUP 0< IF DROP -1 ELSE 0> IF 1 ELSE 0 THEN THEN ; -5 SIGN . 0 SIGN . 5 SIGN .", want: "-1 0 1 "},
+		{desc: "Begin until", source: ": COUNTDOWN BEGIN DUP . 1- DUP 0= UNTIL DROP ; 3 COUNTDOWN", want: "3 2 1 "},
+		{desc: "Begin while repeat", source: ": UPTO 0 BEGIN 2DUP > WHILE DUP . 1+ REPEAT 2DROP ; 4 UPTO", want: "0 1 2 3 "},
+		{desc: "Do loop", source: ": TABLE 3 1 DO 3 1 DO I J * . LOOP LOOP ; TABLE", want: "1 2 2 4 "},
+		{desc: "Recursion", source: ": FIB DUP 2 < IF EXIT THEN DUP 1- RECURSE SWAP 2 - RECURSE + ; 20 FIB .", want: "6765 "},
+		{desc: "Variables and constants", source: "VARIABLE X 5 X ! 3 X +! X @ . 42 CONSTANT ANSWER ANSWER .", want: "8 42 "},
+		{desc: "Create and allot", source: "CREATE BUF 3 ALLOT 7 BUF 2 + ! BUF 2 + @ .", want: "7 "},
+		{desc: "Execution tokens", source: "2 3 ' + EXECUTE . : APPLY EXECUTE ; 4 ' DUP APPLY * .", want: "5 16 "},
+		{desc: "Key", source: ": ECHO KEY EMIT KEY EMIT ; ECHO ok", want: "ok"},
+		{desc: "Bye", source: "1 . BYE 2 .", want: "1 "},
+		{desc: "Unknown word", source: "1 2 FOO 3 .S", want: "FOO ?\n<1> 3 "},
+		{desc: "Numbers out of range", source: "9223372036854775808 -9223372036854775809 99999999999999999999 1 .", want: "9223372036854775808 ?\n-9223372036854775809 ?\n99999999999999999999 ?\n1 "},
+		{desc: "Unknown word while compiling", source: ": BAD 1 UNDEFINED ; BAD 7 .", want: "UNDEFINED ?\nBAD ?\n7 "},
+		{desc: "Stack underflow", source: "DROP 1 .", want: "stack underflow\n1 "},
+		{desc: "Return stack overflow", source: ": FOREVER RECURSE ; FOREVER 1 .", want: "return stack overflow\n1 "},
+		{desc: "Stack overflow in a definition", source: ": T 2000 0 DO I LOOP ; T DEPTH .", want: "stack overflow\n0 "},
+		{desc: "Stack overflow at top level", source: ": T 300 0 DO 1 LOOP ; T 1 2 + .", want: "stack overflow\n3 "},
+		{desc: "Stack underflow in a definition", source: ": U DROP DROP ; 1 U 5 .", want: "stack underflow\n5 "},
+		{desc: "Stack underflow before output", source: ". 1 .", want: "stack underflow\n1 "},
+		{desc: "Stack underflow in pick", source: "1 2 5 PICK DEPTH .", want: "stack underflow\n0 "},
+		{desc: "Return stack underflow", source: ": U R> DROP R> ; U 1 .", want: "return stack underflow\n1 "},
+		{desc: "Loop index outside a loop", source: "I 1 .", want: "return stack underflow\n1 "},
+		{desc: "Allot beyond memory", source: "1000000 ALLOT 2 .", want: "dictionary full\n2 "},
+		{desc: "Allot below the dictionary", source: "-1000000 ALLOT 2 .", want: "dictionary full\n2 "},
+		{desc: "Dictionary full", source: ": FILL BEGIN 0 , AGAIN ; FILL 1 .", want: "dictionary full\n1 "},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := run(t, tC.source)
+			if err != nil {
+				t.Error(err)
+			}
+			if tC.want != got {
+				t.Errorf("want output %q, got %q", tC.want, got)
+			}
+		})
+	}
+}
+
+func TestForthFile(t *testing.T) {
+	t.Parallel()
+	source, err := ioutil.ReadFile("testdata/primes.fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := run(t, string(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2 3 5 7 11 13 17 19 23 29 31 37 41 43 47 53 59 61 67 71 73 79 83 89 97 \n25 primes\n"
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestForthFault(t *testing.T) {
+	t.Parallel()
+	_, err := run(t, "1 0 / .")
+	var fault *gmachine.Fault
+	if !errors.As(err, &fault) || fault.Reason != "division by zero" || !strings.HasPrefix(fault.Location, "kernel.gasm:") {
+		t.Errorf("want division by zero fault in kernel.gasm, got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+\ Prints the primes below 100, by trial division.
+
+: PRIME? ( n -- flag )
+    DUP 2 < IF DROP FALSE EXIT THEN
+    2 BEGIN 2DUP DUP * < 0= WHILE
+        2DUP MOD 0= IF 2DROP FALSE EXIT THEN
+        1+
+    REPEAT 2DROP TRUE ;
+
+VARIABLE COUNT
+: PRIMES ( limit -- )
+    0 COUNT !
+    0 DO I PRIME? IF I . 1 COUNT +! THEN LOOP CR
+    COUNT @ . ." primes" CR ;
+
+100 PRIMES
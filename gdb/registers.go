@@ -0,0 +1,153 @@
+package gdb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"gmachine"
+)
+
+// wordSize is the size of a machine word in gdb's byte addressing.
+const wordSize = 8
+
+// targetXML describes the machine's registers to gdb, in the order of the
+// g packet.
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.gmachine.core">
+    <flags id="gmachine_flags" size="8">
+      <field name="Z" start="0" end="0"/>
+      <field name="I" start="1" end="1"/>
+    </flags>
+    <reg name="a" bitsize="64" type="uint64" regnum="0"/>
+    <reg name="i" bitsize="64" type="uint64"/>
+    <reg name="n" bitsize="64" type="uint64"/>
+    <reg name="p" bitsize="64" type="code_ptr"/>
+    <reg name="e" bitsize="64" type="uint64"/>
+    <reg name="flags" bitsize="64" type="gmachine_flags"/>
+  </feature>
+</target>
+`
+
+// Register numbers, as in targetXML.
+const (
+	regA = iota
+	regI
+	regN
+	regP
+	regE
+	regFlags
+	numRegisters
+)
+
+// Bits of the flags register.
+const (
+	flagZ = 1 << iota
+	flagI
+)
+
+// register returns the value of register n as gdb sees it. P is a byte
+// address, like all addresses gdb uses.
+func register(g *gmachine.GMachine, n int) gmachine.Word {
+	switch n {
+	case regA:
+		return g.A
+	case regI:
+		return g.I
+	case regN:
+		return g.N
+	case regP:
+		return g.P * wordSize
+	case regE:
+		return g.E
+	}
+	flags := gmachine.Word(0)
+	if g.FlagZ {
+		flags |= flagZ
+	}
+	if g.FlagI {
+		flags |= flagI
+	}
+	return flags
+}
+
+func setRegister(g *gmachine.GMachine, n int, value gmachine.Word) error {
+	switch n {
+	case regA:
+		g.A = value
+	case regI:
+		g.I = value
+	case regN:
+		g.N = value
+	case regP:
+		if value%wordSize != 0 {
+			return fmt.Errorf("P must be word aligned, not %d", value)
+		}
+		g.P = value / wordSize
+	case regE:
+		g.E = value
+	case regFlags:
+		g.FlagZ = value&flagZ != 0
+		g.FlagI = value&flagI != 0
+	default:
+		return fmt.Errorf("no register %d", n)
+	}
+	return nil
+}
+
+// encodeWord encodes a register value as gdb expects: little-endian bytes
+// in hex.
+func encodeWord(w gmachine.Word) string {
+	b := make([]byte, wordSize)
+	binary.LittleEndian.PutUint64(b, uint64(w))
+	return hex.EncodeToString(b)
+}
+
+func decodeWord(s string) (gmachine.Word, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != wordSize {
+		return 0, fmt.Errorf("register value %q is not %d bytes", s, wordSize)
+	}
+	return gmachine.Word(binary.LittleEndian.Uint64(b)), nil
+}
+
+// readMemory returns length bytes of memory from byte address addr. Word n
+// of memory occupies bytes 8n to 8n+7, least significant first.
+func readMemory(g *gmachine.GMachine, addr, length uint64) ([]byte, error) {
+	if !inMemory(g, addr, length) {
+		return nil, fmt.Errorf("memory %d+%d out of range", addr, length)
+	}
+	data := make([]byte, length)
+	word := make([]byte, wordSize)
+	for i := range data {
+		a := addr + uint64(i)
+		binary.LittleEndian.PutUint64(word, uint64(g.Memory[a/wordSize]))
+		data[i] = word[a%wordSize]
+	}
+	return data, nil
+}
+
+// writeMemory stores bytes at byte address addr, updating the words which
+// contain them.
+func writeMemory(g *gmachine.GMachine, addr uint64, data []byte) error {
+	if !inMemory(g, addr, uint64(len(data))) {
+		return fmt.Errorf("memory %d+%d out of range", addr, len(data))
+	}
+	word := make([]byte, wordSize)
+	for i, b := range data {
+		a := addr + uint64(i)
+		binary.LittleEndian.PutUint64(word, uint64(g.Memory[a/wordSize]))
+		word[a%wordSize] = b
+		g.Memory[a/wordSize] = gmachine.Word(binary.LittleEndian.Uint64(word))
+	}
+	return nil
+}
+
+func inMemory(g *gmachine.GMachine, addr, length uint64) bool {
+	size := uint64(len(g.Memory)) * wordSize
+	return addr <= size && length <= size-addr
+}
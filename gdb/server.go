@@ -0,0 +1,394 @@
+// Package gdb implements a stub for GDB's remote serial protocol, so that
+// gdb can debug a G-machine: reading and writing its registers and memory,
+// continuing, single-stepping, stopping at breakpoints, and halting a
+// running program.
+//
+// gdb addresses memory in bytes, so word n of memory appears at byte
+// address 8n, least significant byte first, and the P register holds the
+// byte address of the next instruction.
+package gdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gmachine"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Signals reported in stop replies.
+const (
+	sigint  = 2
+	sigill  = 4
+	sigtrap = 5
+	sigsegv = 11
+)
+
+// interruptByte is sent by gdb to halt a running program.
+const interruptByte = 0x03
+
+// Server is a stub through which gdb debugs a machine, which should have
+// its program loaded.
+type Server struct {
+	g *gmachine.GMachine
+	// breakpoints holds the addresses, in words, at which to stop.
+	breakpoints map[gmachine.Word]bool
+	// stop is the reply describing why the machine last stopped.
+	stop   string
+	exited bool
+	noAck  bool
+	// interrupt is set when gdb asks a running program to halt.
+	interrupt int32
+	w         io.Writer
+}
+
+// NewServer returns a server for g, stopped before its first instruction.
+func NewServer(g *gmachine.GMachine) *Server {
+	return &Server{
+		g:           g,
+		breakpoints: map[gmachine.Word]bool{},
+		stop:        fmt.Sprintf("S%02x", sigtrap),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr, such as
+// "localhost:1234", and serves the first client to connect until it
+// detaches or kills the program.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return s.Serve(conn)
+}
+
+// packet is a packet received from gdb. A packet whose checksum doesn't
+// match is not ok, and is asked for again.
+type packet struct {
+	data string
+	ok   bool
+}
+
+// Serve handles packets from conn until gdb detaches or kills the
+// program, or the connection ends.
+func (s *Server) Serve(conn io.ReadWriter) error {
+	s.w = conn
+	packets := make(chan packet)
+	errs := make(chan error, 1)
+	go s.readPackets(bufio.NewReader(conn), packets, errs)
+	for p := range packets {
+		if !p.ok {
+			if _, err := io.WriteString(s.w, "-"); err != nil {
+				return err
+			}
+			continue
+		}
+		if !s.noAck {
+			if _, err := io.WriteString(s.w, "+"); err != nil {
+				return err
+			}
+		}
+		reply, done := s.handle(p.data)
+		if p.data == "k" {
+			return nil
+		}
+		if err := s.send(reply); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	if err := <-errs; err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// readPackets reads packets from r until it fails. Interrupts are flagged
+// as soon as they arrive, so that they can halt a running program.
+func (s *Server) readPackets(r *bufio.Reader, packets chan<- packet, errs chan<- error) {
+	defer close(packets)
+	fail := func(err error) {
+		atomic.StoreInt32(&s.interrupt, 1)
+		errs <- err
+	}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			fail(err)
+			return
+		}
+		switch b {
+		case interruptByte:
+			atomic.StoreInt32(&s.interrupt, 1)
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				fail(err)
+				return
+			}
+			data = strings.TrimSuffix(data, "#")
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				fail(err)
+				return
+			}
+			want, err := strconv.ParseUint(string(sum), 16, 8)
+			packets <- packet{data: data, ok: err == nil && byte(want) == checksum(data)}
+		}
+		// Anything else, such as the acknowledgements '+' and '-', is
+		// ignored: over TCP, packets aren't lost.
+	}
+}
+
+func checksum(data string) byte {
+	sum := byte(0)
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// send sends a packet, escaping the characters which the protocol
+// reserves.
+func (s *Server) send(data string) error {
+	b := strings.Builder{}
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '$', '#', '}', '*':
+			b.WriteByte('}')
+			b.WriteByte(c ^ 0x20)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	escaped := b.String()
+	_, err := fmt.Fprintf(s.w, "$%s#%02x", escaped, checksum(escaped))
+	return err
+}
+
+var errPacket = errors.New("malformed packet")
+
+// handle carries out a command, returning the reply and whether the
+// session is over. An empty reply tells gdb that the command isn't
+// supported.
+func (s *Server) handle(data string) (reply string, done bool) {
+	reply, err := s.command(data)
+	if err != nil {
+		return "E01", false
+	}
+	return reply, data == "D"
+}
+
+func (s *Server) command(data string) (string, error) {
+	switch {
+	case data == "?":
+		return s.stop, nil
+	case strings.HasPrefix(data, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+;swbreak+", nil
+	case data == "QStartNoAckMode":
+		s.noAck = true
+		return "OK", nil
+	case strings.HasPrefix(data, "qXfer:features:read:target.xml:"):
+		return xfer(targetXML, strings.TrimPrefix(data, "qXfer:features:read:target.xml:"))
+	case data == "qAttached":
+		return "1", nil
+	case data == "qC":
+		return "QC1", nil
+	case data == "qfThreadInfo":
+		return "m1", nil
+	case data == "qsThreadInfo":
+		return "l", nil
+	case strings.HasPrefix(data, "H"), strings.HasPrefix(data, "T"):
+		// There is only one thread.
+		return "OK", nil
+	case data == "g":
+		b := strings.Builder{}
+		for n := 0; n < numRegisters; n++ {
+			b.WriteString(encodeWord(register(s.g, n)))
+		}
+		return b.String(), nil
+	case strings.HasPrefix(data, "G"):
+		values := data[1:]
+		if len(values) != numRegisters*wordSize*2 {
+			return "", errPacket
+		}
+		for n := 0; n < numRegisters; n++ {
+			value, err := decodeWord(values[n*wordSize*2 : (n+1)*wordSize*2])
+			if err != nil {
+				return "", err
+			}
+			if err := setRegister(s.g, n, value); err != nil {
+				return "", err
+			}
+		}
+		return "OK", nil
+	case strings.HasPrefix(data, "p"):
+		n, err := strconv.ParseUint(data[1:], 16, 32)
+		if err != nil || n >= numRegisters {
+			return "", errPacket
+		}
+		return encodeWord(register(s.g, int(n))), nil
+	case strings.HasPrefix(data, "P"):
+		reg, hexValue, ok := cut(data[1:], "=")
+		if !ok {
+			return "", errPacket
+		}
+		n, err := strconv.ParseUint(reg, 16, 32)
+		if err != nil {
+			return "", err
+		}
+		value, err := decodeWord(hexValue)
+		if err != nil {
+			return "", err
+		}
+		return "OK", setRegister(s.g, int(n), value)
+	case strings.HasPrefix(data, "m"):
+		addr, length, err := addressLength(data[1:])
+		if err != nil {
+			return "", err
+		}
+		memory, err := readMemory(s.g, addr, length)
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(memory), nil
+	case strings.HasPrefix(data, "M"):
+		location, hexData, ok := cut(data[1:], ":")
+		if !ok {
+			return "", errPacket
+		}
+		addr, length, err := addressLength(location)
+		if err != nil {
+			return "", err
+		}
+		memory, err := hex.DecodeString(hexData)
+		if err != nil || uint64(len(memory)) != length {
+			return "", errPacket
+		}
+		return "OK", writeMemory(s.g, addr, memory)
+	case strings.HasPrefix(data, "Z0,"), strings.HasPrefix(data, "z0,"):
+		fields := strings.Split(data[3:], ",")
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil || addr%wordSize != 0 {
+			return "", errPacket
+		}
+		if data[0] == 'Z' {
+			s.breakpoints[gmachine.Word(addr/wordSize)] = true
+		} else {
+			delete(s.breakpoints, gmachine.Word(addr/wordSize))
+		}
+		return "OK", nil
+	case strings.HasPrefix(data, "c"), strings.HasPrefix(data, "s"):
+		if len(data) > 1 {
+			addr, err := strconv.ParseUint(data[1:], 16, 64)
+			if err != nil {
+				return "", err
+			}
+			if err := setRegister(s.g, regP, gmachine.Word(addr)); err != nil {
+				return "", err
+			}
+		}
+		s.stop = s.resume(data[0] == 's')
+		return s.stop, nil
+	case data == "D":
+		return "OK", nil
+	case data == "k":
+		return "", nil
+	}
+	return "", nil
+}
+
+// resume runs the machine until it reaches a breakpoint, is interrupted,
+// halts or faults, or, when stepping, for a single instruction. It returns
+// the stop reply.
+func (s *Server) resume(step bool) string {
+	if s.exited {
+		return "W00"
+	}
+	for {
+		if !step && atomic.CompareAndSwapInt32(&s.interrupt, 1, 0) {
+			return fmt.Sprintf("S%02x", sigint)
+		}
+		running, err := s.g.Step()
+		switch {
+		case err != nil:
+			return s.fault(err)
+		case !running:
+			s.exited = true
+			return "W00"
+		case step:
+			return fmt.Sprintf("S%02x", sigtrap)
+		case s.breakpoints[s.g.P]:
+			return fmt.Sprintf("T%02xswbreak:;", sigtrap)
+		}
+	}
+}
+
+// fault stops the machine at a faulting instruction, so that gdb shows it,
+// and reports the fault on gdb's console. An invalid opcode is reported as
+// an illegal instruction, and anything else as a memory fault.
+func (s *Server) fault(err error) string {
+	signal := sigsegv
+	var fault *gmachine.Fault
+	if errors.As(err, &fault) {
+		s.g.P = fault.P
+		if text, _ := gmachine.Disassemble(s.g.Memory, fault.P); strings.HasPrefix(text, ".word") {
+			signal = sigill
+		}
+	}
+	s.send("O" + hex.EncodeToString([]byte(err.Error()+"\n")))
+	return fmt.Sprintf("S%02x", signal)
+}
+
+// xfer returns the part of an object which a qXfer read asks for, given
+// its "offset,length" argument.
+func xfer(object, args string) (string, error) {
+	offset, length, err := addressLength(args)
+	if err != nil {
+		return "", err
+	}
+	if offset >= uint64(len(object)) {
+		return "l", nil
+	}
+	rest := object[offset:]
+	if uint64(len(rest)) <= length {
+		return "l" + rest, nil
+	}
+	return "m" + rest[:length], nil
+}
+
+// addressLength parses the "addr,length" argument of memory commands.
+func addressLength(args string) (addr, length uint64, err error) {
+	a, l, ok := cut(args, ",")
+	if !ok {
+		return 0, 0, errPacket
+	}
+	if addr, err = strconv.ParseUint(a, 16, 64); err != nil {
+		return 0, 0, err
+	}
+	if length, err = strconv.ParseUint(l, 16, 64); err != nil {
+		return 0, 0, err
+	}
+	return addr, length, nil
+}
+
+// cut splits s around the first instance of sep.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,222 @@
+package gdb_test
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"gmachine"
+	"gmachine/gdb"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// countdown counts A down from 5 to 0; the instruction after loop is at
+// word 3, or byte address 0x18.
+const countdown = `
+	SETA 5
+loop:	DECA
+	CMPA 0
+	JEQ loop
+	HALT
+`
+
+// client drives a server through a pipe, as gdb would.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	// console holds the text of the console output packets received.
+	console strings.Builder
+	done    chan error
+}
+
+func newClient(t *testing.T, source string) (*client, *gmachine.GMachine) {
+	t.Helper()
+	words, err := gmachine.AssembleFromText(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	copy(g.Memory, words)
+	server, conn := net.Pipe()
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn), done: make(chan error, 1)}
+	go func() {
+		c.done <- gdb.NewServer(g).Serve(server)
+		server.Close()
+	}()
+	t.Cleanup(func() {
+		conn.Close()
+		if err := <-c.done; err != nil {
+			t.Error(err)
+		}
+	})
+	return c, g
+}
+
+func (c *client) send(data string) {
+	c.t.Helper()
+	sum := byte(0)
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", data, sum); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the next packet, skipping acknowledgements and collecting
+// console output.
+func (c *client) read() string {
+	c.t.Helper()
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if b != '$' {
+			continue
+		}
+		data, err := c.r.ReadString('#')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		if _, err := io.ReadFull(c.r, make([]byte, 2)); err != nil {
+			c.t.Fatal(err)
+		}
+		data = strings.TrimSuffix(data, "#")
+		if strings.HasPrefix(data, "O") && data != "OK" {
+			text, err := hex.DecodeString(data[1:])
+			if err != nil {
+				c.t.Fatal(err)
+			}
+			c.console.Write(text)
+			continue
+		}
+		return data
+	}
+}
+
+func (c *client) request(data string) string {
+	c.t.Helper()
+	c.send(data)
+	return c.read()
+}
+
+// kill sends k, which has no reply, and waits for the server to end the
+// session.
+func (c *client) kill() {
+	c.t.Helper()
+	c.send("k")
+	io.Copy(io.Discard, c.r)
+}
+
+func (c *client) expect(data, want string) {
+	c.t.Helper()
+	if got := c.request(data); want != got {
+		c.t.Errorf("%s: want %q, got %q", data, want, got)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	t.Parallel()
+	c, _ := newClient(t, countdown)
+	if got := c.request("qSupported:multiprocess+;swbreak+"); !strings.Contains(got, "qXfer:features:read+") {
+		t.Errorf("want target description support, got %q", got)
+	}
+	c.expect("QStartNoAckMode", "OK")
+	c.expect("?", "S05")
+	c.expect("qfThreadInfo", "m1")
+	c.expect("qsThreadInfo", "l")
+	c.expect("vMustReplyEmpty", "")
+	xml := strings.Builder{}
+	for {
+		reply := c.request(fmt.Sprintf("qXfer:features:read:target.xml:%x,40", xml.Len()))
+		xml.WriteString(reply[1:])
+		if reply[0] == 'l' {
+			break
+		}
+	}
+	for _, reg := range []string{"a", "i", "n", "p", "e", "flags"} {
+		if !strings.Contains(xml.String(), fmt.Sprintf(`<reg name="%s"`, reg)) {
+			t.Errorf("want register %s in target description, got %s", reg, xml.String())
+		}
+	}
+	c.kill()
+}
+
+func TestRegisters(t *testing.T) {
+	t.Parallel()
+	c, g := newClient(t, countdown)
+	c.expect("g", strings.Repeat("0", 6*16))
+	c.expect("s", "S05")
+	c.expect("p0", "0500000000000000")
+	c.expect("p3", "1000000000000000")
+	c.expect("P1=2a00000000000000", "OK")
+	c.expect("P5=0300000000000000", "OK")
+	if g.I != 42 || !g.FlagZ || !g.FlagI {
+		t.Errorf("want I=42 and both flags set, got I=%d Z=%t I=%t", g.I, g.FlagZ, g.FlagI)
+	}
+	c.expect("P3=0100000000000000", "E01")
+	c.expect("p9", "E01")
+	c.expect("G"+strings.Repeat("0", 3*16)+"1000000000000000"+strings.Repeat("0", 2*16), "OK")
+	if g.A != 0 || g.P != 2 {
+		t.Errorf("want A=0 P=2, got A=%d P=%d", g.A, g.P)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+	c, g := newClient(t, countdown)
+	c.expect("m0,10", "0400000000000000"+"0500000000000000")
+	c.expect("m9,2", "0000")
+	c.expect("M8,2:0701", "OK")
+	if g.Memory[1] != 0x0107 {
+		t.Errorf("want word 1 to be 0x107, got %#x", g.Memory[1])
+	}
+	c.expect(fmt.Sprintf("m%x,8", len(g.Memory)*8), "E01")
+	c.expect(fmt.Sprintf("M%x,1:00", len(g.Memory)*8), "E01")
+}
+
+func TestBreakpoints(t *testing.T) {
+	t.Parallel()
+	c, g := newClient(t, countdown)
+	c.expect("Z0,18,1", "OK")
+	for _, want := range []string{"4", "3"} {
+		c.expect("c", "T05swbreak:;")
+		c.expect("p3", "1800000000000000")
+		if got := fmt.Sprint(g.A); want != got {
+			t.Errorf("want A=%s at breakpoint, got %s", want, got)
+		}
+	}
+	c.expect("z0,18,1", "OK")
+	c.expect("c", "W00")
+	c.expect("?", "W00")
+	c.expect("D", "OK")
+}
+
+func TestInterrupt(t *testing.T) {
+	t.Parallel()
+	c, _ := newClient(t, "loop: JUMP loop")
+	c.send("c")
+	if _, err := c.conn.Write([]byte{0x03}); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.read(); got != "S02" {
+		t.Errorf("want stop with SIGINT, got %q", got)
+	}
+	c.expect("p3", "0000000000000000")
+	c.kill()
+}
+
+func TestFault(t *testing.T) {
+	t.Parallel()
+	c, _ := newClient(t, "NOOP\n.word 99")
+	c.expect("c", "S04")
+	c.expect("p3", "0800000000000000")
+	if want, got := "fault at P=1: invalid opcode 99\n", c.console.String(); want != got {
+		t.Errorf("want console output %q, got %q", want, got)
+	}
+	c.kill()
+}
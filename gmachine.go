@@ -2,14 +2,11 @@
 package gmachine
 
 import (
-	"bufio"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
-	"strconv"
-	"strings"
 )
 
 // DefaultMemSize is the number of 64-bit words of memory which will be
@@ -32,302 +29,445 @@ const (
 	CMPI
 	SETI
 	SETAM
+	EINT
+	DINT
+	IRET
+	SETV
+	SETM
+	STORE
+	PEEK
+	POKE
+	LOAD
+	SAVE
+	XCHG
+	SETAI
+	SETIA
+	SETAN
+	SETNA
+	ADDA
+	ADDI
+	DECI
+	ADDM
+	SUBM
+	MULM
+	DIVM
+	MODM
+	CMPM
+	LESS
+	ANDM
+	ORM
+	XORM
 )
 
 const (
 	IOWrite = iota
 	IORead
+	DiskSeek
+	DiskRead
+	DiskWrite
+	FileOpen
+	FileRead
+	FileWrite
+	FileClose
+	ClockTime
+	ClockCycles
+	ClockSleep
+	RandomSeed
+	RandomNext
+	TimerSet
 )
 
 const (
 	PortStdin = iota
 	PortStdout
 	PortStderr
+	PortDisk
+	PortFile
+	PortClock
+	PortRandom
+	PortTimer
+)
+
+// Result codes which BIOS operations leave in the E register.
+const (
+	ErrorNone = iota
+	ErrorNoDevice
+	ErrorInvalid
+	ErrorIO
+	ErrorEOF
+	ErrorNotFound
+	ErrorPermission
+	ErrorBadDescriptor
 )
 
 var PredefinedConstants = map[string]Word{
-	"IOWRITE": IOWrite,
-	"IOREAD":  IORead,
-	"STDIN":   PortStdin,
-	"STDOUT":  PortStdout,
-	"STDERR":  PortStderr,
+	"IOWRITE":   IOWrite,
+	"IOREAD":    IORead,
+	"DISKSEEK":  DiskSeek,
+	"DISKREAD":  DiskRead,
+	"DISKWRITE": DiskWrite,
+	"FOPEN":     FileOpen,
+	"FREAD":     FileRead,
+	"FWRITE":    FileWrite,
+	"FCLOSE":    FileClose,
+	"TIME":      ClockTime,
+	"CYCLES":    ClockCycles,
+	"SLEEP":     ClockSleep,
+	"SEED":      RandomSeed,
+	"RANDOM":    RandomNext,
+	"TIMERSET":  TimerSet,
+	"STDIN":     PortStdin,
+	"STDOUT":    PortStdout,
+	"STDERR":    PortStderr,
+	"DISK":      PortDisk,
+	"FILE":      PortFile,
+	"CLOCK":     PortClock,
+	"RNG":       PortRandom,
+	"TIMER":     PortTimer,
+	"IRQTIMER":  IRQTimer,
+	"FREADONLY": FileModeRead,
+	"FCREATE":   FileModeWrite,
+	"FAPPEND":   FileModeAppend,
+	"ENONE":     ErrorNone,
+	"ENODEV":    ErrorNoDevice,
+	"EINVAL":    ErrorInvalid,
+	"EIO":       ErrorIO,
+	"EOF":       ErrorEOF,
+	"ENOENT":    ErrorNotFound,
+	"EACCES":    ErrorPermission,
+	"EBADF":     ErrorBadDescriptor,
 }
 
+// Instruction describes an assembly mnemonic: its opcode, how many
+// operands follow it, and a short description for editors.
 type Instruction struct {
 	Opcode   Word
 	Operands int
+	Doc      string
 }
 
 var TranslateTable = map[string]Instruction{
-	"HALT": {Opcode: HALT, Operands: 0},
-	"NOOP": {Opcode: NOOP, Operands: 0},
-	"SETA": {Opcode: SETA, Operands: 1},
-	"DECA": {Opcode: DECA, Operands: 0},
-	"INCA": {Opcode: INCA, Operands: 0},
-	"BIOS": {Opcode: BIOS, Operands: 2},
-	"CMPA": {Opcode: CMPA, Operands: 1},
-	"JEQ":  {Opcode: JEQ, Operands: 1},
-	"JUMP": {Opcode: JUMP, Operands: 1},
-	"CALL": {Opcode: CALL, Operands: 1},
-	"RETN": {Opcode: RETN, Operands: 0},
-	"INCI": {Opcode: INCI, Operands: 0},
-	"CMPI": {Opcode: CMPI, Operands: 1},
-	"SETI": {Opcode: SETI, Operands: 1},
+	"HALT":  {Opcode: HALT, Operands: 0, Doc: "HALT: stop the machine."},
+	"NOOP":  {Opcode: NOOP, Operands: 0, Doc: "NOOP: do nothing."},
+	"SETA":  {Opcode: SETA, Operands: 1, Doc: "SETA n: set A to n. SETA [I] sets A to the word in memory at I."},
+	"DECA":  {Opcode: DECA, Operands: 0, Doc: "DECA: subtract one from A."},
+	"INCA":  {Opcode: INCA, Operands: 0, Doc: "INCA: add one to A."},
+	"BIOS":  {Opcode: BIOS, Operands: 2, Doc: "BIOS operation, port: perform an I/O operation on a port, leaving the result code in E."},
+	"CMPA":  {Opcode: CMPA, Operands: 1, Doc: "CMPA n: set Z if A equals n, and clear it otherwise."},
+	"JEQ":   {Opcode: JEQ, Operands: 1, Doc: "JEQ addr: jump to addr if Z is clear, that is, if the last comparison found a difference."},
+	"JUMP":  {Opcode: JUMP, Operands: 1, Doc: "JUMP addr: continue execution at addr."},
+	"CALL":  {Opcode: CALL, Operands: 1, Doc: "CALL addr: save the return address in N and jump to addr."},
+	"RETN":  {Opcode: RETN, Operands: 0, Doc: "RETN: return to the address saved in N by CALL."},
+	"INCI":  {Opcode: INCI, Operands: 0, Doc: "INCI: add one to I."},
+	"CMPI":  {Opcode: CMPI, Operands: 1, Doc: "CMPI n: set Z if I equals n, and clear it otherwise."},
+	"SETI":  {Opcode: SETI, Operands: 1, Doc: "SETI n: set I to n."},
+	"EINT":  {Opcode: EINT, Operands: 0, Doc: "EINT: enable interrupts."},
+	"DINT":  {Opcode: DINT, Operands: 0, Doc: "DINT: disable interrupts."},
+	"IRET":  {Opcode: IRET, Operands: 0, Doc: "IRET: return from an interrupt handler, restoring P and Z and enabling interrupts."},
+	"SETV":  {Opcode: SETV, Operands: 1, Doc: "SETV addr: set V, the address of the interrupt vector table."},
+	"SETM":  {Opcode: SETM, Operands: 1, Doc: "SETM mask: set M, the interrupt mask, with one bit set for each masked line."},
+	"STORE": {Opcode: STORE, Operands: 0, Doc: "STORE: store A in memory at I."},
+	"PEEK":  {Opcode: PEEK, Operands: 1, Doc: "PEEK n: set A to the word in memory at I+n, where n may be negative."},
+	"POKE":  {Opcode: POKE, Operands: 1, Doc: "POKE n: store A in memory at I+n, where n may be negative."},
+	"LOAD":  {Opcode: LOAD, Operands: 1, Doc: "LOAD addr: set A to the word in memory at addr."},
+	"SAVE":  {Opcode: SAVE, Operands: 1, Doc: "SAVE addr: store A in memory at addr."},
+	"XCHG":  {Opcode: XCHG, Operands: 0, Doc: "XCHG: exchange A with the word in memory at I."},
+	"SETAI": {Opcode: SETAI, Operands: 0, Doc: "SETAI: set A to I."},
+	"SETIA": {Opcode: SETIA, Operands: 0, Doc: "SETIA: set I to A."},
+	"SETAN": {Opcode: SETAN, Operands: 0, Doc: "SETAN: set A to N."},
+	"SETNA": {Opcode: SETNA, Operands: 0, Doc: "SETNA: set N to A."},
+	"ADDA":  {Opcode: ADDA, Operands: 1, Doc: "ADDA n: add n to A. A negative n subtracts."},
+	"ADDI":  {Opcode: ADDI, Operands: 1, Doc: "ADDI n: add n to I. A negative n subtracts."},
+	"DECI":  {Opcode: DECI, Operands: 0, Doc: "DECI: subtract one from I."},
+	"ADDM":  {Opcode: ADDM, Operands: 0, Doc: "ADDM: add the word in memory at I to A."},
+	"SUBM":  {Opcode: SUBM, Operands: 0, Doc: "SUBM: subtract the word in memory at I from A."},
+	"MULM":  {Opcode: MULM, Operands: 0, Doc: "MULM: multiply A by the word in memory at I."},
+	"DIVM":  {Opcode: DIVM, Operands: 0, Doc: "DIVM: divide A by the word in memory at I, as signed integers, faulting if it is zero."},
+	"MODM":  {Opcode: MODM, Operands: 0, Doc: "MODM: set A to the remainder of dividing it by the word in memory at I, as signed integers, faulting if it is zero."},
+	"CMPM":  {Opcode: CMPM, Operands: 0, Doc: "CMPM: set Z if A equals the word in memory at I, and clear it otherwise."},
+	"LESS":  {Opcode: LESS, Operands: 0, Doc: "LESS: set Z if A is less than the word in memory at I, as signed integers, and clear it otherwise."},
+	"ANDM":  {Opcode: ANDM, Operands: 0, Doc: "ANDM: set A to the bitwise AND of A and the word in memory at I."},
+	"ORM":   {Opcode: ORM, Operands: 0, Doc: "ORM: set A to the bitwise OR of A and the word in memory at I."},
+	"XORM":  {Opcode: XORM, Operands: 0, Doc: "XORM: set A to the bitwise exclusive OR of A and the word in memory at I."},
 }
 
 type Word uint64
 
 type GMachine struct {
-	A, N, P, I     Word
-	FlagZ          bool
+	// pending holds one bit per raised interrupt line. It is accessed
+	// atomically, so it comes first to keep it 64-bit aligned.
+	pending       uint64
+	A, N, P, I, E Word
+	FlagZ         bool
+	// V is the address of the interrupt vector table, M is the interrupt
+	// mask with one bit per masked line, and FlagI enables interrupts.
+	V, M           Word
+	FlagI          bool
+	savedP         Word
+	savedZ         bool
+	timerPeriod    Word
+	timerRemaining Word
 	Memory         []Word
+	Stdin          io.Reader
 	Stdout, Stderr io.Writer
+	Disk           *Disk
+	// FileRoot is the host directory which programs may access through
+	// the FILE port. File access is disabled when it is empty.
+	FileRoot string
+	files    map[Word]*os.File
+	// Cycles counts the instructions executed by Run.
+	Cycles Word
+	Clock  Clock
+	Rand   *rand.Rand
+	// pc is the address of the instruction being executed.
+	pc Word
+	// Trace, if set, receives a line for each instruction executed.
+	Trace io.Writer
+	// Symbols, if set, is used to show addresses symbolically in faults
+	// and traces.
+	Symbols *SymbolMap
+	// Debug holds the debug info of the loaded binary, if it had any.
+	Debug *DebugInfo
 }
 
 func New() *GMachine {
 	return &GMachine{
 		Memory: make([]Word, DefaultMemSize),
+		Stdin:  os.Stdin,
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
+		files:  map[Word]*os.File{},
+		Clock:  systemClock{},
+		Rand:   newRand(),
 	}
 }
 
-func (g *GMachine) Run() {
+// Run executes instructions until HALT, or until an instruction faults, in
+// which case it returns the *Fault.
+func (g *GMachine) Run() error {
 	for {
-		opcode := g.Memory[g.P]
-		g.P++
-		switch opcode {
-		case NOOP:
-		case HALT:
-			return
-		case INCA:
-			g.A++
-		case DECA:
-			g.A--
-		case SETA:
-			g.A = g.Next()
-		case SETI:
-			g.I = g.Next()
-		case SETAM:
-			g.A = g.Memory[g.I]
-		case BIOS:
-			operation := g.Next()
-			fileDescriptor := g.Next()
-			if operation == IOWrite {
-				if fileDescriptor == PortStdout {
-					fmt.Fprintf(g.Stdout, "%c", g.A)
-					continue
-				}
-				fmt.Fprintf(g.Stderr, "%c", g.A)
-			}
-		case CMPA:
-			value := g.Next()
-			g.FlagZ = g.A == value
-		case CMPI:
-			value := g.Next()
-			g.FlagZ = g.I == value
-		case JEQ:
-			if !g.FlagZ {
-				g.P = g.Memory[g.P]
-				continue
-			}
-			g.P++
-		case JUMP:
-			g.P = g.Next()
-		case CALL:
-			g.N = g.P + 1
-			g.P = g.Next()
-		case RETN:
-			g.P = g.N
-			g.N = 0
-		case INCI:
-			g.I++
+		running, err := g.Step()
+		if err != nil || !running {
+			return err
 		}
-
 	}
 }
 
-func (g *GMachine) Next() Word {
-	next := g.Memory[g.P]
-	g.P++
-	return next
+// Step executes a single instruction, reporting whether the machine is
+// still running, which it isn't once it has executed HALT. A fault is
+// returned as a *Fault.
+func (g *GMachine) Step() (running bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fault, ok := r.(*Fault)
+			if !ok {
+				panic(r)
+			}
+			running, err = false, fault
+		}
+	}()
+	return g.step(), nil
 }
 
-func (g *GMachine) RunProgram(instructions []Word) {
-	for i := range instructions {
-		g.Memory[i] = instructions[i]
+// step services any pending interrupt and then executes one instruction,
+// reporting whether the machine is still running.
+func (g *GMachine) step() bool {
+	g.serviceInterrupt()
+	g.pc = g.P
+	opcode := g.load(g.P)
+	if g.Trace != nil {
+		g.trace(g.Trace)
 	}
-	g.Run()
-}
-
-func (g *GMachine) ExecuteBinary(binPath string) error {
-	binFile, err := os.Open(binPath)
-	if err != nil {
-		return err
-	}
-	defer binFile.Close()
-	return g.RunProgramFromReader(binFile)
-}
-
-func AssembleData(token string) ([]Word, error) {
-	words := []Word{}
-	//fmt.Println(token, "is data")
-	switch {
-	case strings.HasPrefix(token, "\""):
-		token = strings.ReplaceAll(token, "\"", "")
-		for _, l := range token {
-			words = append(words, Word(l))
+	g.P++
+	g.Cycles++
+	g.tickTimer()
+	switch opcode {
+	case NOOP:
+	case HALT:
+		return false
+	case INCA:
+		g.A++
+	case DECA:
+		g.A--
+	case SETA:
+		g.A = g.Next()
+	case SETI:
+		g.I = g.Next()
+	case SETAM:
+		g.A = g.load(g.I)
+	case BIOS:
+		operation := g.Next()
+		port := g.Next()
+		g.bios(operation, port)
+	case CMPA:
+		value := g.Next()
+		g.FlagZ = g.A == value
+	case CMPI:
+		value := g.Next()
+		g.FlagZ = g.I == value
+	case JEQ:
+		if !g.FlagZ {
+			g.P = g.load(g.P)
+			return true
 		}
-	case strings.HasPrefix(token, "'"):
-		token = strings.ReplaceAll(token, "'", "")
-		for _, l := range token {
-			words = append(words, Word(l))
+		g.P++
+	case JUMP:
+		g.P = g.Next()
+	case CALL:
+		g.N = g.P + 1
+		g.P = g.Next()
+	case RETN:
+		g.P = g.N
+		g.N = 0
+	case INCI:
+		g.I++
+	case EINT:
+		g.FlagI = true
+	case DINT:
+		g.FlagI = false
+	case IRET:
+		g.returnFromInterrupt()
+	case SETV:
+		g.V = g.Next()
+	case SETM:
+		g.M = g.Next()
+	case STORE:
+		g.store(g.I, g.A)
+	case PEEK:
+		g.A = g.load(g.I + g.Next())
+	case POKE:
+		g.store(g.I+g.Next(), g.A)
+	case LOAD:
+		g.A = g.load(g.Next())
+	case SAVE:
+		g.store(g.Next(), g.A)
+	case XCHG:
+		word := g.load(g.I)
+		g.store(g.I, g.A)
+		g.A = word
+	case SETAI:
+		g.A = g.I
+	case SETIA:
+		g.I = g.A
+	case SETAN:
+		g.A = g.N
+	case SETNA:
+		g.N = g.A
+	case ADDA:
+		g.A += g.Next()
+	case ADDI:
+		g.I += g.Next()
+	case DECI:
+		g.I--
+	case ADDM:
+		g.A += g.load(g.I)
+	case SUBM:
+		g.A -= g.load(g.I)
+	case MULM:
+		g.A *= g.load(g.I)
+	case DIVM, MODM:
+		divisor := int64(g.load(g.I))
+		if divisor == 0 {
+			g.fault("division by zero")
 		}
-	default:
-		for _, s := range strings.Fields(token) {
-			temp, err := strconv.Atoi(s)
-			if err != nil {
-				return nil, err
-			}
-			words = append(words, Word(temp))
+		if opcode == DIVM {
+			g.A = Word(int64(g.A) / divisor)
+		} else {
+			g.A = Word(int64(g.A) % divisor)
 		}
-
+	case CMPM:
+		g.FlagZ = g.A == g.load(g.I)
+	case LESS:
+		g.FlagZ = int64(g.A) < int64(g.load(g.I))
+	case ANDM:
+		g.A &= g.load(g.I)
+	case ORM:
+		g.A |= g.load(g.I)
+	case XORM:
+		g.A ^= g.load(g.I)
+	default:
+		// A word which isn't an instruction usually means the program has
+		// jumped into its data, so stop rather than carry on regardless.
+		g.fault("invalid opcode %d", opcode)
 	}
-	return words, nil
+	return true
 }
 
-func AssembleOperand(constants map[string]Word, token string) (Word, error) {
-	if strings.HasPrefix(token, "[") {
-		return SETAM, nil
-	}
-	word, ok := constants[token]
-	if ok {
-		return word, nil
+func (g *GMachine) bios(operation, port Word) {
+	switch port {
+	case PortDisk:
+		g.diskOperation(operation)
+		return
+	case PortFile:
+		g.fileOperation(operation)
+		return
+	case PortClock:
+		g.clockOperation(operation)
+		return
+	case PortRandom:
+		g.randomOperation(operation)
+		return
+	case PortTimer:
+		g.timerOperation(operation)
+		return
 	}
-	temp, err := strconv.Atoi(token)
-	if err != nil {
-		return 0, err
-	}
-	return Word(temp), nil
-}
-
-func Assemble(code []string) ([]Word, error) {
-	words := []Word{}
-	constants := PredefinedConstants
-	for pos := 0; pos < len(code); pos++ {
-		token := code[pos]
-		instruction, ok := TranslateTable[strings.ToUpper(token)]
-		if !ok {
-			data, err := AssembleData(token)
-			if err != nil {
-				return nil, err
-			}
-			words = append(words, data...)
-			continue
-		}
-		//fmt.Println(token, "is opcode")
-		words = append(words, instruction.Opcode)
-		if instruction.Operands <= 0 {
-			continue
-		}
-		if pos+instruction.Operands >= len(code) {
-			return nil, errors.New("missing operand")
+	if operation == IORead {
+		if g.Stdin == nil {
+			g.E = ErrorNoDevice
+			return
 		}
-		for count := 0; count < instruction.Operands; count++ {
-			operand := code[pos+1]
-			//fmt.Println(operand, "is operand")
-			if strings.HasPrefix(operand, "[") {
-				word, err := AssembleOperand(constants, operand)
-				if err != nil {
-					return nil, err
-				}
-				words[len(words)-1] = word
-				pos++
-				continue
-			}
-			word, err := AssembleOperand(constants, operand)
-			if err != nil {
-				return nil, err
-			}
-			words = append(words, word)
-			pos++
+		b := make([]byte, 1)
+		_, err := io.ReadFull(g.Stdin, b)
+		g.E = errorCode(err)
+		g.A = Word(b[0])
+		return
+	}
+	if operation == IOWrite {
+		if port == PortStdout {
+			fmt.Fprintf(g.Stdout, "%c", g.A)
+			return
 		}
+		fmt.Fprintf(g.Stderr, "%c", g.A)
 	}
-
-	return words, nil
 }
 
-func AssembleFromFile(path string) ([]Word, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	code := []string{}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		code = append(code, scanner.Text())
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-	words, err := Assemble(code)
-	if err != nil {
-		return nil, err
-	}
-	return words, nil
+func (g *GMachine) Next() Word {
+	next := g.load(g.P)
+	g.P++
+	return next
 }
 
-func AssembleFromText(text string) ([]Word, error) {
-	code := []string{}
-	scanner := bufio.NewScanner(strings.NewReader(text))
-	for scanner.Scan() {
-		line := scanner.Text()
-		switch {
-		case line == "":
-			continue
-		case strings.HasPrefix(line, "#"):
-			continue
-		}
-		for _, item := range strings.Fields(line) {
-			code = append(code, item)
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+// RunProgram loads a program at address 0 and runs it, returning the
+// *Fault from Run, if any. A program too big for memory isn't loaded, and
+// is reported with an error instead.
+func (g *GMachine) RunProgram(instructions []Word) error {
+	if len(instructions) > len(g.Memory) {
+		return fmt.Errorf("program of %d words does not fit in %d words of memory", len(instructions), len(g.Memory))
 	}
-	if len(code) <= 0 {
-		return nil, fmt.Errorf("Invalid code. Length is %d", len(code))
-	}
-	words, err := Assemble(code)
-	if err != nil {
-		return nil, err
+	for i := range instructions {
+		g.Memory[i] = instructions[i]
 	}
-	return words, nil
+	return g.Run()
 }
 
-func AssembleFromFileToBinary(inPath, outPath string) error {
-	data, err := AssembleFromFile(inPath)
-	if err != nil {
-		return err
-	}
-	outFile, err := os.Create(outPath)
+func (g *GMachine) ExecuteBinary(binPath string) error {
+	binFile, err := os.Open(binPath)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
-	return WriteWords(outFile, data)
+	defer binFile.Close()
+	return g.RunProgramFromReader(binFile)
 }
 
+// RunProgramFromReader loads and runs a binary, keeping any debug info it
+// carries in g.Debug.
 func (g *GMachine) RunProgramFromReader(r io.Reader) error {
-	words, err := ReadWords(r)
+	words, debug, err := ReadBinary(r)
 	if err != nil {
 		return err
 	}
-	g.RunProgram(words)
-
-	return nil
+	g.Debug = debug
+	return g.RunProgram(words)
 }
 
 func ReadWords(r io.Reader) ([]Word, error) {
@@ -0,0 +1,181 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"errors"
+	"gmachine"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAssembleDirectives(t *testing.T) {
+	testCases := []struct {
+		desc, code string
+		want       []gmachine.Word
+	}{
+		{
+			desc: "Constant defined with .equ",
+			code: ".equ ANSWER 42\nSETA ANSWER",
+			want: []gmachine.Word{gmachine.SETA, 42},
+		},
+		{
+			desc: "Constant used before .equ",
+			code: "SETA ANSWER\n.equ ANSWER 42",
+			want: []gmachine.Word{gmachine.SETA, 42},
+		},
+		{
+			desc: "Words with constants and labels",
+			code: "start:\n.word 1 'A' STDERR start end\nend:",
+			want: []gmachine.Word{1, 65, gmachine.PortStderr, 0, 5},
+		},
+		{
+			desc: "Characters and character expressions",
+			code: ".word 'AB' 'A'+'B' ('A'|0x80)",
+			want: []gmachine.Word{65, 66, 131, 0xc1},
+		},
+		{
+			desc: "String with spaces and escapes",
+			code: `.string "Hi there\n"`,
+			want: []gmachine.Word{'H', 'i', ' ', 't', 'h', 'e', 'r', 'e', '\n'},
+		},
+		{
+			desc: "Zero-terminated string",
+			code: `.stringz "Hi"`,
+			want: []gmachine.Word{'H', 'i', 0},
+		},
+		{
+			desc: "Space",
+			code: ".equ SIZE 3\nHALT\n.space SIZE\nNOOP",
+			want: []gmachine.Word{gmachine.HALT, 0, 0, 0, gmachine.NOOP},
+		},
+		{
+			desc: "Org",
+			code: "HALT\n.org 4\nNOOP",
+			want: []gmachine.Word{gmachine.HALT, 0, 0, 0, gmachine.NOOP},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := gmachine.AssembleFromText(tC.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(tC.want, got) {
+				t.Error(cmp.Diff(tC.want, got))
+			}
+		})
+	}
+}
+
+func TestAssembleDirectiveErrors(t *testing.T) {
+	testCases := []struct {
+		desc, code, want string
+	}{
+		{
+			desc: "Redefined constant",
+			code: ".equ STDOUT 7",
+			want: `line 1: constant "STDOUT" already defined`,
+		},
+		{
+			desc: "Org moving backwards",
+			code: "HALT\nHALT\n.org 1",
+			want: "line 3: .org 1 is before the current address 2",
+		},
+		{
+			desc: "Unknown directive",
+			code: "HALT\n.byte 1",
+			want: "line 2: unknown directive .byte",
+		},
+		{
+			desc: "Unquoted string",
+			code: ".string Hi",
+			want: "line 1: invalid string Hi",
+		},
+		{
+			desc: "Undefined space size",
+			code: ".space SIZE",
+			want: `line 1: undefined symbol "SIZE"`,
+		},
+		{
+			desc: "Negative space size",
+			code: "HALT\n.space -1",
+			want: "line 2: negative .space size -1",
+		},
+		{
+			desc: "Negative org",
+			code: ".org -1",
+			want: "line 1: .org -1 is before the current address 0",
+		},
+		{
+			desc: "Huge space",
+			code: "HALT\n.space 1<<40",
+			want: "line 2: program would be larger than 1048576 words",
+		},
+		{
+			desc: "Org past the largest program",
+			code: "HALT\n.org 1<<20+1",
+			want: "line 2: program would be larger than 1048576 words",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := gmachine.AssembleFromText(tC.code)
+			var asmErr *gmachine.AssemblyError
+			if !errors.As(err, &asmErr) {
+				t.Fatalf("want *gmachine.AssemblyError, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tC.want) {
+				t.Errorf("want error containing %q, got %q", tC.want, err)
+			}
+		})
+	}
+}
+
+func TestAssembleConstantsScopedToRun(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.AssembleFromText(".equ SCOPED 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gmachine.PredefinedConstants["SCOPED"]; ok {
+		t.Error("want .equ constant not added to PredefinedConstants")
+	}
+	_, err = gmachine.AssembleFromText(".equ SCOPED 2")
+	if err != nil {
+		t.Errorf("want constant redefinable in a new run, got %v", err)
+	}
+}
+
+func TestAssembleHelloWorldDirectives(t *testing.T) {
+	t.Parallel()
+	words, err := gmachine.AssembleFromText(`
+		JUMP start
+	message:
+		.stringz "Hello, World"
+	start:
+		SETI message
+	loop:
+		SETA [I]
+		CMPA 0
+		JEQ print
+		HALT
+	print:
+		BIOS IOWRITE STDOUT
+		INCI
+		JUMP loop
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	g.RunProgram(words)
+	want := "Hello, World"
+	got := buf.String()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
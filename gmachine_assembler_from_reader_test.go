@@ -3,6 +3,7 @@ package gmachine_test
 import (
 	"bytes"
 	"gmachine"
+	"strings"
 	"testing"
 )
 
@@ -129,3 +130,53 @@ func TestRETNFromReader(t *testing.T) {
 		t.Errorf("want initial N value %d, got %d", wantN, g.N)
 	}
 }
+
+func TestAssembleProgramFromReaderInclude(t *testing.T) {
+	t.Parallel()
+	program, err := gmachine.AssembleProgramFromReader("", strings.NewReader(`
+		SETI message
+		CALL print      ; from an included file
+		HALT
+	message:
+		.stringz "Hi"
+	.include "testdata/include/lib/print.gasm"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Hi"
+	got := runForOutput(t, program.Words)
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestAssembleFromReaderNoIncludes(t *testing.T) {
+	t.Parallel()
+	for _, source := range []string{
+		`.include "testdata/include/lib/print.gasm"`,
+		`.include "/etc/hostname"`,
+	} {
+		_, err := gmachine.AssembleFromReader(strings.NewReader("HALT\n" + source))
+		if err == nil || !strings.Contains(err.Error(), "line 2: cannot include files") {
+			t.Errorf("%s: want error for include, got %v", source, err)
+		}
+	}
+}
+
+func TestAssembleFromReaderEmpty(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.AssembleFromReader(strings.NewReader("; nothing but a comment\n"))
+	if err == nil {
+		t.Error("want error for empty source")
+	}
+}
+
+func TestAssembleProgramFromReaderPositions(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.AssembleProgramFromReader("<stdin>", strings.NewReader("INCA\nJUMP nowhere\n"))
+	want := `<stdin>:2: undefined symbol "nowhere"`
+	if err == nil || want != err.Error() {
+		t.Errorf("want %q, got %v", want, err)
+	}
+}
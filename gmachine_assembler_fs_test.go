@@ -0,0 +1,107 @@
+package gmachine_test
+
+import (
+	"errors"
+	"gmachine"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAssembleFS(t *testing.T) {
+	t.Parallel()
+	words, err := gmachine.AssembleFS(os.DirFS("testdata/include"), "main.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := gmachine.AssembleFromFile("testdata/include/main.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, words) {
+		t.Error(cmp.Diff(want, words))
+	}
+}
+
+func TestAssembleFSInclude(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"src/main.gasm":     {Data: []byte(".include \"lib/two.gasm\"\nSETA TWO\n")},
+		"src/lib/two.gasm":  {Data: []byte(".include \"../const.gasm\"\n.equ TWO ONE+1\n")},
+		"src/const.gasm":    {Data: []byte(".equ ONE 1\n")},
+		"src/cycle_a.gasm":  {Data: []byte(".include \"cycle_b.gasm\"\n")},
+		"src/cycle_b.gasm":  {Data: []byte(".include \"cycle_a.gasm\"\n")},
+		"src/escape.gasm":   {Data: []byte(".include \"../../outside.gasm\"\n")},
+		"src/missing.gasm":  {Data: []byte(".include \"nothere.gasm\"\n")},
+		"src/unrelated.txt": {Data: []byte("not source")},
+	}
+	words, err := gmachine.AssembleFS(fsys, "src/main.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []gmachine.Word{gmachine.SETA, 2}
+	if !cmp.Equal(want, words) {
+		t.Error(cmp.Diff(want, words))
+	}
+	testCases := []struct {
+		path, wantErr string
+	}{
+		{path: "src/cycle_a.gasm", wantErr: "include cycle: src/cycle_a.gasm -> src/cycle_b.gasm -> src/cycle_a.gasm"},
+		{path: "src/escape.gasm", wantErr: "invalid argument"},
+		{path: "src/missing.gasm", wantErr: "file does not exist"},
+	}
+	for _, tC := range testCases {
+		_, err := gmachine.AssembleFS(fsys, tC.path)
+		if err == nil || !strings.Contains(err.Error(), tC.wantErr) {
+			t.Errorf("%s: want error containing %q, got %v", tC.path, tC.wantErr, err)
+		}
+	}
+}
+
+func TestAssembleProgramFS(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"main.gasm": {Data: []byte("start: SETA 1\nHALT\n")},
+	}
+	program, err := gmachine.AssembleProgramFS(fsys, "main.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []gmachine.Word{gmachine.SETA, 1, gmachine.HALT}
+	if !cmp.Equal(want, program.Words) {
+		t.Error(cmp.Diff(want, program.Words))
+	}
+	if pos := program.Listing[1].Pos; pos.File != "main.gasm" || pos.Line != 2 {
+		t.Errorf("want HALT at main.gasm:2, got %s", pos)
+	}
+	if sym, ok := program.Symbols.Find("start"); !ok || sym.Value != 0 {
+		t.Errorf("want label start at 0, got %+v", sym)
+	}
+}
+
+func TestAssembleProgramFSLimit(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"fits.gasm":  {Data: []byte("HALT\n.space 3\n")},
+		"space.gasm": {Data: []byte("HALT\n.space 4\n")},
+		"org.gasm":   {Data: []byte("HALT\n.org 5\n")},
+		"huge.gasm":  {Data: []byte(".space 0x7fffffffffffffff\n")},
+	}
+	program, err := gmachine.AssembleProgramFSLimit(fsys, 4, "fits.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Words) != 4 {
+		t.Errorf("want 4 words, got %d", len(program.Words))
+	}
+	for _, path := range []string{"space.gasm", "org.gasm", "huge.gasm"} {
+		_, err := gmachine.AssembleProgramFSLimit(fsys, 4, path)
+		var aerr *gmachine.AssemblyError
+		if !errors.As(err, &aerr) || !strings.Contains(err.Error(), "larger than 4 words") {
+			t.Errorf("%s: want assembly error for program larger than 4 words, got %v", path, err)
+		}
+	}
+}
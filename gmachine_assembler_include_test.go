@@ -0,0 +1,88 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"gmachine"
+	"strings"
+	"testing"
+)
+
+func runForOutput(t *testing.T, words []gmachine.Word) string {
+	t.Helper()
+	g := gmachine.New()
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	g.RunProgram(words)
+	return buf.String()
+}
+
+func TestAssembleInclude(t *testing.T) {
+	t.Parallel()
+	words, err := gmachine.AssembleFromFile("testdata/include/main.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Hi"
+	got := runForOutput(t, words)
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestAssembleFromTextInclude(t *testing.T) {
+	t.Parallel()
+	// Text held in memory has no directory to include files from, and
+	// mustn't be able to read the host's.
+	_, err := gmachine.AssembleFromText(`
+		SETI message
+		CALL print
+		HALT
+	message:
+		.stringz "Hi"
+	.include "testdata/include/lib/print.gasm"
+	`)
+	want := "line 7: cannot include files"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("want error containing %q, got %v", want, err)
+	}
+}
+
+func TestAssembleFiles(t *testing.T) {
+	t.Parallel()
+	words, err := gmachine.AssembleFiles(
+		"testdata/include/multi.gasm",
+		"testdata/include/lib/print.gasm",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Hi"
+	got := runForOutput(t, words)
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestAssembleIncludeCycle(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.AssembleFromFile("testdata/include/cycle_a.gasm")
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	want := "testdata/include/cycle_b.gasm:1: include cycle:"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("want error containing %q, got %q", want, err)
+	}
+}
+
+func TestAssembleIncludeMissing(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.AssembleProgramFromReader("", strings.NewReader("HALT\n.include \"testdata/missing.gasm\""))
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	want := "line 2:"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("want error containing %q, got %q", want, err)
+	}
+}
@@ -0,0 +1,146 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"errors"
+	"gmachine"
+	"strings"
+	"testing"
+)
+
+func TestAssembleMacro(t *testing.T) {
+	t.Parallel()
+	words, err := gmachine.AssembleFromText(`
+.macro putc c
+	SETA c
+	BIOS IOWRITE STDOUT
+.endm
+		putc 72
+		putc 105
+		HALT
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	g.RunProgram(words)
+	want := "Hi"
+	got := buf.String()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestAssembleMacroLocalLabels(t *testing.T) {
+	t.Parallel()
+	words, err := gmachine.AssembleFromText(`
+.macro countto n
+	SETA 0
+	loop:
+	INCA
+	CMPA n
+	JEQ loop
+.endm
+		countto 3
+		SETI 0
+		countto 5
+		HALT
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	g.RunProgram(words)
+	var wantA gmachine.Word = 5
+	if wantA != g.A {
+		t.Errorf("want A value %d, got %d", wantA, g.A)
+	}
+}
+
+func TestAssembleNestedMacro(t *testing.T) {
+	t.Parallel()
+	words, err := gmachine.AssembleFromText(`
+.macro putc c
+	SETA c
+	BIOS IOWRITE STDOUT
+.endm
+.macro twice c
+	putc c
+	putc c
+.endm
+		twice 111
+		HALT
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	g.RunProgram(words)
+	want := "oo"
+	got := buf.String()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestAssembleMacroErrors(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc, code, want string
+	}{
+		{
+			desc: "Recursive macro",
+			code: ".macro forever\nforever\n.endm\nforever",
+			want: "line 2: macro expansion nested deeper than 16 (expanded from line 2) (expanded from line 4)",
+		},
+		{
+			desc: "Missing .endm",
+			code: ".macro putc c\nSETA c",
+			want: "line 1: missing .endm",
+		},
+		{
+			desc: "Missing argument",
+			code: ".macro putc c\nSETA c\n.endm\nputc",
+			want: "line 4: missing argument",
+		},
+		{
+			desc: "Argument on the next line",
+			code: ".macro pair a b\n.word a, b\n.endm\npair 1\nHALT",
+			want: `line 4: missing argument for macro "pair"`,
+		},
+		{
+			desc: "Too many arguments",
+			code: ".macro putc c\nSETA c\n.endm\nputc 1 2",
+			want: `line 4: too many arguments for macro "putc"`,
+		},
+		{
+			desc: "Instruction name",
+			code: ".macro SETA c\n.endm",
+			want: "is an instruction",
+		},
+		{
+			desc: "Undefined symbol in macro body",
+			code: ".macro escape\nJUMP nowhere\n.endm\nHALT\nescape",
+			want: `line 2: undefined symbol "nowhere" (expanded from line 5)`,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := gmachine.AssembleFromText(tC.code)
+			if err == nil {
+				t.Fatal("want error, got nil")
+			}
+			if !strings.Contains(err.Error(), tC.want) {
+				t.Errorf("want error containing %q, got %q", tC.want, err)
+			}
+			var asmErr *gmachine.AssemblyError
+			if !errors.As(err, &asmErr) {
+				t.Errorf("want *gmachine.AssemblyError, got %T", err)
+			}
+		})
+	}
+}
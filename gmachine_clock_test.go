@@ -0,0 +1,108 @@
+package gmachine_test
+
+import (
+	"gmachine"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept += d
+	c.now = c.now.Add(d)
+}
+
+func TestClockTime(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.Clock = &fakeClock{now: time.Unix(1, 500*int64(time.Millisecond))}
+	g.RunProgram([]gmachine.Word{
+		gmachine.BIOS, gmachine.ClockTime, gmachine.PortClock,
+		gmachine.HALT,
+	})
+	var wantA gmachine.Word = 1500
+	if wantA != g.A {
+		t.Errorf("want A value %d, got %d", wantA, g.A)
+	}
+}
+
+func TestClockSleep(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	clock := &fakeClock{}
+	g.Clock = clock
+	g.RunProgram([]gmachine.Word{
+		gmachine.SETA, 250,
+		gmachine.BIOS, gmachine.ClockSleep, gmachine.PortClock,
+		gmachine.HALT,
+	})
+	want := 250 * time.Millisecond
+	if want != clock.slept {
+		t.Errorf("want sleep of %v, got %v", want, clock.slept)
+	}
+}
+
+func TestClockSleepTooLong(t *testing.T) {
+	t.Parallel()
+	for _, ms := range []gmachine.Word{gmachine.Word(gmachine.MaxSleep/time.Millisecond) + 1, 1 << 62, math.MaxUint64} {
+		g := gmachine.New()
+		clock := &fakeClock{}
+		g.Clock = clock
+		err := g.RunProgram([]gmachine.Word{
+			gmachine.SETA, ms,
+			gmachine.BIOS, gmachine.ClockSleep, gmachine.PortClock,
+			gmachine.HALT,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if clock.slept != 0 || g.E != gmachine.ErrorInvalid {
+			t.Errorf("%d ms: want no sleep and error %d, got sleep of %v and error %d", ms, gmachine.ErrorInvalid, clock.slept, g.E)
+		}
+	}
+}
+
+func TestClockCycles(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.RunProgram([]gmachine.Word{
+		gmachine.NOOP,
+		gmachine.NOOP,
+		gmachine.BIOS, gmachine.ClockCycles, gmachine.PortClock,
+		gmachine.HALT,
+	})
+	var wantA gmachine.Word = 3
+	if wantA != g.A {
+		t.Errorf("want A value %d, got %d", wantA, g.A)
+	}
+	var wantCycles gmachine.Word = 4
+	if wantCycles != g.Cycles {
+		t.Errorf("want Cycles value %d, got %d", wantCycles, g.Cycles)
+	}
+}
+
+func TestRandomSeeded(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.Rand = rand.New(rand.NewSource(0))
+	g.RunProgram([]gmachine.Word{
+		gmachine.SETA, 42,
+		gmachine.BIOS, gmachine.RandomSeed, gmachine.PortRandom,
+		gmachine.BIOS, gmachine.RandomNext, gmachine.PortRandom,
+		gmachine.HALT,
+	})
+	want := gmachine.Word(rand.New(rand.NewSource(42)).Uint64())
+	if want != g.A {
+		t.Errorf("want A value %d, got %d", want, g.A)
+	}
+}
@@ -0,0 +1,142 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"gmachine"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBinaryDebugInfoRoundTrip(t *testing.T) {
+	t.Parallel()
+	program, err := gmachine.AssembleProgram("testdata/listing/count.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := program.DebugInfo()
+	buf := &bytes.Buffer{}
+	err = gmachine.WriteBinary(buf, program.Words, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words, got, err := gmachine.ReadBinary(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(program.Words, words) {
+		t.Error(cmp.Diff(program.Words, words))
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestReadBinaryPlain(t *testing.T) {
+	t.Parallel()
+	want := []gmachine.Word{gmachine.SETA, 5, gmachine.HALT}
+	buf := &bytes.Buffer{}
+	err := gmachine.WriteBinary(buf, want, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, debug, err := gmachine.ReadBinary(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if debug != nil {
+		t.Errorf("want no debug info, got %+v", debug)
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestReadBinaryTruncated(t *testing.T) {
+	t.Parallel()
+	program, err := gmachine.AssembleProgram("testdata/listing/count.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	err = gmachine.WriteBinary(buf, program.Words, program.DebugInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = gmachine.ReadBinary(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if err == nil {
+		t.Error("want error for truncated binary")
+	}
+}
+
+func TestReadBinaryHugeCounts(t *testing.T) {
+	// A short header claiming the largest counts allowed mustn't make the
+	// reader allocate for them up front.
+	huge := []byte{0, 0, 0, 0, 1, 0, 0, 0}
+	inputs := map[string][]byte{
+		"words":   append([]byte("GBIN\x00\x00\x00\x01"), huge...),
+		"lines":   append([]byte("GBIN\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00"), huge...),
+		"strings": append([]byte("GBIN\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01"), huge...),
+	}
+	for desc, input := range inputs {
+		var err error
+		n := allocated(func() {
+			_, _, err = gmachine.ReadBinary(bytes.NewReader(input))
+		})
+		if err == nil {
+			t.Errorf("%s: want error for truncated binary", desc)
+		}
+		if n > 1<<20 {
+			t.Errorf("%s: want less than 1MiB allocated, got %d bytes", desc, n)
+		}
+	}
+}
+
+func TestDebugInfoLine(t *testing.T) {
+	t.Parallel()
+	program, err := gmachine.AssembleProgram("testdata/listing/count.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	debug := program.DebugInfo()
+	testCases := []struct {
+		addr   gmachine.Word
+		want   int
+		wantOK bool
+	}{
+		{addr: 0, want: 7, wantOK: true},
+		{addr: 1, want: 7, wantOK: true},
+		{addr: 2, want: 4, wantOK: true},
+		{addr: 8, want: 12, wantOK: true},
+		{addr: 9, want: 14, wantOK: true},
+		{addr: 10},
+	}
+	for _, tC := range testCases {
+		pos, ok := debug.Line(tC.addr)
+		if tC.wantOK != ok || tC.want != pos.Line {
+			t.Errorf("at %d: want line %d (%t), got %d (%t)", tC.addr, tC.want, tC.wantOK, pos.Line, ok)
+		}
+	}
+}
+
+func TestRunProgramFromReaderDebugFault(t *testing.T) {
+	t.Parallel()
+	program, err := gmachine.AssembleProgram("testdata/listing/count.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	err = gmachine.WriteBinary(buf, program.Words, program.DebugInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	err = g.RunProgramFromReader(buf)
+	want := "fault at testdata/listing/count.gasm:14 (bad): invalid opcode 99"
+	if err == nil || want != err.Error() {
+		t.Errorf("want %q, got %v", want, err)
+	}
+	if g.Debug == nil {
+		t.Error("want debug info kept after loading")
+	}
+}
@@ -0,0 +1,34 @@
+package gmachine_test
+
+import (
+	"gmachine"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDefinitions(t *testing.T) {
+	t.Parallel()
+	src := `; definitions
+.equ LEN 2 + 3
+    .macro twice op
+        op
+        op
+    .endm
+start:  SETA LEN
+  a: b: INCA
+    .string "not: a label"
+broken "
+`
+	want := []gmachine.Definition{
+		{Name: "LEN", Kind: gmachine.DefinitionConstant, Line: 2, Col: 5, Detail: "2 + 3"},
+		{Name: "twice", Kind: gmachine.DefinitionMacro, Line: 3, Col: 11, Detail: "op"},
+		{Name: "start", Kind: gmachine.DefinitionLabel, Line: 7, Col: 0},
+		{Name: "a", Kind: gmachine.DefinitionLabel, Line: 8, Col: 2},
+		{Name: "b", Kind: gmachine.DefinitionLabel, Line: 8, Col: 5},
+	}
+	got := gmachine.Definitions([]byte(src))
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
@@ -0,0 +1,116 @@
+package gmachine_test
+
+import (
+	"errors"
+	"gmachine"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDisk(t *testing.T, blocks int) *gmachine.Disk {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.gdisk")
+	err := gmachine.CreateDisk(path, blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, err := gmachine.OpenDisk(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { disk.Close() })
+	return disk
+}
+
+func TestCreateDisk(t *testing.T) {
+	t.Parallel()
+	disk := newTestDisk(t, 4)
+	var wantBlocks gmachine.Word = 4
+	if wantBlocks != disk.Blocks() {
+		t.Errorf("want %d blocks, got %d", wantBlocks, disk.Blocks())
+	}
+}
+
+func TestDiskWriteThenRead(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.Disk = newTestDisk(t, 4)
+	g.Memory[100] = 'G'
+	g.Memory[100+gmachine.BlockSize-1] = 'Z'
+	g.RunProgram([]gmachine.Word{
+		gmachine.SETA, 2,
+		gmachine.BIOS, gmachine.DiskSeek, gmachine.PortDisk,
+		gmachine.SETI, 100,
+		gmachine.BIOS, gmachine.DiskWrite, gmachine.PortDisk,
+		gmachine.BIOS, gmachine.DiskSeek, gmachine.PortDisk,
+		gmachine.SETI, 200,
+		gmachine.BIOS, gmachine.DiskRead, gmachine.PortDisk,
+		gmachine.HALT,
+	})
+	if g.E != gmachine.ErrorNone {
+		t.Fatalf("want E value %d, got %d", gmachine.ErrorNone, g.E)
+	}
+	if g.Memory[200] != 'G' || g.Memory[200+gmachine.BlockSize-1] != 'Z' {
+		t.Errorf("want block read back into memory, got %v", g.Memory[200:200+gmachine.BlockSize])
+	}
+	var wantBlock gmachine.Word = 3
+	if wantBlock != g.Disk.Block() {
+		t.Errorf("want current block %d, got %d", wantBlock, g.Disk.Block())
+	}
+}
+
+func TestDiskShortBuffer(t *testing.T) {
+	t.Parallel()
+	disk := newTestDisk(t, 4)
+	buf := make([]gmachine.Word, gmachine.BlockSize-1)
+	if err := disk.ReadBlock(buf); !errors.Is(err, gmachine.ErrShortBuffer) {
+		t.Errorf("read: want ErrShortBuffer, got %v", err)
+	}
+	if err := disk.WriteBlock(buf); !errors.Is(err, gmachine.ErrShortBuffer) {
+		t.Errorf("write: want ErrShortBuffer, got %v", err)
+	}
+	var wantBlock gmachine.Word = 0
+	if wantBlock != disk.Block() {
+		t.Errorf("want current block %d, got %d", wantBlock, disk.Block())
+	}
+}
+
+func TestDiskErrors(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc    string
+		disk    bool
+		program []gmachine.Word
+		wantE   gmachine.Word
+	}{
+		{
+			desc:    "No disk attached",
+			program: []gmachine.Word{gmachine.BIOS, gmachine.DiskRead, gmachine.PortDisk},
+			wantE:   gmachine.ErrorNoDevice,
+		},
+		{
+			desc:    "Seek past the last block",
+			disk:    true,
+			program: []gmachine.Word{gmachine.SETA, 4, gmachine.BIOS, gmachine.DiskSeek, gmachine.PortDisk},
+			wantE:   gmachine.ErrorInvalid,
+		},
+		{
+			desc:    "Buffer past the end of memory",
+			disk:    true,
+			program: []gmachine.Word{gmachine.SETI, gmachine.DefaultMemSize - 1, gmachine.BIOS, gmachine.DiskRead, gmachine.PortDisk},
+			wantE:   gmachine.ErrorInvalid,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			g := gmachine.New()
+			if tC.disk {
+				g.Disk = newTestDisk(t, 4)
+			}
+			g.RunProgram(tC.program)
+			if tC.wantE != g.E {
+				t.Errorf("want E value %d, got %d", tC.wantE, g.E)
+			}
+		})
+	}
+}
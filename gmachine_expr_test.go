@@ -0,0 +1,162 @@
+package gmachine_test
+
+import (
+	"errors"
+	"gmachine"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEvalExpression(t *testing.T) {
+	t.Parallel()
+	symbols := map[string]gmachine.Word{
+		"START": 10,
+		"END":   30,
+		"LEN":   4,
+	}
+	lookup := func(name string) (gmachine.Word, bool) {
+		word, ok := symbols[name]
+		return word, ok
+	}
+	testCases := []struct {
+		code string
+		want gmachine.Word
+	}{
+		{code: "42", want: 42},
+		{code: "0x2A", want: 42},
+		{code: "0b101010", want: 42},
+		{code: "'A'", want: 65},
+		{code: `'\n'`, want: 10},
+		{code: "'A'|0x80", want: 0xC1},
+		{code: "LEN-1", want: 3},
+		{code: "START+LEN*2", want: 18},
+		{code: "(END-START)/2", want: 10},
+		{code: "(START+LEN)*2", want: 28},
+		{code: "1<<4|1", want: 17},
+		{code: "END%7&3", want: 2},
+		{code: "~0", want: 0xFFFFFFFFFFFFFFFF},
+		{code: "-1", want: 0xFFFFFFFFFFFFFFFF},
+		{code: "0xFFFFFFFFFFFFFFFF", want: 0xFFFFFFFFFFFFFFFF},
+		{code: "( END - START ) / 2", want: 10},
+	}
+	for _, tC := range testCases {
+		got, err := gmachine.EvalExpression(tC.code, lookup)
+		if err != nil {
+			t.Errorf("%s: %v", tC.code, err)
+			continue
+		}
+		if tC.want != got {
+			t.Errorf("%s: want %d, got %d", tC.code, tC.want, got)
+		}
+	}
+}
+
+func TestEvalExpressionErrors(t *testing.T) {
+	t.Parallel()
+	lookup := func(name string) (gmachine.Word, bool) {
+		return 0, false
+	}
+	testCases := []struct {
+		code    string
+		wantErr error
+	}{
+		{code: "9223372036854775807+1", wantErr: gmachine.ErrOverflow},
+		{code: "4611686018427387904*2", wantErr: gmachine.ErrOverflow},
+		{code: "1/0", wantErr: gmachine.ErrDivisionByZero},
+		{code: "(1+2", wantErr: nil},
+		{code: "1+", wantErr: nil},
+		{code: "1 2", wantErr: nil},
+	}
+	for _, tC := range testCases {
+		_, err := gmachine.EvalExpression(tC.code, lookup)
+		if err == nil {
+			t.Errorf("%s: want error, got nil", tC.code)
+			continue
+		}
+		if tC.wantErr != nil && !errors.Is(err, tC.wantErr) {
+			t.Errorf("%s: want error %v, got %v", tC.code, tC.wantErr, err)
+		}
+	}
+	_, err := gmachine.EvalExpression("MISSING+1", lookup)
+	var undefined *gmachine.UndefinedSymbolError
+	if !errors.As(err, &undefined) || undefined.Name != "MISSING" {
+		t.Errorf("want undefined symbol MISSING, got %v", err)
+	}
+}
+
+func TestAssembleExpressions(t *testing.T) {
+	t.Parallel()
+	got, err := gmachine.AssembleFromText(`
+		.equ LEN 4
+		.equ SIZE LEN * 2
+	start:
+		SETA buffer+4
+		CMPI LEN-1
+		.word 'A'|0x80
+		SETA (end-start)/2
+		.space SIZE-LEN
+	buffer:
+	end:
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []gmachine.Word{
+		gmachine.SETA, 15,
+		gmachine.CMPI, 3,
+		0xC1,
+		gmachine.SETA, 5,
+		0, 0, 0, 0,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestAssembleMacroExpressionArguments(t *testing.T) {
+	t.Parallel()
+	got, err := gmachine.AssembleFromText(`
+.macro double x
+	.word x*2
+	here:
+	.word here+1
+.endm
+		double 1+2
+		double 1
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []gmachine.Word{6, 2, 2, 4}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestAssembleExpressionErrors(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		code, want string
+	}{
+		{
+			code: "HALT\nSETA 9223372036854775807+1",
+			want: "line 2: arithmetic overflow",
+		},
+		{
+			code: "HALT\nHALT\nJUMP nowhere+1",
+			want: `line 3: undefined symbol "nowhere"`,
+		},
+		{
+			code: ".equ X LATER\n.equ LATER 1",
+			want: `line 1: undefined symbol "LATER"`,
+		},
+	}
+	for _, tC := range testCases {
+		_, err := gmachine.AssembleFromText(tC.code)
+		if err == nil || !strings.Contains(err.Error(), tC.want) {
+			t.Errorf("want error containing %q, got %v", tC.want, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"errors"
+	"gmachine"
+	"strings"
+	"testing"
+)
+
+func TestFaultInvalidOpcode(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.NOOP, 999})
+	var fault *gmachine.Fault
+	if !errors.As(err, &fault) {
+		t.Fatalf("want *gmachine.Fault, got %v", err)
+	}
+	var wantP gmachine.Word = 1
+	if wantP != fault.P {
+		t.Errorf("want fault P %d, got %d", wantP, fault.P)
+	}
+	want := "fault at P=1: invalid opcode 999"
+	if want != err.Error() {
+		t.Errorf("want %q, got %q", want, err)
+	}
+}
+
+func TestFaultInvalidOpcodeStopsMachine(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.SETA, 1, 999, gmachine.INCA, gmachine.HALT})
+	var fault *gmachine.Fault
+	if !errors.As(err, &fault) {
+		t.Fatalf("want *gmachine.Fault, got %v", err)
+	}
+	var wantA gmachine.Word = 1
+	if wantA != g.A {
+		t.Errorf("want A %d after fault, got %d", wantA, g.A)
+	}
+}
+
+func TestRunHaltReturnsNil(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.INCA, gmachine.HALT})
+	if err != nil {
+		t.Errorf("want nil error, got %v", err)
+	}
+}
+
+func TestFaultMemoryOutOfRange(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc    string
+		program []gmachine.Word
+	}{
+		{
+			desc:    "Load through I",
+			program: []gmachine.Word{gmachine.SETI, gmachine.DefaultMemSize, gmachine.SETAM},
+		},
+		{
+			desc:    "Jump past the end of memory",
+			program: []gmachine.Word{gmachine.JUMP, gmachine.DefaultMemSize},
+		},
+		{
+			desc:    "Store through I",
+			program: []gmachine.Word{gmachine.SETI, gmachine.DefaultMemSize, gmachine.STORE},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			g := gmachine.New()
+			err := g.RunProgram(tC.program)
+			if err == nil || !strings.Contains(err.Error(), "out of range") {
+				t.Errorf("want out of range fault, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFaultDivisionByZero(t *testing.T) {
+	t.Parallel()
+	for _, opcode := range []gmachine.Word{gmachine.DIVM, gmachine.MODM} {
+		g := gmachine.New()
+		err := g.RunProgram([]gmachine.Word{gmachine.SETI, 10, opcode})
+		want := "fault at P=2: division by zero"
+		if err == nil || want != err.Error() {
+			t.Errorf("opcode %d: want %q, got %v", opcode, want, err)
+		}
+	}
+}
+
+func TestRunProgramTooBig(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	program := make([]gmachine.Word, gmachine.DefaultMemSize+1)
+	program[0] = gmachine.INCA
+	err := g.RunProgram(program)
+	want := "program of 1025 words does not fit in 1024 words of memory"
+	if err == nil || want != err.Error() {
+		t.Errorf("want %q, got %v", want, err)
+	}
+	var fault *gmachine.Fault
+	if errors.As(err, &fault) {
+		t.Error("want a load error, not a fault")
+	}
+	if g.Memory[0] != gmachine.HALT || g.A != 0 {
+		t.Error("want program not loaded or run")
+	}
+}
+
+func TestFaultSymbolic(t *testing.T) {
+	t.Parallel()
+	program, err := gmachine.AssembleProgram("testdata/listing/count.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	g.Symbols = program.Symbols
+	err = g.RunProgram(program.Words)
+	want := "fault at P=9 (bad): invalid opcode 99"
+	if err == nil || want != err.Error() {
+		t.Errorf("want %q, got %v", want, err)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+	program, err := gmachine.AssembleProgram("testdata/listing/count.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	g.Symbols = program.Symbols
+	buf := &bytes.Buffer{}
+	g.Trace = buf
+	g.RunProgram(program.Words)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 9 {
+		t.Fatalf("want 9 trace lines, got %d:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []string{"P=0 (start):", "SETA 2", "A=0 I=0"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("want first trace line containing %q, got %q (check %d)", want, lines[0], i)
+		}
+	}
+	if !strings.Contains(lines[2], "P=3 (loop+1):") {
+		t.Errorf("want symbolic address loop+1, got %q", lines[2])
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	t.Parallel()
+	memory := []gmachine.Word{
+		gmachine.BIOS, gmachine.IOWrite, gmachine.PortStdout,
+		gmachine.SETAM,
+		999,
+		gmachine.SETA,
+	}
+	testCases := []struct {
+		addr     gmachine.Word
+		want     string
+		wantSize int
+	}{
+		{addr: 0, want: "BIOS 0 1", wantSize: 3},
+		{addr: 3, want: "SETA [I]", wantSize: 1},
+		{addr: 4, want: ".word 999", wantSize: 1},
+		{addr: 5, want: "SETA ?", wantSize: 2},
+	}
+	for _, tC := range testCases {
+		got, size := gmachine.Disassemble(memory, tC.addr)
+		if tC.want != got || tC.wantSize != size {
+			t.Errorf("at %d: want %q (%d words), got %q (%d words)", tC.addr, tC.want, tC.wantSize, got, size)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"gmachine"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadString stores s as a zero-terminated string in memory at addr.
+func loadString(g *gmachine.GMachine, addr int, s string) {
+	for i, r := range s {
+		g.Memory[addr+i] = gmachine.Word(r)
+	}
+	g.Memory[addr+len(s)] = 0
+}
+
+func TestFileReadWrite(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	err := os.WriteFile(filepath.Join(root, "in.txt"), []byte("G"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	g.FileRoot = root
+	loadString(g, 100, "in.txt")
+	loadString(g, 200, "out.txt")
+	g.RunProgram([]gmachine.Word{
+		gmachine.SETI, 100,
+		gmachine.SETA, gmachine.FileModeRead,
+		gmachine.BIOS, gmachine.FileOpen, gmachine.PortFile,
+		gmachine.SETI, 300,
+		gmachine.BIOS, gmachine.FileRead, gmachine.PortFile,
+		gmachine.BIOS, gmachine.FileClose, gmachine.PortFile,
+		gmachine.SETI, 200,
+		gmachine.SETA, gmachine.FileModeWrite,
+		gmachine.BIOS, gmachine.FileOpen, gmachine.PortFile,
+		gmachine.SETI, 300,
+		gmachine.BIOS, gmachine.FileWrite, gmachine.PortFile,
+		gmachine.BIOS, gmachine.FileClose, gmachine.PortFile,
+		gmachine.HALT,
+	})
+	if g.E != gmachine.ErrorNone {
+		t.Fatalf("want E value %d, got %d", gmachine.ErrorNone, g.E)
+	}
+	got, err := os.ReadFile(filepath.Join(root, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "G" {
+		t.Errorf("want %q, got %q", "G", got)
+	}
+}
+
+func TestFileReadEOF(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	err := os.WriteFile(filepath.Join(root, "empty.txt"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	g.FileRoot = root
+	defer g.CloseFiles()
+	loadString(g, 100, "empty.txt")
+	g.RunProgram([]gmachine.Word{
+		gmachine.SETI, 100,
+		gmachine.BIOS, gmachine.FileOpen, gmachine.PortFile,
+		gmachine.BIOS, gmachine.FileRead, gmachine.PortFile,
+		gmachine.HALT,
+	})
+	if g.E != gmachine.ErrorEOF {
+		t.Errorf("want E value %d, got %d", gmachine.ErrorEOF, g.E)
+	}
+}
+
+func TestFileOpenErrors(t *testing.T) {
+	t.Parallel()
+	root := t.TempDir()
+	testCases := []struct {
+		desc, root, path string
+		wantE            gmachine.Word
+	}{
+		{
+			desc:  "No file root",
+			path:  "in.txt",
+			wantE: gmachine.ErrorNoDevice,
+		},
+		{
+			desc:  "Missing file",
+			root:  root,
+			path:  "missing.txt",
+			wantE: gmachine.ErrorNotFound,
+		},
+		{
+			desc:  "Parent directory is confined to the root",
+			root:  filepath.Join(root, "sub"),
+			path:  "../../etc/passwd",
+			wantE: gmachine.ErrorNotFound,
+		},
+	}
+	err := os.Mkdir(filepath.Join(root, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			g := gmachine.New()
+			g.FileRoot = tC.root
+			loadString(g, 100, tC.path)
+			g.RunProgram([]gmachine.Word{
+				gmachine.SETI, 100,
+				gmachine.BIOS, gmachine.FileOpen, gmachine.PortFile,
+				gmachine.HALT,
+			})
+			if tC.wantE != g.E {
+				t.Errorf("want E value %d, got %d", tC.wantE, g.E)
+			}
+		})
+	}
+}
+
+func TestFileSymlinkOutsideRoot(t *testing.T) {
+	t.Parallel()
+	outside := t.TempDir()
+	root := t.TempDir()
+	err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt"))
+	if err != nil {
+		t.Skip(err)
+	}
+	g := gmachine.New()
+	g.FileRoot = root
+	loadString(g, 100, "link.txt")
+	g.RunProgram([]gmachine.Word{
+		gmachine.SETI, 100,
+		gmachine.BIOS, gmachine.FileOpen, gmachine.PortFile,
+		gmachine.HALT,
+	})
+	if g.E != gmachine.ErrorPermission {
+		t.Errorf("want E value %d, got %d", gmachine.ErrorPermission, g.E)
+	}
+}
+
+func TestFileDanglingSymlinkOutsideRoot(t *testing.T) {
+	t.Parallel()
+	outside := t.TempDir()
+	root := t.TempDir()
+	target := filepath.Join(outside, "created.txt")
+	err := os.Symlink(target, filepath.Join(root, "link.txt"))
+	if err != nil {
+		t.Skip(err)
+	}
+	g := gmachine.New()
+	g.FileRoot = root
+	loadString(g, 100, "link.txt")
+	g.RunProgram([]gmachine.Word{
+		gmachine.SETI, 100,
+		gmachine.SETA, gmachine.FileModeWrite,
+		gmachine.BIOS, gmachine.FileOpen, gmachine.PortFile,
+		gmachine.HALT,
+	})
+	if g.E != gmachine.ErrorPermission {
+		t.Errorf("want E value %d, got %d", gmachine.ErrorPermission, g.E)
+	}
+	if _, err := os.Lstat(target); !os.IsNotExist(err) {
+		t.Errorf("want no file created outside the root, got %v", err)
+	}
+}
+
+func TestFileBadDescriptor(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.FileRoot = t.TempDir()
+	g.RunProgram([]gmachine.Word{
+		gmachine.SETA, 7,
+		gmachine.BIOS, gmachine.FileRead, gmachine.PortFile,
+		gmachine.HALT,
+	})
+	if g.E != gmachine.ErrorBadDescriptor {
+		t.Errorf("want E value %d, got %d", gmachine.ErrorBadDescriptor, g.E)
+	}
+}
+
+func TestBIOSStdin(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.Stdin = strings.NewReader("G")
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	g.RunProgram([]gmachine.Word{
+		gmachine.BIOS, gmachine.IORead, gmachine.PortStdin,
+		gmachine.BIOS, gmachine.IOWrite, gmachine.PortStdout,
+		gmachine.BIOS, gmachine.IORead, gmachine.PortStdin,
+		gmachine.HALT,
+	})
+	if buf.String() != "G" {
+		t.Errorf("want %q, got %q", "G", buf.String())
+	}
+	if g.E != gmachine.ErrorEOF {
+		t.Errorf("want E value %d, got %d", gmachine.ErrorEOF, g.E)
+	}
+}
@@ -0,0 +1,133 @@
+package gmachine_test
+
+import (
+	"gmachine"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc, src, want string
+	}{
+		{
+			desc: "Mnemonics are upper-cased and operands aligned",
+			src:  "seta 5\n  bios iowrite stdout\n",
+			want: "        SETA    5\n        BIOS    iowrite, stdout\n",
+		},
+		{
+			desc: "Labels start in column zero",
+			src:  "  loop:\n deca\n",
+			want: "loop:\n        DECA\n",
+		},
+		{
+			desc: "Short label shares the line of its instruction",
+			src:  "loop: deca\n",
+			want: "loop:   DECA\n",
+		},
+		{
+			desc: "Long label has a line of its own",
+			src:  "print_char: inci\n",
+			want: "print_char:\n        INCI\n",
+		},
+		{
+			desc: "One instruction per line",
+			src:  "inca deca seta 1 halt\n",
+			want: "        INCA\n        DECA\n        SETA    1\n        HALT\n",
+		},
+		{
+			desc: "Operands on following lines are joined",
+			src:  "SETA\n5\nBIOS 0\n1\n",
+			want: "        SETA    5\n        BIOS    0, 1\n",
+		},
+		{
+			desc: "Trailing comments are aligned within a block",
+			src:  "SETA 5 ; five\nBIOS IOWRITE, STDOUT # print\n\nINCA;one\n",
+			want: "        SETA    5               ; five\n        BIOS    IOWRITE, STDOUT # print\n\n        INCA ;one\n",
+		},
+		{
+			desc: "Comment lines keep their indentation",
+			src:  "# top\n   ; inner\nHALT\n",
+			want: "# top\n        ; inner\n        HALT\n",
+		},
+		{
+			desc: "Blank lines are collapsed and trimmed",
+			src:  "\n\nINCA\n\n\n\nDECA\n\n",
+			want: "        INCA\n\n        DECA\n",
+		},
+		{
+			desc: "Directives, data and strings",
+			src:  ".word 1 2 3\n.string \"a, b ; c\"\n.global a b\n72 101\n.equ X (1 + 2) * 3\n",
+			want: "        .word   1, 2, 3\n        .string \"a, b ; c\"\n        .global a, b\n        72 101\n        .equ    X (1 + 2) * 3\n",
+		},
+		{
+			desc: "Index operand is upper-cased",
+			src:  "seta [i]\n",
+			want: "        SETA    [I]\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := gmachine.Format([]byte(tC.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(tC.want, string(got)) {
+				t.Error(cmp.Diff(tC.want, string(got)))
+			}
+		})
+	}
+}
+
+func TestFormatInvalid(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.Format([]byte("INCA\nSETA 'a"))
+	want := "line 2: unterminated ' quote"
+	if err == nil || want != err.Error() {
+		t.Errorf("want %q, got %v", want, err)
+	}
+}
+
+// TestFormatPreservesProgram checks that formatting is idempotent and
+// doesn't change what the source assembles to.
+func TestFormatPreservesProgram(t *testing.T) {
+	t.Parallel()
+	paths := []string{
+		"testdata/biosstdout.gasm",
+		"testdata/lowercase.gasm",
+		"testdata/setadeca.gasm",
+		"testdata/lexer/syntax.gasm",
+		"testdata/listing/count.gasm",
+	}
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := gmachine.Format(src)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		again, err := gmachine.Format(formatted)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !cmp.Equal(string(formatted), string(again)) {
+			t.Errorf("%s: formatting is not idempotent:\n%s", path, cmp.Diff(string(formatted), string(again)))
+		}
+		want, err := gmachine.AssembleFromText(string(src))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		got, err := gmachine.AssembleFromText(string(formatted))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if !cmp.Equal(want, got) {
+			t.Errorf("%s: %s", path, cmp.Diff(want, got))
+		}
+	}
+}
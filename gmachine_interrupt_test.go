@@ -0,0 +1,130 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"gmachine"
+	"testing"
+)
+
+// interruptProgram enables interrupts with the vector table at address 30,
+// increments A and halts. The handler for line 1, at address 10, writes '1'
+// to stdout, and the handler for line 3, at address 20, writes '3'.
+func interruptProgram(mask gmachine.Word) []gmachine.Word {
+	program := make([]gmachine.Word, 40)
+	copy(program, []gmachine.Word{
+		gmachine.SETV, 30,
+		gmachine.SETM, mask,
+		gmachine.EINT,
+		gmachine.INCA,
+		gmachine.HALT,
+	})
+	copy(program[10:], []gmachine.Word{
+		gmachine.SETA, '1',
+		gmachine.BIOS, gmachine.IOWrite, gmachine.PortStdout,
+		gmachine.SETA, 0,
+		gmachine.IRET,
+	})
+	copy(program[20:], []gmachine.Word{
+		gmachine.SETA, '3',
+		gmachine.BIOS, gmachine.IOWrite, gmachine.PortStdout,
+		gmachine.SETA, 0,
+		gmachine.IRET,
+	})
+	program[31] = 10
+	program[33] = 20
+	return program
+}
+
+func TestInterruptHandler(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	err := g.Interrupt(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.FlagZ = true
+	g.RunProgram(interruptProgram(0))
+	want := "1"
+	got := buf.String()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	var wantA gmachine.Word = 1
+	if wantA != g.A {
+		t.Errorf("want A value %d, got %d", wantA, g.A)
+	}
+	var wantP gmachine.Word = 7
+	if wantP != g.P {
+		t.Errorf("want P value %d, got %d", wantP, g.P)
+	}
+	if !g.FlagZ {
+		t.Error("want FlagZ restored by IRET")
+	}
+	if !g.FlagI {
+		t.Error("want interrupts re-enabled by IRET")
+	}
+	if g.Pending(1) {
+		t.Error("want interrupt 1 no longer pending")
+	}
+}
+
+func TestInterruptPriority(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	g.Interrupt(3)
+	g.Interrupt(1)
+	g.RunProgram(interruptProgram(0))
+	want := "13"
+	got := buf.String()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestInterruptMasked(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	g.Interrupt(1)
+	g.Interrupt(3)
+	g.RunProgram(interruptProgram(1 << 1))
+	want := "3"
+	got := buf.String()
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if !g.Pending(1) {
+		t.Error("want masked interrupt 1 still pending")
+	}
+}
+
+func TestInterruptDisabled(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	g.Interrupt(1)
+	program := interruptProgram(0)
+	program[4] = gmachine.DINT
+	g.RunProgram(program)
+	if buf.Len() != 0 {
+		t.Errorf("want no output, got %q", buf.String())
+	}
+	if !g.Pending(1) {
+		t.Error("want interrupt 1 still pending")
+	}
+}
+
+func TestInterruptInvalidLine(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.Interrupt(gmachine.NumInterrupts)
+	if err == nil {
+		t.Error("want error for invalid interrupt line")
+	}
+}
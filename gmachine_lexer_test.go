@@ -0,0 +1,124 @@
+package gmachine_test
+
+import (
+	"gmachine"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAssembleSyntax(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc string
+		code string
+		want []gmachine.Word
+	}{
+		{
+			desc: "Trailing semicolon comment",
+			code: "SETA 5 ; set A",
+			want: []gmachine.Word{gmachine.SETA, 5},
+		},
+		{
+			desc: "Trailing hash comment",
+			code: "SETA 5 # set A",
+			want: []gmachine.Word{gmachine.SETA, 5},
+		},
+		{
+			desc: "Comment without spaces",
+			code: "INCA;DECA",
+			want: []gmachine.Word{gmachine.INCA},
+		},
+		{
+			desc: "Commas between operands",
+			code: "BIOS IOWRITE, STDOUT",
+			want: []gmachine.Word{gmachine.BIOS, gmachine.IOWrite, gmachine.PortStdout},
+		},
+		{
+			desc: "Commas without spaces",
+			code: ".word 1,2,3",
+			want: []gmachine.Word{1, 2, 3},
+		},
+		{
+			desc: "String containing spaces and comment characters",
+			code: `.string "a b;#,"`,
+			want: []gmachine.Word{'a', ' ', 'b', ';', '#', ','},
+		},
+		{
+			desc: "Escaped quote in string",
+			code: `.string "say \"hi\""`,
+			want: []gmachine.Word{'s', 'a', 'y', ' ', '"', 'h', 'i', '"'},
+		},
+		{
+			desc: "Space character",
+			code: "SETA ' '",
+			want: []gmachine.Word{gmachine.SETA, ' '},
+		},
+		{
+			desc: "Characters containing a space",
+			code: "'a b'",
+			want: []gmachine.Word{'a', ' ', 'b'},
+		},
+		{
+			desc: "Parenthesized expression containing spaces",
+			code: "SETA ((2 + 3) * 4)",
+			want: []gmachine.Word{gmachine.SETA, 20},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := gmachine.AssembleFromText(tC.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(tC.want, got) {
+				t.Error(cmp.Diff(tC.want, got))
+			}
+		})
+	}
+}
+
+func TestAssembleUnterminatedQuote(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.AssembleFromText("INCA\n.string \"abc")
+	want := `line 2: unterminated " quote`
+	if err == nil || want != err.Error() {
+		t.Errorf("want %q, got %v", want, err)
+	}
+}
+
+// TestAssembleFrontEndsAgree checks that files, text and slices of lines
+// are all lexed the same way.
+func TestAssembleFrontEndsAgree(t *testing.T) {
+	t.Parallel()
+	path := "testdata/lexer/syntax.gasm"
+	fromFile, err := gmachine.AssembleFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromText, err := gmachine.AssembleFromText(string(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(fromFile, fromText) {
+		t.Error(cmp.Diff(fromFile, fromText))
+	}
+	fromSlice, err := gmachine.Assemble(strings.Split(string(source), "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(fromFile, fromSlice) {
+		t.Error(cmp.Diff(fromFile, fromSlice))
+	}
+	want := "Hello, world ;"
+	got := runForOutput(t, fromFile)
+	if want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,176 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"gmachine"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func assembleObject(t *testing.T, paths ...string) *gmachine.Object {
+	t.Helper()
+	obj, err := gmachine.AssembleObject(paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestAssembleObject(t *testing.T) {
+	t.Parallel()
+	obj := assembleObject(t, "testdata/link/main.gasm")
+	wantCode := []gmachine.Word{
+		gmachine.SETI, 5,
+		gmachine.CALL, 0,
+		gmachine.HALT,
+		'H', 'i', 0,
+	}
+	if !cmp.Equal(wantCode, obj.Code) {
+		t.Error(cmp.Diff(wantCode, obj.Code))
+	}
+	wantRelocations := []gmachine.Relocation{
+		{Offset: 1},
+		{Offset: 3, Symbol: "print"},
+	}
+	if !cmp.Equal(wantRelocations, obj.Relocations) {
+		t.Error(cmp.Diff(wantRelocations, obj.Relocations))
+	}
+	wantImports := []string{"print"}
+	if !cmp.Equal(wantImports, obj.Imports) {
+		t.Error(cmp.Diff(wantImports, obj.Imports))
+	}
+}
+
+func TestLink(t *testing.T) {
+	t.Parallel()
+	words, err := gmachine.Link(
+		assembleObject(t, "testdata/link/main.gasm"),
+		assembleObject(t, "testdata/link/print.gasm"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := gmachine.AssembleFiles("testdata/link/main.gasm", "testdata/link/print.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, words) {
+		t.Error(cmp.Diff(want, words))
+	}
+	g := gmachine.New()
+	buf := &bytes.Buffer{}
+	g.Stdout = buf
+	g.RunProgram(words)
+	if buf.String() != "Hi" {
+		t.Errorf("want %q, got %q", "Hi", buf.String())
+	}
+}
+
+func TestObjectRoundTrip(t *testing.T) {
+	t.Parallel()
+	want := assembleObject(t, "testdata/link/print.gasm")
+	path := filepath.Join(t.TempDir(), "print.gobj")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gmachine.WriteObject(file, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	file, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	got, err := gmachine.ReadObject(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(want, got, cmpopts.EquateEmpty()) {
+		t.Error(cmp.Diff(want, got, cmpopts.EquateEmpty()))
+	}
+}
+
+func TestReadObjectInvalid(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.ReadObject(strings.NewReader("GBIN\x00\x00\x00\x01"))
+	if err == nil {
+		t.Error("want error for bad magic number")
+	}
+	_, err = gmachine.ReadObject(strings.NewReader("GOBJ\x00\x00\x00\x01\x00"))
+	if err == nil {
+		t.Error("want error for truncated object")
+	}
+}
+
+// allocated returns the bytes allocated while running f. Tests which
+// use it mustn't run in parallel, so that nothing else allocates.
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestReadObjectHugeCounts(t *testing.T) {
+	// An empty name, code and symbol table, then a claim of 1<<24 imports.
+	input := "GOBJ\x00\x00\x00\x01" + strings.Repeat("\x00", 24) + "\x00\x00\x00\x00\x01\x00\x00\x00"
+	var err error
+	n := allocated(func() {
+		_, err = gmachine.ReadObject(strings.NewReader(input))
+	})
+	if err == nil {
+		t.Error("want error for truncated object")
+	}
+	if n > 1<<20 {
+		t.Errorf("want less than 1MiB allocated, got %d bytes", n)
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	t.Parallel()
+	print := assembleObject(t, "testdata/link/print.gasm")
+	_, err := gmachine.Link(assembleObject(t, "testdata/link/unresolved.gasm"), print, print)
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+	for _, want := range []string{
+		`unresolved symbol "missing" referenced by testdata/link/unresolved.gasm`,
+		`duplicate symbol "print" defined in testdata/link/print.gasm and testdata/link/print.gasm`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want error containing %q, got %q", want, err)
+		}
+	}
+}
+
+func TestLinkUnusedImport(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.Link(assembleObject(t, "testdata/link/unused.gasm"))
+	want := `unresolved symbol "nowhere" referenced by testdata/link/unused.gasm`
+	if err == nil || err.Error() != want {
+		t.Errorf("want error %q, got %v", want, err)
+	}
+}
+
+func TestAssembleObjectNotRelocatable(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "scaled.gasm")
+	err := os.WriteFile(path, []byte("start:\nSETA start*2\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gmachine.AssembleObject(path)
+	if err == nil || !strings.Contains(err.Error(), "not relocatable") {
+		t.Errorf("want not relocatable error, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package gmachine_test
+
+import (
+	"bytes"
+	"gmachine"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteListing(t *testing.T) {
+	t.Parallel()
+	program, err := gmachine.AssembleProgram("testdata/listing/count.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	err = program.WriteListing(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"0000  4 2                       testdata/listing/count.gasm:7: SETA START",
+		"0002  3                         testdata/listing/count.gasm:4: DECA (expanded from testdata/listing/count.gasm:9)",
+		"0003  6 0                       testdata/listing/count.gasm:10: CMPA 0",
+		"0005  7 2                       testdata/listing/count.gasm:11: JEQ loop",
+		"0007  8 9                       testdata/listing/count.gasm:12: JUMP bad",
+		"0009  99                        testdata/listing/count.gasm:14: .word 99",
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestSymbolMap(t *testing.T) {
+	t.Parallel()
+	program, err := gmachine.AssembleProgram("testdata/listing/count.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	_, err = program.Symbols.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "label 0 start\nconst 2 START\nlabel 2 loop\nlabel 9 bad\n"
+	if want != buf.String() {
+		t.Errorf("want %q, got %q", want, buf.String())
+	}
+	symbols, err := gmachine.ReadSymbolMap(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(program.Symbols, symbols) {
+		t.Error(cmp.Diff(program.Symbols, symbols))
+	}
+}
+
+func TestSymbolMapLookup(t *testing.T) {
+	t.Parallel()
+	symbols, err := gmachine.ReadSymbolMap(strings.NewReader("label 5 loop\nconst 8 SIZE\nlabel 10 end\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		addr gmachine.Word
+		want string
+	}{
+		{addr: 4, want: ""},
+		{addr: 5, want: "loop"},
+		{addr: 8, want: "loop+3"},
+		{addr: 12, want: "end+2"},
+	}
+	for _, tC := range testCases {
+		got := symbols.Lookup(tC.addr)
+		if tC.want != got {
+			t.Errorf("at %d: want %q, got %q", tC.addr, tC.want, got)
+		}
+	}
+}
+
+func TestReadSymbolMapInvalid(t *testing.T) {
+	t.Parallel()
+	_, err := gmachine.ReadSymbolMap(strings.NewReader("label loop\n"))
+	if err == nil {
+		t.Error("want error for malformed symbol map")
+	}
+}
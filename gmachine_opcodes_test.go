@@ -2,8 +2,13 @@ package gmachine_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"gmachine"
+	"math"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestNew(t *testing.T) {
@@ -216,3 +221,342 @@ func TestCMPASetZ(t *testing.T) {
 		t.Errorf("want initial P value %d, got %d", wantP, g.P)
 	}
 }
+
+func TestSTORE(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.SETA, 7, gmachine.SETI, 10, gmachine.STORE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want gmachine.Word = 7
+	if want != g.Memory[10] {
+		t.Errorf("want memory at 10 to contain %d, got %d", want, g.Memory[10])
+	}
+}
+
+func TestRegisterTransfers(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	words, err := gmachine.AssembleFromText("SETA 7\nSETIA\nSETNA\nSETA 3\nSETAN\nINCI\nADDI 5\nDECI\nSETAI\nHALT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.RunProgram(words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.I != 12 || g.N != 7 || g.A != 12 {
+		t.Errorf("want A=12 I=12 N=7, got A=%d I=%d N=%d", g.A, g.I, g.N)
+	}
+}
+
+func TestMemoryAddressing(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	source := `SETI data+1
+	SETA 5
+	POKE -1
+	PEEK 1
+	SAVE result
+	XCHG
+	LOAD data
+	HALT
+data: .word 0, 7, 9
+result: .word 0`
+	words, err := gmachine.AssembleFromText(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.RunProgram(words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []gmachine.Word{5, 9, 9, 9}
+	got := g.Memory[14:18]
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if g.A != 5 {
+		t.Errorf("want A=5, got %d", g.A)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc  string
+		a, m  int64
+		op    string
+		want  int64
+		wantZ bool
+	}{
+		{desc: "ADDM", a: 5, m: 3, op: "ADDM", want: 8},
+		{desc: "SUBM", a: 5, m: 7, op: "SUBM", want: -2},
+		{desc: "MULM", a: -4, m: 3, op: "MULM", want: -12},
+		{desc: "DIVM", a: -7, m: 2, op: "DIVM", want: -3},
+		{desc: "MODM", a: -7, m: 2, op: "MODM", want: -1},
+		{desc: "CMPM equal", a: 4, m: 4, op: "CMPM", want: 4, wantZ: true},
+		{desc: "CMPM different", a: 4, m: 5, op: "CMPM", want: 4},
+		{desc: "LESS negative", a: -1, m: 0, op: "LESS", want: -1, wantZ: true},
+		{desc: "LESS equal", a: 2, m: 2, op: "LESS", want: 2},
+		{desc: "LESS is signed", a: 1, m: -1, op: "LESS", want: 1},
+		{desc: "ADDM wraps", a: -1, m: 1, op: "ADDM", want: 0},
+		{desc: "MODM positive", a: 7, m: -2, op: "MODM", want: 1},
+		{desc: "ANDM", a: 12, m: 10, op: "ANDM", want: 8},
+		{desc: "ORM", a: 12, m: 10, op: "ORM", want: 14},
+		{desc: "XORM", a: 12, m: -1, op: "XORM", want: -13},
+	}
+	for _, tC := range testCases {
+		g := gmachine.New()
+		source := fmt.Sprintf("SETA %d\nSETI operand\n%s\nHALT\noperand: .word %d", tC.a, tC.op, tC.m)
+		words, err := gmachine.AssembleFromText(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = g.RunProgram(words)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gmachine.Word(tC.want) != g.A || tC.wantZ != g.FlagZ {
+			t.Errorf("%s: want A=%d Z=%t, got A=%d Z=%t", tC.desc, tC.want, tC.wantZ, int64(g.A), g.FlagZ)
+		}
+	}
+}
+
+func TestADDA(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	words, err := gmachine.AssembleFromText("SETA 10\nADDA 5\nADDA -20\nHALT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.RunProgram(words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(g.A) != -5 {
+		t.Errorf("want A=-5, got %d", int64(g.A))
+	}
+}
+
+func TestPEEK(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.Memory[12] = 7
+	err := g.RunProgram([]gmachine.Word{gmachine.SETI, 10, gmachine.PEEK, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.A != 7 || g.I != 10 {
+		t.Errorf("want A=7 I=10, got A=%d I=%d", g.A, g.I)
+	}
+}
+
+func TestPOKE(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.SETA, 7, gmachine.SETI, 12, gmachine.POKE, math.MaxUint64 - 1}) // -2
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Memory[10] != 7 || g.I != 12 {
+		t.Errorf("want memory at 10 to contain 7 and I=12, got %d and I=%d", g.Memory[10], g.I)
+	}
+}
+
+func TestLOAD(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.Memory[10] = 7
+	err := g.RunProgram([]gmachine.Word{gmachine.LOAD, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantA gmachine.Word = 7
+	if wantA != g.A {
+		t.Errorf("want A value %d, got %d", wantA, g.A)
+	}
+	var wantP gmachine.Word = 3
+	if wantP != g.P {
+		t.Errorf("want P value %d, got %d", wantP, g.P)
+	}
+}
+
+func TestSAVE(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.SETA, 7, gmachine.SAVE, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want gmachine.Word = 7
+	if want != g.Memory[10] {
+		t.Errorf("want memory at 10 to contain %d, got %d", want, g.Memory[10])
+	}
+}
+
+func TestXCHG(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.Memory[10] = 3
+	err := g.RunProgram([]gmachine.Word{gmachine.SETA, 7, gmachine.SETI, 10, gmachine.XCHG})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.A != 3 || g.Memory[10] != 7 {
+		t.Errorf("want A=3 and memory at 10 to contain 7, got A=%d and %d", g.A, g.Memory[10])
+	}
+}
+
+func TestSETAI(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.SETI, 7, gmachine.SETAI})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.A != 7 {
+		t.Errorf("want A=7, got %d", g.A)
+	}
+}
+
+func TestSETIA(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.SETA, 7, gmachine.SETIA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.I != 7 {
+		t.Errorf("want I=7, got %d", g.I)
+	}
+}
+
+func TestSETAN(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.N = 7
+	err := g.RunProgram([]gmachine.Word{gmachine.SETAN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.A != 7 {
+		t.Errorf("want A=7, got %d", g.A)
+	}
+}
+
+func TestSETNA(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.SETA, 7, gmachine.SETNA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.N != 7 {
+		t.Errorf("want N=7, got %d", g.N)
+	}
+}
+
+func TestADDI(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.SETI, 10, gmachine.ADDI, 5, gmachine.ADDI, math.MaxUint64 - 19}) // -20
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(g.I) != -5 {
+		t.Errorf("want I=-5, got %d", int64(g.I))
+	}
+}
+
+func TestDECI(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	err := g.RunProgram([]gmachine.Word{gmachine.SETI, 2, gmachine.DECI})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantI gmachine.Word = 1
+	if wantI != g.I {
+		t.Errorf("want I value %d, got %d", wantI, g.I)
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	t.Parallel()
+	for _, op := range []gmachine.Word{gmachine.DIVM, gmachine.MODM} {
+		g := gmachine.New()
+		err := g.RunProgram([]gmachine.Word{gmachine.SETA, 7, gmachine.SETI, 10, op})
+		var fault *gmachine.Fault
+		if !errors.As(err, &fault) || fault.Reason != "division by zero" || fault.P != 4 {
+			t.Errorf("opcode %d: want division by zero fault at 4, got %v", op, err)
+		}
+	}
+}
+
+func TestMemoryInstructionsOutOfRange(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc    string
+		program []gmachine.Word
+	}{
+		{desc: "STORE", program: []gmachine.Word{gmachine.SETI, gmachine.DefaultMemSize, gmachine.STORE}},
+		{desc: "PEEK", program: []gmachine.Word{gmachine.SETI, gmachine.DefaultMemSize - 1, gmachine.PEEK, 1}},
+		{desc: "POKE", program: []gmachine.Word{gmachine.SETI, gmachine.DefaultMemSize - 1, gmachine.POKE, 1}},
+		{desc: "LOAD", program: []gmachine.Word{gmachine.LOAD, gmachine.DefaultMemSize}},
+		{desc: "SAVE", program: []gmachine.Word{gmachine.SAVE, gmachine.DefaultMemSize}},
+		{desc: "XCHG", program: []gmachine.Word{gmachine.SETI, gmachine.DefaultMemSize, gmachine.XCHG}},
+		{desc: "ADDM", program: []gmachine.Word{gmachine.SETI, gmachine.DefaultMemSize, gmachine.ADDM}},
+	}
+	for _, tC := range testCases {
+		g := gmachine.New()
+		err := g.RunProgram(tC.program)
+		var fault *gmachine.Fault
+		if !errors.As(err, &fault) {
+			t.Errorf("%s: want fault, got %v", tC.desc, err)
+		}
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc string
+		op   gmachine.Word
+		a, m gmachine.Word
+		want gmachine.Word
+	}{
+		{desc: "ANDM", op: gmachine.ANDM, a: 0b1100, m: 0b1010, want: 0b1000},
+		{desc: "ANDM with all ones", op: gmachine.ANDM, a: 0b1100, m: math.MaxUint64, want: 0b1100},
+		{desc: "ANDM with zero", op: gmachine.ANDM, a: 0b1100, m: 0, want: 0},
+		{desc: "ORM", op: gmachine.ORM, a: 0b1100, m: 0b1010, want: 0b1110},
+		{desc: "ORM top bit", op: gmachine.ORM, a: 1, m: 1 << 63, want: 1<<63 | 1},
+		{desc: "XORM", op: gmachine.XORM, a: 0b1100, m: 0b1010, want: 0b0110},
+		{desc: "XORM with all ones", op: gmachine.XORM, a: 0b1100, m: math.MaxUint64, want: math.MaxUint64 ^ 0b1100},
+		{desc: "XORM with itself", op: gmachine.XORM, a: 0b1100, m: 0b1100, want: 0},
+	}
+	for _, tC := range testCases {
+		g := gmachine.New()
+		g.Memory[10] = tC.m
+		err := g.RunProgram([]gmachine.Word{gmachine.SETA, tC.a, gmachine.SETI, 10, tC.op})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tC.want != g.A || g.Memory[10] != tC.m || g.FlagZ {
+			t.Errorf("%s: want A=%#x with memory and Z unchanged, got A=%#x memory %#x Z=%t", tC.desc, tC.want, g.A, g.Memory[10], g.FlagZ)
+		}
+	}
+}
+
+func TestBitwiseOutOfRange(t *testing.T) {
+	t.Parallel()
+	for _, op := range []gmachine.Word{gmachine.ANDM, gmachine.ORM, gmachine.XORM} {
+		g := gmachine.New()
+		err := g.RunProgram([]gmachine.Word{gmachine.SETI, gmachine.DefaultMemSize, op})
+		var fault *gmachine.Fault
+		if !errors.As(err, &fault) || fault.P != 2 {
+			t.Errorf("opcode %d: want fault at 2, got %v", op, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package gmachine_test
+
+import (
+	"gmachine"
+	"testing"
+)
+
+// timerProgram starts the timer with the given period and spins
+// incrementing A. The timer handler counts interrupts in I, and halts the
+// machine on the third one.
+func timerProgram(period gmachine.Word) []gmachine.Word {
+	program := make([]gmachine.Word, 41)
+	copy(program, []gmachine.Word{
+		gmachine.SETV, 40,
+		gmachine.SETA, period,
+		gmachine.BIOS, gmachine.TimerSet, gmachine.PortTimer,
+		gmachine.SETA, 0,
+		gmachine.EINT,
+		gmachine.INCA,
+		gmachine.JUMP, 10,
+	})
+	copy(program[20:], []gmachine.Word{
+		gmachine.INCI,
+		gmachine.CMPI, 3,
+		gmachine.JEQ, 26,
+		gmachine.HALT,
+		gmachine.IRET,
+	})
+	program[40+gmachine.IRQTimer] = 20
+	return program
+}
+
+func TestTimerInterrupt(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.RunProgram(timerProgram(10))
+	var wantI gmachine.Word = 3
+	if wantI != g.I {
+		t.Errorf("want I value %d, got %d", wantI, g.I)
+	}
+	var wantCycles gmachine.Word = 37
+	if wantCycles != g.Cycles {
+		t.Errorf("want Cycles value %d, got %d", wantCycles, g.Cycles)
+	}
+	var wantA gmachine.Word = 10
+	if wantA != g.A {
+		t.Errorf("want A value %d, got %d", wantA, g.A)
+	}
+}
+
+func TestTimerDisabled(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	program := timerProgram(0)
+	program[11] = 12
+	program[12] = gmachine.HALT
+	g.RunProgram(program)
+	if g.Pending(gmachine.IRQTimer) {
+		t.Error("want no timer interrupt pending")
+	}
+	var wantI gmachine.Word = 0
+	if wantI != g.I {
+		t.Errorf("want I value %d, got %d", wantI, g.I)
+	}
+}
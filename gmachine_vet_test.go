@@ -0,0 +1,99 @@
+package gmachine_test
+
+import (
+	"gmachine"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVet(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc, src string
+		want      []string
+	}{
+		{
+			desc: "Clean program",
+			src: `
+				SETI message
+			loop:
+				SETA [I]
+				CMPA 0
+				JEQ print
+				HALT
+			print:
+				BIOS IOWRITE, STDOUT
+				INCI
+				JUMP loop
+			message:
+				.stringz "Hi"
+			`,
+			want: []string{},
+		},
+		{
+			desc: "Missing HALT",
+			src:  "SETA 5\nDECA\n",
+			want: []string{"line 2: execution falls through past the end of the program; missing HALT?"},
+		},
+		{
+			desc: "Falling through into data",
+			src:  "INCA\n.word 99\n",
+			want: []string{"line 1: execution falls through into data at 1"},
+		},
+		{
+			desc: "Jump outside the program",
+			src:  "JUMP 100\n",
+			want: []string{"line 1: jump target 100 is outside the program"},
+		},
+		{
+			desc: "Jump into operands",
+			src:  "start: SETA 5\nJUMP start+1\n",
+			want: []string{"line 2: jump target 1 (start+1) is in the middle of the instruction at 0 (start)"},
+		},
+		{
+			desc: "Unreachable block",
+			src:  "JUMP end\nINCA\nDECA\nend: HALT\n",
+			want: []string{"line 2: unreachable code"},
+		},
+		{
+			desc: "Call and return",
+			src:  "CALL sub\nHALT\nsub: INCA\nRETN\n",
+			want: []string{},
+		},
+		{
+			desc: "Interrupt handler reached through the vector table",
+			src:  "SETV vectors\nEINT\nHALT\nhandler: IRET\nvectors: .word handler\n",
+			want: []string{},
+		},
+		{
+			desc: "Unknown BIOS port",
+			src:  "BIOS IOWRITE, 42\nHALT\n",
+			want: []string{"line 1: BIOS call to unknown port 42"},
+		},
+		{
+			desc: "Unsupported BIOS operation",
+			src:  "BIOS IOREAD, STDOUT\nBIOS 99, CLOCK\nBIOS DISKREAD, DISK\nHALT\n",
+			want: []string{
+				"line 1: BIOS operation 1 is not supported by port STDOUT",
+				"line 2: BIOS operation 99 is not supported by port CLOCK",
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			program, err := gmachine.AssembleProgramFromReader("", strings.NewReader(tC.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, d := range gmachine.Vet(program) {
+				got = append(got, d.String())
+			}
+			if !cmp.Equal(tC.want, got) {
+				t.Error(cmp.Diff(tC.want, got))
+			}
+		})
+	}
+}
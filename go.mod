@@ -1,5 +1,5 @@
 module gmachine
 
-go 1.14
+go 1.16
 
 require github.com/google/go-cmp v0.5.6
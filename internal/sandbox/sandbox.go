@@ -0,0 +1,93 @@
+// Package sandbox holds what the playground and runner services share for
+// running untrusted programs: an in-memory file system for their sources,
+// a buffer which caps their output, and a snapshot of the registers they
+// finish with.
+package sandbox
+
+import (
+	"gmachine"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// Files is a read-only file system of source files, keyed by name, so
+// that a program can include only the other files it was given.
+type Files map[string]string
+
+// Open opens the named file.
+func (f Files) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	text, ok := f[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &file{Reader: strings.NewReader(text), name: path.Base(name)}, nil
+}
+
+// file is an open file of Files.
+type file struct {
+	*strings.Reader
+	name string
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return fileInfo{name: f.name, size: f.Size()}, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+type fileInfo struct {
+	name string
+	size int64
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+// LimitedBuffer keeps the first Max bytes written to it, discarding the
+// rest.
+type LimitedBuffer struct {
+	strings.Builder
+	Max int
+}
+
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	if room := b.Max - b.Len(); room < len(p) {
+		if room > 0 {
+			b.Builder.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Builder.Write(p)
+}
+
+// Registers holds the machine's registers and flags.
+type Registers struct {
+	A     gmachine.Word `json:"A"`
+	I     gmachine.Word `json:"I"`
+	N     gmachine.Word `json:"N"`
+	P     gmachine.Word `json:"P"`
+	E     gmachine.Word `json:"E"`
+	V     gmachine.Word `json:"V"`
+	M     gmachine.Word `json:"M"`
+	FlagZ bool          `json:"Z"`
+	FlagI bool          `json:"interrupts"`
+}
+
+// RegistersOf returns the registers and flags of g.
+func RegistersOf(g *gmachine.GMachine) Registers {
+	return Registers{
+		A: g.A, I: g.I, N: g.N, P: g.P, E: g.E, V: g.V, M: g.M,
+		FlagZ: g.FlagZ, FlagI: g.FlagI,
+	}
+}
@@ -0,0 +1,87 @@
+package sandbox_test
+
+import (
+	"errors"
+	"fmt"
+	"gmachine"
+	"gmachine/internal/sandbox"
+	"io/fs"
+	"path"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	t.Parallel()
+	files := sandbox.Files{"main.gasm": "HALT", "lib/print.gasm": "NOOP"}
+	for name, want := range files {
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want != string(data) {
+			t.Errorf("%s: want %q, got %q", name, want, data)
+		}
+		info, err := fs.Stat(files, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != path.Base(name) || info.Size() != int64(len(want)) || info.IsDir() {
+			t.Errorf("%s: want file of %d bytes, got %s of %d bytes", name, len(want), info.Name(), info.Size())
+		}
+	}
+}
+
+func TestFilesOpenErrors(t *testing.T) {
+	t.Parallel()
+	files := sandbox.Files{"main.gasm": "HALT"}
+	for name, want := range map[string]error{
+		"missing.gasm":  fs.ErrNotExist,
+		"../main.gasm":  fs.ErrInvalid,
+		"/etc/passwd":   fs.ErrInvalid,
+		"lib/../x.gasm": fs.ErrInvalid,
+	} {
+		_, err := files.Open(name)
+		if !errors.Is(err, want) {
+			t.Errorf("%s: want %v, got %v", name, want, err)
+		}
+	}
+}
+
+func TestAssembleFiles(t *testing.T) {
+	t.Parallel()
+	files := sandbox.Files{
+		"main.gasm":     ".include \"lib/halt.gasm\"",
+		"lib/halt.gasm": "INCA\nHALT",
+	}
+	words, err := gmachine.AssembleFS(files, "main.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := fmt.Sprint([]gmachine.Word{gmachine.INCA, gmachine.HALT}), fmt.Sprint(words); want != got {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	t.Parallel()
+	b := &sandbox.LimitedBuffer{Max: 5}
+	for _, s := range []string{"abc", "defg", "h"} {
+		n, err := b.Write([]byte(s))
+		if err != nil || n != len(s) {
+			t.Errorf("Write(%q) = %d, %v; want %d, nil", s, n, err, len(s))
+		}
+	}
+	if want, got := "abcde", b.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestRegistersOf(t *testing.T) {
+	t.Parallel()
+	g := gmachine.New()
+	g.RunProgram([]gmachine.Word{gmachine.SETA, 7, gmachine.SETI, 3, gmachine.HALT})
+	want := sandbox.Registers{A: 7, I: 3, P: 5}
+	if got := sandbox.RegistersOf(g); want != got {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
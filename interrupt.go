@@ -0,0 +1,75 @@
+package gmachine
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// NumInterrupts is the number of interrupt lines. The vector table holds
+// one handler address per line, and lower-numbered lines have priority
+// over higher-numbered ones.
+const NumInterrupts = 16
+
+// Interrupt raises the given interrupt line. It is safe to call from a
+// goroutine other than the one running the machine. The interrupt stays
+// pending until the program enables interrupts and the line is unmasked.
+func (g *GMachine) Interrupt(line int) error {
+	if line < 0 || line >= NumInterrupts {
+		return fmt.Errorf("invalid interrupt line %d", line)
+	}
+	for {
+		old := atomic.LoadUint64(&g.pending)
+		if atomic.CompareAndSwapUint64(&g.pending, old, old|1<<uint(line)) {
+			return nil
+		}
+	}
+}
+
+// Pending reports whether the given interrupt line is waiting to be
+// serviced.
+func (g *GMachine) Pending(line int) bool {
+	return atomic.LoadUint64(&g.pending)&(1<<uint(line)) != 0
+}
+
+// serviceInterrupt diverts execution to the handler of the highest priority
+// pending, unmasked interrupt, saving P and FlagZ for IRET. Handlers run
+// with interrupts disabled, so they do not nest. An interrupt whose vector
+// is zero is discarded.
+func (g *GMachine) serviceInterrupt() {
+	if !g.FlagI {
+		return
+	}
+	ready := atomic.LoadUint64(&g.pending) &^ uint64(g.M)
+	if ready == 0 {
+		return
+	}
+	line := 0
+	for ready&(1<<uint(line)) == 0 {
+		line++
+	}
+	for {
+		old := atomic.LoadUint64(&g.pending)
+		if atomic.CompareAndSwapUint64(&g.pending, old, old&^(1<<uint(line))) {
+			break
+		}
+	}
+	if g.V+Word(line) >= Word(len(g.Memory)) {
+		return
+	}
+	handler := g.Memory[g.V+Word(line)]
+	if handler == 0 {
+		return
+	}
+	g.savedP = g.P
+	g.savedZ = g.FlagZ
+	g.FlagI = false
+	g.P = handler
+}
+
+// returnFromInterrupt restores the state saved by serviceInterrupt and
+// re-enables interrupts.
+func (g *GMachine) returnFromInterrupt() {
+	g.P = g.savedP
+	g.FlagZ = g.savedZ
+	g.FlagI = true
+}
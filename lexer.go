@@ -0,0 +1,101 @@
+package gmachine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// lexLine splits one line of source into tokens with splitLine, dropping
+// any comment.
+func lexLine(file string, line int, text string) ([]token, error) {
+	pos := Position{File: file, Line: line}
+	fields, _, err := splitLine(text)
+	if err != nil {
+		return nil, token{pos: pos, line: text}.wrap(err)
+	}
+	tokens := make([]token, len(fields))
+	for i, field := range fields {
+		tokens[i] = token{text: field, pos: pos, line: text}
+	}
+	return tokens, nil
+}
+
+// splitLine splits one line of source into fields and a trailing comment.
+// Fields are separated by whitespace or commas, and a `;` or `#` starts a
+// comment running to the end of the line. Quoted strings and characters,
+// and parenthesized expressions, are single fields even when they contain
+// spaces.
+func splitLine(text string) (fields []string, comment string, err error) {
+	fields = []string{}
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r), r == ',':
+			i++
+			continue
+		case r == ';', r == '#':
+			return fields, strings.TrimRightFunc(string(runes[i:]), unicode.IsSpace), nil
+		}
+		start := i
+		depth := 0
+	scan:
+		for ; i < len(runes); i++ {
+			r := runes[i]
+			switch {
+			case r == '"', r == '\'':
+				end, ok := closingQuote(runes, i)
+				if !ok {
+					return nil, "", fmt.Errorf("unterminated %c quote", r)
+				}
+				i = end
+			case r == '(':
+				depth++
+			case r == ')':
+				if depth > 0 {
+					depth--
+				}
+			case depth > 0:
+			case unicode.IsSpace(r), r == ',', r == ';', r == '#':
+				break scan
+			}
+		}
+		fields = append(fields, string(runes[start:i]))
+	}
+	return fields, "", nil
+}
+
+// closingQuote returns the index of the quote closing the one at start,
+// skipping escaped characters.
+func closingQuote(runes []rune, start int) (int, bool) {
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			i++
+		case runes[start]:
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// tokenize reads source from r and splits each line into tokens with
+// lexLine.
+func tokenize(file string, r io.Reader) ([]token, error) {
+	tokens := []token{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		lineTokens, err := lexLine(file, line, scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, lineTokens...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
@@ -0,0 +1,112 @@
+package gmachine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// listingWordsPerLine is how many words a listing shows on each line.
+const listingWordsPerLine = 4
+
+// Program is an assembled program together with what is needed to relate
+// its code back to the source.
+type Program struct {
+	Words   []Word
+	Listing []ListingLine
+	Symbols *SymbolMap
+	// Instructions holds the address of each instruction, in order. The
+	// other words are operands or data.
+	Instructions []Word
+}
+
+// ListingLine relates the words starting at Addr to the source line they
+// were assembled from. For code expanded from a macro, Pos is the line in
+// the macro body and Expansion lists the call sites.
+type ListingLine struct {
+	Addr      Word
+	Words     []Word
+	Pos       Position
+	Expansion []Position
+	Source    string
+}
+
+// AssembleProgram assembles several source files together like
+// AssembleFiles, also returning the listing and symbol map.
+func AssembleProgram(paths ...string) (*Program, error) {
+	tokens, err := readFiles(hostSources{}, paths)
+	if err != nil {
+		return nil, err
+	}
+	a := newAssembler()
+	err = a.assemble(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return a.program(), nil
+}
+
+func (a *assembler) program() *Program {
+	p := &Program{Words: a.words, Instructions: a.instructions}
+	for addr, origin := range a.origins {
+		if addr > 0 {
+			previous := a.origins[addr-1]
+			if previous.pos == origin.pos && previous.instance == origin.instance {
+				last := &p.Listing[len(p.Listing)-1]
+				last.Words = append(last.Words, a.words[addr])
+				continue
+			}
+		}
+		p.Listing = append(p.Listing, ListingLine{
+			Addr:      Word(addr),
+			Words:     []Word{a.words[addr]},
+			Pos:       origin.pos,
+			Expansion: origin.expansion,
+			Source:    strings.TrimSpace(origin.line),
+		})
+	}
+	symbols := []Symbol{}
+	for name, word := range a.labels {
+		symbols = append(symbols, Symbol{Name: name, Value: word, Label: true})
+	}
+	for name, word := range a.constants {
+		if _, ok := PredefinedConstants[name]; !ok {
+			symbols = append(symbols, Symbol{Name: name, Value: word})
+		}
+	}
+	p.Symbols = newSymbolMap(symbols)
+	return p
+}
+
+// WriteListing writes a listing of the program, showing the address and
+// words assembled from each source line, followed by the line itself.
+func (p *Program) WriteListing(w io.Writer) error {
+	for _, line := range p.Listing {
+		for i := 0; i < len(line.Words); i += listingWordsPerLine {
+			end := i + listingWordsPerLine
+			if end > len(line.Words) {
+				end = len(line.Words)
+			}
+			words := []string{}
+			for _, word := range line.Words[i:end] {
+				words = append(words, fmt.Sprintf("%d", word))
+			}
+			if i > 0 {
+				_, err := fmt.Fprintf(w, "%04d  %s\n", line.Addr+Word(i), strings.Join(words, " "))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			source := fmt.Sprintf("%s: %s", line.Pos, line.Source)
+			for _, call := range line.Expansion {
+				source += fmt.Sprintf(" (expanded from %s)", call)
+			}
+			_, err := fmt.Fprintf(w, "%04d  %-24s  %s\n", line.Addr, strings.Join(words, " "), source)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"gmachine"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// document is an open source file, analysed when its text changes.
+type document struct {
+	uri         string
+	lines       []string
+	definitions []gmachine.Definition
+	// program is the assembled document, or nil if it has errors.
+	program     *gmachine.Program
+	diagnostics []Diagnostic
+}
+
+func newDocument(uri, path, text string) *document {
+	doc := &document{
+		uri:         uri,
+		lines:       strings.Split(text, "\n"),
+		definitions: gmachine.Definitions([]byte(text)),
+		diagnostics: []Diagnostic{},
+	}
+	program, err := gmachine.AssembleProgramFromReader(path, strings.NewReader(text))
+	if errors.Is(err, gmachine.ErrNoCode) {
+		// A new file has nothing in it to complain about yet.
+		return doc
+	}
+	if err != nil {
+		doc.diagnostics = append(doc.diagnostics, doc.errorDiagnostic(path, err))
+		return doc
+	}
+	doc.program = program
+	for _, d := range gmachine.Vet(program) {
+		if d.Pos.File != path {
+			continue
+		}
+		doc.diagnostics = append(doc.diagnostics, Diagnostic{
+			Range:    doc.lineRange(d.Pos.Line - 1),
+			Severity: SeverityWarning,
+			Source:   "gasm vet",
+			Message:  d.Message,
+		})
+	}
+	return doc
+}
+
+// errorDiagnostic reports an assembly error on the line where it occurred,
+// or on the first line if it occurred in an included file.
+func (doc *document) errorDiagnostic(path string, err error) Diagnostic {
+	line := 0
+	message := err.Error()
+	var aerr *gmachine.AssemblyError
+	if errors.As(err, &aerr) && aerr.Pos.File == path {
+		line = aerr.Pos.Line - 1
+		message = aerr.Err.Error()
+		for _, call := range aerr.Expansion {
+			message += fmt.Sprintf(" (expanded from line %d)", call.Line)
+		}
+	}
+	return Diagnostic{
+		Range:    doc.lineRange(line),
+		Severity: SeverityError,
+		Source:   "gasm",
+		Message:  message,
+	}
+}
+
+func (doc *document) line(n int) string {
+	if n < 0 || n >= len(doc.lines) {
+		return ""
+	}
+	return strings.TrimSuffix(doc.lines[n], "\r")
+}
+
+// lineRange returns the range of the text of line n, without leading
+// indentation.
+func (doc *document) lineRange(n int) Range {
+	text := doc.line(n)
+	start := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+	return Range{
+		Start: Position{Line: n, Character: utf16Len(text[:start])},
+		End:   Position{Line: n, Character: utf16Len(text)},
+	}
+}
+
+// word returns the identifier or mnemonic at pos, and its range.
+func (doc *document) word(pos Position) (string, Range) {
+	text := doc.line(pos.Line)
+	offset := byteOffset(text, pos.Character)
+	isWord := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '@'
+	}
+	start := offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if !isWord(r) {
+			break
+		}
+		start -= size
+	}
+	end := offset
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if !isWord(r) {
+			break
+		}
+		end += size
+	}
+	return text[start:end], Range{
+		Start: Position{Line: pos.Line, Character: utf16Len(text[:start])},
+		End:   Position{Line: pos.Line, Character: utf16Len(text[:end])},
+	}
+}
+
+func (doc *document) find(name string) (gmachine.Definition, bool) {
+	for _, def := range doc.definitions {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return gmachine.Definition{}, false
+}
+
+func (doc *document) definitionRange(def gmachine.Definition) Range {
+	text := doc.line(def.Line - 1)
+	return Range{
+		Start: Position{Line: def.Line - 1, Character: utf16Len(text[:def.Col])},
+		End:   Position{Line: def.Line - 1, Character: utf16Len(text[:def.Col+len(def.Name)])},
+	}
+}
+
+func (doc *document) hover(pos Position) *Hover {
+	name, r := doc.word(pos)
+	if name == "" {
+		return nil
+	}
+	text := ""
+	if instruction, ok := gmachine.TranslateTable[strings.ToUpper(name)]; ok {
+		text = instruction.Doc
+	} else if def, ok := doc.find(name); ok {
+		text = doc.describe(def)
+	} else if word, ok := gmachine.PredefinedConstants[name]; ok {
+		text = fmt.Sprintf("%s = %d (predefined)", name, word)
+	}
+	if text == "" {
+		return nil
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "plaintext", Value: text},
+		Range:    r,
+	}
+}
+
+// describe describes a definition for hover text, using its assembled
+// value when the document assembles.
+func (doc *document) describe(def gmachine.Definition) string {
+	var value *gmachine.Word
+	if doc.program != nil {
+		if sym, ok := doc.program.Symbols.Find(def.Name); ok {
+			value = &sym.Value
+		}
+	}
+	switch def.Kind {
+	case gmachine.DefinitionLabel:
+		if value != nil {
+			return fmt.Sprintf("label %s at address %d", def.Name, *value)
+		}
+		return fmt.Sprintf("label %s", def.Name)
+	case gmachine.DefinitionConstant:
+		if value != nil {
+			return fmt.Sprintf("%s = %d (.equ %s)", def.Name, *value, def.Detail)
+		}
+		return fmt.Sprintf("%s = %s", def.Name, def.Detail)
+	}
+	return strings.TrimSpace(fmt.Sprintf("macro %s %s", def.Name, def.Detail))
+}
+
+func (doc *document) completion() []CompletionItem {
+	items := []CompletionItem{}
+	for name, instruction := range gmachine.TranslateTable {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionKeyword, Detail: instruction.Doc})
+	}
+	for name, word := range gmachine.PredefinedConstants {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionConstant, Detail: fmt.Sprintf("%d", word)})
+	}
+	for _, def := range doc.definitions {
+		item := CompletionItem{Label: def.Name, Detail: doc.describe(def)}
+		switch def.Kind {
+		case gmachine.DefinitionLabel:
+			item.Kind = CompletionVariable
+		case gmachine.DefinitionConstant:
+			item.Kind = CompletionConstant
+		default:
+			item.Kind = CompletionFunction
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+func (doc *document) definition(pos Position) *Location {
+	name, _ := doc.word(pos)
+	def, ok := doc.find(name)
+	if !ok {
+		return nil
+	}
+	return &Location{URI: doc.uri, Range: doc.definitionRange(def)}
+}
+
+func (doc *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for _, def := range doc.definitions {
+		kind := SymbolFunction
+		switch def.Kind {
+		case gmachine.DefinitionConstant:
+			kind = SymbolConstant
+		case gmachine.DefinitionMacro:
+			kind = SymbolOperator
+		}
+		symbols = append(symbols, DocumentSymbol{
+			Name:           def.Name,
+			Detail:         def.Detail,
+			Kind:           kind,
+			Range:          doc.lineRange(def.Line - 1),
+			SelectionRange: doc.definitionRange(def),
+		})
+	}
+	return symbols
+}
+
+// utf16Len returns the length of s in UTF-16 code units, which is how
+// the protocol counts characters.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+// byteOffset converts a character offset in UTF-16 code units to a byte
+// offset in text.
+func byteOffset(text string, character int) int {
+	units := 0
+	for i, r := range text {
+		if units >= character {
+			return i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(text)
+}
@@ -0,0 +1,220 @@
+// Package lsp implements a Language Server Protocol server for G-machine
+// assembly, offering diagnostics, hover, completion, go-to-definition and
+// document symbols to editors.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Server is a language server communicating over a pair of streams, such
+// as stdin and stdout, with the base protocol's Content-Length framing.
+type Server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	documents map[string]*document
+	shutdown  bool
+}
+
+// NewServer returns a server which reads requests from r and writes
+// responses and notifications to w.
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(r),
+		out:       w,
+		documents: map[string]*document{},
+	}
+}
+
+// Serve handles messages until the client sends exit, or the input ends.
+func (s *Server) Serve() error {
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+		reply := &message{JSONRPC: "2.0", ID: msg.ID, Result: result, Error: rerr}
+		if rerr == nil && result == nil {
+			reply.Result = json.RawMessage("null")
+		}
+		if err := s.write(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// maxMessageSize is the largest message accepted from the client, room for
+// a source file far larger than any written by hand.
+const maxMessageSize = 16 << 20
+
+// nullID is the ID of a reply to a message whose ID couldn't be read.
+var nullID = json.RawMessage("null")
+
+// read reads the next message. It returns nil if the message was invalid
+// and has been answered with an error.
+func (s *Server) read() (*message, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %v", err)
+	}
+	if length < 0 || length > maxMessageSize {
+		return nil, fmt.Errorf("invalid Content-Length: %d is not between 0 and %d", length, maxMessageSize)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, s.write(&message{
+			JSONRPC: "2.0",
+			ID:      &nullID,
+			Error:   &responseError{Code: codeParseError, Message: err.Error()},
+		})
+	}
+	return msg, nil
+}
+
+func (s *Server) write(msg *message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.write(&message{JSONRPC: "2.0", Method: method, Params: raw})
+}
+
+// handle dispatches a request or notification, returning the result or
+// error to reply with for requests.
+func (s *Server) handle(msg *message) (interface{}, *responseError) {
+	decode := func(params interface{}) *responseError {
+		if err := json.Unmarshal(msg.Params, params); err != nil {
+			return &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return nil
+	}
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shut down"}
+	}
+	switch msg.Method {
+	case "initialize":
+		result := InitializeResult{}
+		result.ServerInfo.Name = "gasmls"
+		result.Capabilities.TextDocumentSync = 1
+		result.Capabilities.HoverProvider = true
+		result.Capabilities.DefinitionProvider = true
+		result.Capabilities.DocumentSymbolProvider = true
+		return result, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		params := DidOpenParams{}
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		params := DidChangeParams{}
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+	case "textDocument/didClose":
+		params := DidCloseParams{}
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+	case "textDocument/hover", "textDocument/completion", "textDocument/definition":
+		params := TextDocumentPositionParams{}
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil, nil
+		}
+		switch msg.Method {
+		case "textDocument/hover":
+			return doc.hover(params.Position), nil
+		case "textDocument/completion":
+			return doc.completion(), nil
+		}
+		return doc.definition(params.Position), nil
+	case "textDocument/documentSymbol":
+		params := DocumentSymbolParams{}
+		if err := decode(&params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return []DocumentSymbol{}, nil
+		}
+		return doc.symbols(), nil
+	default:
+		if msg.ID != nil && !strings.HasPrefix(msg.Method, "$/") {
+			return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+		}
+	}
+	return nil, nil
+}
+
+// update stores the new text of a document and publishes its
+// diagnostics.
+func (s *Server) update(uri, text string) {
+	doc := newDocument(uri, uriPath(uri), text)
+	s.documents[uri] = doc
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: doc.diagnostics,
+	})
+}
+
+// uriPath returns the file path of a file URI, which is used to resolve
+// includes, or the URI itself for other schemes.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
@@ -0,0 +1,297 @@
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gmachine"
+	"gmachine/lsp"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const uri = "file:///tmp/prog.gasm"
+
+const source = `.equ LEN 2
+start:  SETI message
+        CALL print
+        HALT
+print:  SETA [I]
+        BIOS IOWRITE, STDOUT
+        RETN
+message:
+        .word LEN
+`
+
+type response struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+// session runs the server over a sequence of messages, each of which is a
+// request if it has an ID, and returns everything the server sent.
+func session(t *testing.T, messages ...map[string]interface{}) []response {
+	t.Helper()
+	in := &bytes.Buffer{}
+	for _, msg := range messages {
+		msg["jsonrpc"] = "2.0"
+		body, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	out := &bytes.Buffer{}
+	err := lsp.NewServer(in, out).Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := []response{}
+	r := bufio.NewReader(out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			return responses
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		resp := response{}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, resp)
+	}
+}
+
+func open(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"method": "textDocument/didOpen",
+		"params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "languageId": "gasm", "version": 1, "text": text},
+		},
+	}
+}
+
+func request(id int, method string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     id,
+		"method": method,
+		"params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": line, "character": character},
+		},
+	}
+}
+
+func decode(t *testing.T, raw json.RawMessage, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitialize(t *testing.T) {
+	t.Parallel()
+	responses := session(t,
+		map[string]interface{}{"id": 1, "method": "initialize", "params": map[string]interface{}{}},
+		map[string]interface{}{"id": 2, "method": "shutdown"},
+		map[string]interface{}{"method": "exit"},
+		map[string]interface{}{"id": 3, "method": "never/reached"},
+	)
+	if len(responses) != 2 {
+		t.Fatalf("want 2 responses, got %d", len(responses))
+	}
+	result := lsp.InitializeResult{}
+	decode(t, responses[0].Result, &result)
+	if !result.Capabilities.HoverProvider || result.Capabilities.TextDocumentSync != 1 {
+		t.Errorf("unexpected capabilities %+v", result.Capabilities)
+	}
+	if string(responses[1].Result) != "null" {
+		t.Errorf("want null shutdown result, got %s", responses[1].Result)
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	t.Parallel()
+	responses := session(t, map[string]interface{}{"id": 1, "method": "workspace/unknown"})
+	if len(responses) != 1 || responses[0].Error == nil || responses[0].Error.Code != -32601 {
+		t.Errorf("want method not found error, got %+v", responses)
+	}
+}
+
+func TestInvalidContentLength(t *testing.T) {
+	t.Parallel()
+	for _, length := range []string{"-1", "1099511627776", "lots"} {
+		in := strings.NewReader("Content-Length: " + length + "\r\n\r\n{}")
+		err := lsp.NewServer(in, io.Discard).Serve()
+		if err == nil || !strings.Contains(err.Error(), "invalid Content-Length") {
+			t.Errorf("%s: want invalid Content-Length error, got %v", length, err)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc, text string
+		want       []lsp.Diagnostic
+	}{
+		{
+			desc: "No problems",
+			text: source,
+			want: []lsp.Diagnostic{},
+		},
+		{
+			desc: "Empty document",
+			text: "",
+			want: []lsp.Diagnostic{},
+		},
+		{
+			desc: "Only blank lines and comments",
+			text: "\n   \n; to do\n",
+			want: []lsp.Diagnostic{},
+		},
+		{
+			desc: "Assembly error",
+			text: "INCA\n  JUMP nowhere\nHALT\n",
+			want: []lsp.Diagnostic{{
+				Range:    lsp.Range{Start: lsp.Position{Line: 1, Character: 2}, End: lsp.Position{Line: 1, Character: 14}},
+				Severity: lsp.SeverityError,
+				Source:   "gasm",
+				Message:  `undefined symbol "nowhere"`,
+			}},
+		},
+		{
+			desc: "Vet warning",
+			text: "INCA\nDECA\n",
+			want: []lsp.Diagnostic{{
+				Range:    lsp.Range{Start: lsp.Position{Line: 1}, End: lsp.Position{Line: 1, Character: 4}},
+				Severity: lsp.SeverityWarning,
+				Source:   "gasm vet",
+				Message:  "execution falls through past the end of the program; missing HALT?",
+			}},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			responses := session(t, open(tC.text))
+			if len(responses) != 1 || responses[0].Method != "textDocument/publishDiagnostics" {
+				t.Fatalf("want diagnostics notification, got %+v", responses)
+			}
+			params := lsp.PublishDiagnosticsParams{}
+			decode(t, responses[0].Params, &params)
+			if !cmp.Equal(tC.want, params.Diagnostics) {
+				t.Error(cmp.Diff(tC.want, params.Diagnostics))
+			}
+		})
+	}
+}
+
+func TestHover(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		desc            string
+		line, character int
+		want            string
+	}{
+		{desc: "Mnemonic", line: 3, character: 9, want: gmachine.TranslateTable["HALT"].Doc},
+		{desc: "Label", line: 2, character: 14, want: "label print at address 5"},
+		{desc: "Constant", line: 8, character: 15, want: "LEN = 2 (.equ 2)"},
+		{desc: "Predefined constant", line: 5, character: 24, want: "STDOUT = 1 (predefined)"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			responses := session(t, open(source), request(1, "textDocument/hover", tC.line, tC.character))
+			hover := lsp.Hover{}
+			decode(t, responses[1].Result, &hover)
+			if tC.want != hover.Contents.Value {
+				t.Errorf("want %q, got %q", tC.want, hover.Contents.Value)
+			}
+		})
+	}
+}
+
+func TestHoverNothing(t *testing.T) {
+	t.Parallel()
+	responses := session(t, open(source), request(1, "textDocument/hover", 1, 7))
+	if string(responses[1].Result) != "null" {
+		t.Errorf("want null hover, got %s", responses[1].Result)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	t.Parallel()
+	responses := session(t, open(source), request(1, "textDocument/completion", 2, 8))
+	items := []lsp.CompletionItem{}
+	decode(t, responses[1].Result, &items)
+	want := map[string]int{
+		"SETA":    lsp.CompletionKeyword,
+		"STDOUT":  lsp.CompletionConstant,
+		"LEN":     lsp.CompletionConstant,
+		"message": lsp.CompletionVariable,
+	}
+	for _, item := range items {
+		if kind, ok := want[item.Label]; ok {
+			if kind != item.Kind {
+				t.Errorf("%s: want kind %d, got %d", item.Label, kind, item.Kind)
+			}
+			delete(want, item.Label)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("missing completions %v", want)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	t.Parallel()
+	responses := session(t, open(source), request(1, "textDocument/definition", 1, 15))
+	got := lsp.Location{}
+	decode(t, responses[1].Result, &got)
+	want := lsp.Location{
+		URI:   uri,
+		Range: lsp.Range{Start: lsp.Position{Line: 7}, End: lsp.Position{Line: 7, Character: 7}},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	t.Parallel()
+	responses := session(t, open(source), map[string]interface{}{
+		"id":     1,
+		"method": "textDocument/documentSymbol",
+		"params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}},
+	})
+	symbols := []lsp.DocumentSymbol{}
+	decode(t, responses[1].Result, &symbols)
+	got := []string{}
+	for _, sym := range symbols {
+		got = append(got, fmt.Sprintf("%s %d line %d", sym.Name, sym.Kind, sym.SelectionRange.Start.Line))
+	}
+	want := []string{"LEN 14 line 0", "start 12 line 1", "print 12 line 4", "message 12 line 7"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
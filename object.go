@@ -0,0 +1,387 @@
+package gmachine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// objectMagic identifies a relocatable object file.
+var objectMagic = []byte("GOBJ\x00\x00\x00\x01")
+
+// Object is a relocatable unit of assembled code. Its labels are relative
+// to the start of its code, and Link combines objects into a program,
+// fixing up addresses and references between them.
+type Object struct {
+	Name        string
+	Code        []Word
+	Symbols     []ObjectSymbol
+	Imports     []string
+	Relocations []Relocation
+}
+
+// ObjectSymbol is a label or constant defined by an object. Labels are
+// relocatable; constants are absolute. Only exported symbols can be
+// imported by other objects.
+type ObjectSymbol struct {
+	Name        string
+	Value       Word
+	Relocatable bool
+	Exported    bool
+}
+
+// Relocation marks a code word which must be adjusted when linking: by the
+// address of the object itself when Symbol is empty, or otherwise by the
+// value of the named imported symbol.
+type Relocation struct {
+	Offset Word
+	Symbol string
+}
+
+// The probes are added to label addresses to find out how an expression
+// depends on them. They are odd and unrelated, so that masking or scaling
+// an address shows up as a change other than the probe itself.
+const (
+	relocationProbe1 = 0x10001
+	relocationProbe2 = 0x1000003
+)
+
+// relocate evaluates an expression in a relocatable object with the object
+// and any imported symbols at address zero, and works out the relocation
+// which the linker must apply, if any. Only one relocatable address may be
+// added, with constants added or subtracted.
+func (a *assembler) relocate(tok token) (Word, *Relocation, error) {
+	imports := map[string]bool{}
+	eval := func(base Word, symbol string, value Word) (Word, error) {
+		return EvalExpression(tok.text, func(name string) (Word, bool) {
+			if word, ok := a.labels[name]; ok {
+				return word + base, true
+			}
+			if a.externs[name] {
+				imports[name] = true
+				if name == symbol {
+					return value, true
+				}
+				return 0, true
+			}
+			word, ok := a.constants[name]
+			return word, ok
+		})
+	}
+	word, err := eval(0, "", 0)
+	if err != nil {
+		return 0, nil, tok.wrap(err)
+	}
+	depends := func(base Word, symbol string) (bool, error) {
+		first, err := eval(base*relocationProbe1, symbol, relocationProbe1)
+		if err != nil {
+			return false, err
+		}
+		second, err := eval(base*relocationProbe2, symbol, relocationProbe2)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case first == word && second == word:
+			return false, nil
+		case first-word == relocationProbe1 && second-word == relocationProbe2:
+			return true, nil
+		}
+		return false, fmt.Errorf("expression %q is not relocatable", tok.text)
+	}
+	relocations := []*Relocation{}
+	relative, err := depends(1, "")
+	if err != nil {
+		return 0, nil, tok.wrap(err)
+	}
+	if relative {
+		relocations = append(relocations, &Relocation{})
+	}
+	names := make([]string, 0, len(imports))
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		external, err := depends(0, name)
+		if err != nil {
+			return 0, nil, tok.wrap(err)
+		}
+		if external {
+			relocations = append(relocations, &Relocation{Symbol: name})
+		}
+	}
+	switch len(relocations) {
+	case 0:
+		return word, nil, nil
+	case 1:
+		return word, relocations[0], nil
+	}
+	return 0, nil, tok.errorf("expression %q refers to more than one relocatable address", tok.text)
+}
+
+// AssembleObject assembles several source files together into a
+// relocatable object. Symbols listed with `.global` are exported, and
+// symbols listed with `.extern` are imported from other objects.
+func AssembleObject(paths ...string) (*Object, error) {
+	tokens, err := readFiles(hostSources{}, paths)
+	if err != nil {
+		return nil, err
+	}
+	a := newAssembler()
+	a.relocatable = true
+	err = a.assemble(tokens)
+	if err != nil {
+		return nil, err
+	}
+	obj := &Object{
+		Code:        a.words,
+		Relocations: a.relocations,
+	}
+	if len(paths) > 0 {
+		obj.Name = paths[0]
+	}
+	for name := range a.externs {
+		obj.Imports = append(obj.Imports, name)
+	}
+	sort.Strings(obj.Imports)
+	for name, word := range a.labels {
+		_, exported := a.globals[name]
+		obj.Symbols = append(obj.Symbols, ObjectSymbol{
+			Name:        name,
+			Value:       word,
+			Relocatable: true,
+			Exported:    exported,
+		})
+	}
+	for name, tok := range a.globals {
+		if _, ok := a.labels[name]; ok {
+			continue
+		}
+		word, ok := a.constants[name]
+		if !ok {
+			return nil, tok.errorf("undefined global symbol %q", name)
+		}
+		obj.Symbols = append(obj.Symbols, ObjectSymbol{Name: name, Value: word, Exported: true})
+	}
+	sort.Slice(obj.Symbols, func(i, j int) bool {
+		return obj.Symbols[i].Name < obj.Symbols[j].Name
+	})
+	return obj, nil
+}
+
+// Link combines objects into a program, placing them one after another
+// starting at address zero, so execution starts with the first object.
+// It reports every import, used or not, which no object exports, and every
+// symbol exported by more than one object.
+func Link(objects ...*Object) ([]Word, error) {
+	type definition struct {
+		value Word
+		obj   *Object
+	}
+	globals := map[string]definition{}
+	bases := make([]Word, len(objects))
+	words := []Word{}
+	problems := []string{}
+	for i, obj := range objects {
+		bases[i] = Word(len(words))
+		words = append(words, obj.Code...)
+		for _, sym := range obj.Symbols {
+			if !sym.Exported {
+				continue
+			}
+			if previous, ok := globals[sym.Name]; ok {
+				problems = append(problems, fmt.Sprintf("duplicate symbol %q defined in %s and %s", sym.Name, previous.obj.Name, obj.Name))
+				continue
+			}
+			value := sym.Value
+			if sym.Relocatable {
+				value += bases[i]
+			}
+			globals[sym.Name] = definition{value: value, obj: obj}
+		}
+	}
+	unresolved := map[string]bool{}
+	reportUnresolved := func(name string, obj *Object) {
+		key := fmt.Sprintf("unresolved symbol %q referenced by %s", name, obj.Name)
+		if !unresolved[key] {
+			unresolved[key] = true
+			problems = append(problems, key)
+		}
+	}
+	for i, obj := range objects {
+		for _, name := range obj.Imports {
+			if _, ok := globals[name]; !ok {
+				reportUnresolved(name, obj)
+			}
+		}
+		for _, r := range obj.Relocations {
+			if r.Offset >= Word(len(obj.Code)) {
+				return nil, fmt.Errorf("%s: relocation offset %d is outside the code", obj.Name, r.Offset)
+			}
+			addr := bases[i] + r.Offset
+			if r.Symbol == "" {
+				words[addr] += bases[i]
+				continue
+			}
+			def, ok := globals[r.Symbol]
+			if !ok {
+				reportUnresolved(r.Symbol, obj)
+				continue
+			}
+			words[addr] += def.value
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "\n"))
+	}
+	return words, nil
+}
+
+// WriteObject writes an object in the object file format: a magic number
+// followed by big-endian words, with strings stored as a length and bytes.
+func WriteObject(w io.Writer, obj *Object) error {
+	ow := &objectWriter{w: w}
+	ow.bytes(objectMagic)
+	ow.string(obj.Name)
+	ow.word(Word(len(obj.Code)))
+	for _, word := range obj.Code {
+		ow.word(word)
+	}
+	ow.word(Word(len(obj.Symbols)))
+	for _, sym := range obj.Symbols {
+		ow.string(sym.Name)
+		ow.word(sym.Value)
+		var flags Word
+		if sym.Relocatable {
+			flags |= 1
+		}
+		if sym.Exported {
+			flags |= 2
+		}
+		ow.word(flags)
+	}
+	ow.word(Word(len(obj.Imports)))
+	for _, name := range obj.Imports {
+		ow.string(name)
+	}
+	ow.word(Word(len(obj.Relocations)))
+	for _, r := range obj.Relocations {
+		ow.word(r.Offset)
+		ow.string(r.Symbol)
+	}
+	return ow.err
+}
+
+// ReadObject reads an object written by WriteObject.
+func ReadObject(r io.Reader) (*Object, error) {
+	or := &objectReader{r: r}
+	magic := or.bytes(len(objectMagic))
+	if or.err == nil && string(magic) != string(objectMagic) {
+		return nil, errors.New("not a G-machine object file")
+	}
+	obj := &Object{Name: or.string()}
+	obj.Code = or.words()
+	obj.Symbols = []ObjectSymbol{}
+	for n := or.count(); len(obj.Symbols) < n && or.err == nil; {
+		sym := ObjectSymbol{Name: or.string(), Value: or.word()}
+		flags := or.word()
+		sym.Relocatable = flags&1 != 0
+		sym.Exported = flags&2 != 0
+		obj.Symbols = append(obj.Symbols, sym)
+	}
+	obj.Imports = []string{}
+	for n := or.count(); len(obj.Imports) < n && or.err == nil; {
+		obj.Imports = append(obj.Imports, or.string())
+	}
+	obj.Relocations = []Relocation{}
+	for n := or.count(); len(obj.Relocations) < n && or.err == nil; {
+		obj.Relocations = append(obj.Relocations, Relocation{Offset: or.word(), Symbol: or.string()})
+	}
+	if or.err != nil {
+		return nil, or.err
+	}
+	return obj, nil
+}
+
+type objectWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ow *objectWriter) bytes(b []byte) {
+	if ow.err != nil {
+		return
+	}
+	_, ow.err = ow.w.Write(b)
+}
+
+func (ow *objectWriter) word(word Word) {
+	rawBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(rawBytes, uint64(word))
+	ow.bytes(rawBytes)
+}
+
+func (ow *objectWriter) string(s string) {
+	ow.word(Word(len(s)))
+	ow.bytes([]byte(s))
+}
+
+// maxObjectCount bounds the lengths read from an object file. The reader
+// also grows what it reads as the data arrives, rather than trusting a
+// count, so that a short, corrupt file can't make ReadObject or ReadBinary
+// allocate much more memory than the file itself.
+const maxObjectCount = 1 << 24
+
+type objectReader struct {
+	r   io.Reader
+	err error
+}
+
+func (or *objectReader) bytes(n int) []byte {
+	if or.err != nil {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	_, or.err = io.CopyN(buf, or.r, int64(n))
+	if or.err == io.EOF {
+		or.err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes()
+}
+
+func (or *objectReader) word() Word {
+	b := or.bytes(8)
+	if or.err != nil {
+		return 0
+	}
+	return Word(binary.BigEndian.Uint64(b))
+}
+
+func (or *objectReader) count() int {
+	n := or.word()
+	if n > maxObjectCount {
+		if or.err == nil {
+			or.err = fmt.Errorf("invalid object file: count %d too large", n)
+		}
+		return 0
+	}
+	return int(n)
+}
+
+// words reads a count and then that many words.
+func (or *objectReader) words() []Word {
+	words := []Word{}
+	for n := or.count(); len(words) < n && or.err == nil; {
+		words = append(words, or.word())
+	}
+	return words
+}
+
+func (or *objectReader) string() string {
+	return string(or.bytes(or.count()))
+}
@@ -0,0 +1,264 @@
+// Package playground serves a web page where G-machine programs can be
+// written, assembled, run and stepped through, and the JSON API behind it,
+// which other tools can also call:
+//
+//	POST /api/assemble  {"source": "..."}
+//	POST /api/run       {"source": "...", "stdin": "...", "steps": 100}
+//
+// Programs run on a fresh machine for each request, with no disk or file
+// access, and for a bounded number of instructions. Stepping through a
+// program is done by running it again for one more step.
+package playground
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gmachine"
+	"gmachine/internal/sandbox"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Limits on the work done for a request.
+const (
+	// MaxSteps is the most instructions a program may execute.
+	MaxSteps = 1000000
+	// maxOutput is the most output kept from each of stdout and stderr.
+	maxOutput = 64 * 1024
+	// maxBody is the largest request body accepted.
+	maxBody = 1 << 20
+)
+
+// sourceName is the name of the playground's only source file, used in
+// positions.
+const sourceName = "main.gasm"
+
+//go:embed static
+var static embed.FS
+
+// AssembleRequest is the body of a request to /api/assemble.
+type AssembleRequest struct {
+	Source string `json:"source"`
+}
+
+// AssembleResponse gives the assembled program, or the error which
+// stopped it assembling.
+type AssembleResponse struct {
+	Error       *Diagnostic              `json:"error,omitempty"`
+	Words       []gmachine.Word          `json:"words"`
+	Listing     []ListingLine            `json:"listing"`
+	Symbols     map[string]gmachine.Word `json:"symbols"`
+	Diagnostics []Diagnostic             `json:"diagnostics"`
+}
+
+// ListingLine relates the words at Addr to the source line they were
+// assembled from.
+type ListingLine struct {
+	Addr   gmachine.Word   `json:"addr"`
+	Words  []gmachine.Word `json:"words"`
+	Line   int             `json:"line"`
+	Source string          `json:"source"`
+}
+
+// Diagnostic is an assembly error or a warning from gasm vet. Line is
+// zero when the problem isn't on a line of the source, such as a missing
+// include.
+type Diagnostic struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// RunRequest is the body of a request to /api/run. The program runs for
+// Steps instructions, or until it halts; zero or anything above MaxSteps
+// means MaxSteps.
+type RunRequest struct {
+	Source string `json:"source"`
+	Stdin  string `json:"stdin"`
+	Steps  int    `json:"steps"`
+}
+
+// RunResponse gives the state of the machine once the program has halted,
+// faulted or used up its steps. Line is the source line of the next
+// instruction, or of the faulting one, if it has one.
+type RunResponse struct {
+	Error     *Diagnostic     `json:"error,omitempty"`
+	Steps     int             `json:"steps"`
+	Halted    bool            `json:"halted"`
+	Fault     string          `json:"fault,omitempty"`
+	Stdout    string          `json:"stdout"`
+	Stderr    string          `json:"stderr"`
+	Registers Registers       `json:"registers"`
+	Line      int             `json:"line,omitempty"`
+	Memory    []gmachine.Word `json:"memory"`
+}
+
+// Registers holds the machine's registers and flags.
+type Registers = sandbox.Registers
+
+// Handler returns a handler serving the playground page at / and its API
+// under /api/.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	page, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("/", http.FileServer(http.FS(page)))
+	mux.HandleFunc("/api/assemble", post(func(r *http.Request) (interface{}, error) {
+		req := AssembleRequest{}
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return Assemble(req), nil
+	}))
+	mux.HandleFunc("/api/run", post(func(r *http.Request) (interface{}, error) {
+		req := RunRequest{}
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return Run(req), nil
+	}))
+	return mux
+}
+
+// post adapts an API function to a handler of POST requests with JSON
+// bodies and responses.
+func post(api func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		resp, err := api(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func decode(r *http.Request, req interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return fmt.Errorf("invalid request: %v", err)
+	}
+	return nil
+}
+
+// assemble assembles source as the only file in the playground, so that
+// it can't include files from the host, and no larger than the machine's
+// memory.
+func assemble(source string) (*gmachine.Program, *Diagnostic) {
+	files := sandbox.Files{sourceName: source}
+	program, err := gmachine.AssembleProgramFSLimit(files, gmachine.DefaultMemSize, sourceName)
+	if err != nil {
+		d := &Diagnostic{Message: err.Error()}
+		var aerr *gmachine.AssemblyError
+		if errors.As(err, &aerr) && aerr.Pos.File == sourceName {
+			d.Line = aerr.Pos.Line
+			d.Message = aerr.Err.Error()
+		}
+		return nil, d
+	}
+	return program, nil
+}
+
+// Assemble carries out an assemble request.
+func Assemble(req AssembleRequest) AssembleResponse {
+	resp := AssembleResponse{
+		Words:       []gmachine.Word{},
+		Listing:     []ListingLine{},
+		Symbols:     map[string]gmachine.Word{},
+		Diagnostics: []Diagnostic{},
+	}
+	program, d := assemble(req.Source)
+	if d != nil {
+		resp.Error = d
+		return resp
+	}
+	resp.Words = program.Words
+	for _, l := range program.Listing {
+		resp.Listing = append(resp.Listing, ListingLine{
+			Addr:   l.Addr,
+			Words:  l.Words,
+			Line:   l.Pos.Line,
+			Source: l.Source,
+		})
+	}
+	for _, sym := range program.Symbols.Symbols {
+		resp.Symbols[sym.Name] = sym.Value
+	}
+	for _, d := range gmachine.Vet(program) {
+		resp.Diagnostics = append(resp.Diagnostics, Diagnostic{Line: d.Pos.Line, Message: d.Message})
+	}
+	return resp
+}
+
+// Run carries out a run request.
+func Run(req RunRequest) RunResponse {
+	resp := RunResponse{Memory: []gmachine.Word{}}
+	program, d := assemble(req.Source)
+	if d != nil {
+		resp.Error = d
+		return resp
+	}
+	g := gmachine.New()
+	if len(program.Words) > len(g.Memory) {
+		resp.Error = &Diagnostic{Message: fmt.Sprintf("program of %d words does not fit in %d words of memory", len(program.Words), len(g.Memory))}
+		return resp
+	}
+	copy(g.Memory, program.Words)
+	g.Debug = program.DebugInfo()
+	g.Symbols = program.Symbols
+	stdout := &sandbox.LimitedBuffer{Max: maxOutput}
+	stderr := &sandbox.LimitedBuffer{Max: maxOutput}
+	g.Stdin = strings.NewReader(req.Stdin)
+	g.Stdout, g.Stderr = stdout, stderr
+	g.Clock = clock{}
+	steps := req.Steps
+	if steps <= 0 || steps > MaxSteps {
+		steps = MaxSteps
+	}
+	next := g.P
+	for resp.Steps < steps {
+		running, err := g.Step()
+		resp.Steps++
+		next = g.P
+		if err != nil {
+			resp.Fault = err.Error()
+			var fault *gmachine.Fault
+			if errors.As(err, &fault) {
+				next = fault.P
+			}
+			break
+		}
+		if !running {
+			resp.Halted = true
+			break
+		}
+	}
+	resp.Stdout, resp.Stderr = stdout.String(), stderr.String()
+	resp.Registers = sandbox.RegistersOf(g)
+	if pos, ok := g.Debug.Line(next); ok && !resp.Halted {
+		resp.Line = pos.Line
+	}
+	resp.Memory = g.Memory
+	return resp
+}
+
+// clock tells the real time, but doesn't sleep, so that a program can't
+// hold up a request.
+type clock struct{}
+
+func (clock) Now() time.Time {
+	return time.Now()
+}
+
+func (clock) Sleep(time.Duration) {}
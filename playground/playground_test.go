@@ -0,0 +1,211 @@
+package playground_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"gmachine"
+	"gmachine/playground"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const hello = `start:  SETI message
+loop:   SETA [I]
+        CMPA 0
+        JEQ print
+        HALT
+print:  BIOS IOWRITE, STDOUT
+        INCI
+        JUMP loop
+message:
+        .stringz "Hi"
+`
+
+func post(t *testing.T, server *httptest.Server, path string, req, resp interface{}) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.Post(server.URL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		t.Fatalf("want status 200, got %s", r.Status)
+	}
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(playground.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPage(t *testing.T) {
+	t.Parallel()
+	server := newServer(t)
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "<title>G-machine playground</title>") {
+		t.Errorf("want playground page, got %q", page)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	t.Parallel()
+	server := newServer(t)
+	got := playground.AssembleResponse{}
+	post(t, server, "/api/assemble", playground.AssembleRequest{Source: "SETA 1\nHALT\nNOOP\n"}, &got)
+	want := playground.AssembleResponse{
+		Words: []gmachine.Word{gmachine.SETA, 1, gmachine.HALT, gmachine.NOOP},
+		Listing: []playground.ListingLine{
+			{Addr: 0, Words: []gmachine.Word{gmachine.SETA, 1}, Line: 1, Source: "SETA 1"},
+			{Addr: 2, Words: []gmachine.Word{gmachine.HALT}, Line: 2, Source: "HALT"},
+			{Addr: 3, Words: []gmachine.Word{gmachine.NOOP}, Line: 3, Source: "NOOP"},
+		},
+		Symbols: map[string]gmachine.Word{},
+		Diagnostics: []playground.Diagnostic{
+			{Line: 3, Message: "unreachable code"},
+		},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	t.Parallel()
+	server := newServer(t)
+	testCases := []struct {
+		source string
+		want   playground.Diagnostic
+	}{
+		{
+			source: "HALT\nBOGUS\n",
+			want:   playground.Diagnostic{Line: 2, Message: `undefined symbol "BOGUS"`},
+		},
+		{
+			source: ".include \"/etc/passwd\"\n",
+			want:   playground.Diagnostic{Line: 1, Message: "open etc/passwd: file does not exist"},
+		},
+		{
+			source: "HALT\n.space 100000000\n",
+			want:   playground.Diagnostic{Line: 2, Message: "program would be larger than 1024 words"},
+		},
+	}
+	for _, tC := range testCases {
+		got := playground.AssembleResponse{}
+		post(t, server, "/api/assemble", playground.AssembleRequest{Source: tC.source}, &got)
+		if got.Error == nil || !strings.Contains(got.Error.Message, tC.want.Message) || got.Error.Line != tC.want.Line {
+			t.Errorf("%q: want error %+v, got %+v", tC.source, tC.want, got.Error)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	server := newServer(t)
+	got := playground.RunResponse{}
+	post(t, server, "/api/run", playground.RunRequest{Source: hello}, &got)
+	if !got.Halted || got.Stdout != "Hi" || got.Fault != "" {
+		t.Errorf("want halted with output Hi, got %+v", got)
+	}
+	if got.Steps != 17 {
+		t.Errorf("want 17 steps, got %d", got.Steps)
+	}
+	if len(got.Memory) != gmachine.DefaultMemSize || got.Memory[0] != gmachine.SETI {
+		t.Errorf("want program in memory, got %v", got.Memory[:4])
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	t.Parallel()
+	server := newServer(t)
+	testCases := []struct {
+		steps             int
+		wantLine          int
+		wantA, wantI      gmachine.Word
+		wantStdout        string
+		wantZ, wantHalted bool
+	}{
+		{steps: 1, wantLine: 2, wantI: 14},
+		{steps: 2, wantLine: 3, wantA: 'H', wantI: 14},
+		{steps: 5, wantLine: 7, wantA: 'H', wantI: 14, wantStdout: "H"},
+		{steps: 100, wantA: 0, wantI: 16, wantStdout: "Hi", wantZ: true, wantHalted: true},
+	}
+	for _, tC := range testCases {
+		got := playground.RunResponse{}
+		post(t, server, "/api/run", playground.RunRequest{Source: hello, Steps: tC.steps}, &got)
+		r := got.Registers
+		if got.Line != tC.wantLine || r.A != tC.wantA || r.I != tC.wantI || r.FlagZ != tC.wantZ ||
+			got.Stdout != tC.wantStdout || got.Halted != tC.wantHalted {
+			t.Errorf("%d steps: want line %d A=%d I=%d Z=%t output %q halted %t, got line %d A=%d I=%d Z=%t output %q halted %t",
+				tC.steps, tC.wantLine, tC.wantA, tC.wantI, tC.wantZ, tC.wantStdout, tC.wantHalted,
+				got.Line, r.A, r.I, r.FlagZ, got.Stdout, got.Halted)
+		}
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	t.Parallel()
+	server := newServer(t)
+	got := playground.RunResponse{}
+	post(t, server, "/api/run", playground.RunRequest{Source: "loop: JUMP loop"}, &got)
+	if got.Halted || got.Steps != playground.MaxSteps {
+		t.Errorf("want stop after %d steps, got %d (halted %t)", playground.MaxSteps, got.Steps, got.Halted)
+	}
+	got = playground.RunResponse{}
+	post(t, server, "/api/run", playground.RunRequest{Source: "NOOP\n.word 99\n"}, &got)
+	if want := "fault at main.gasm:2: invalid opcode 99"; got.Fault != want || got.Line != 2 {
+		t.Errorf("want fault %q on line 2, got %q on line %d", want, got.Fault, got.Line)
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	t.Parallel()
+	server := newServer(t)
+	source := "BIOS IOREAD, STDIN\nBIOS IOWRITE, STDERR\nHALT\n"
+	got := playground.RunResponse{}
+	post(t, server, "/api/run", playground.RunRequest{Source: source, Stdin: "x"}, &got)
+	if got.Stderr != "x" || got.Stdout != "" {
+		t.Errorf("want x echoed to stderr, got stdout %q stderr %q", got.Stdout, got.Stderr)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	t.Parallel()
+	server := newServer(t)
+	resp, err := http.Get(server.URL + "/api/run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: want status 405, got %s", resp.Status)
+	}
+	resp, err = http.Post(server.URL+"/api/run", "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid JSON: want status 400, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,343 @@
+// Package runner is an HTTP service which runs G-machine jobs for clients
+// such as CI systems. A job is submitted as JSON to POST /v1/run, with the
+// program given as source or as a binary, and the result comes back as
+// JSON: the program's output, how it ended, any fault, and the final state
+// of the registers. GET /v1/limits reports the server's limits.
+//
+// Each job runs on its own machine, with no disk or host file access, and
+// within limits on memory, instructions, time and output. Jobs beyond the
+// limit on concurrency are refused with 503 Service Unavailable, for the
+// client to retry.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gmachine"
+	"gmachine/internal/sandbox"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Job is a program to run. Exactly one of Source and Binary must be given.
+// Source may include the other files in Files, by name. Binary is a plain
+// binary or one with debug info, as written by gasm, and is base64 encoded
+// in JSON.
+type Job struct {
+	Source string            `json:"source,omitempty"`
+	Files  map[string]string `json:"files,omitempty"`
+	Binary []byte            `json:"binary,omitempty"`
+	Stdin  string            `json:"stdin,omitempty"`
+	// MemorySize is the size of memory in words, and MaxSteps the most
+	// instructions to execute. Zero means gmachine.DefaultMemSize and the
+	// server's limit, respectively.
+	MemorySize int `json:"memorySize,omitempty"`
+	MaxSteps   int `json:"maxSteps,omitempty"`
+}
+
+// Statuses of a Result, and the corresponding exit codes.
+const (
+	StatusHalted  = "halted"
+	StatusFault   = "fault"
+	StatusLimit   = "limit"
+	StatusTimeout = "timeout"
+	StatusError   = "error"
+)
+
+var exitCodes = map[string]int{
+	StatusHalted:  0,
+	StatusFault:   1,
+	StatusLimit:   2,
+	StatusTimeout: 2,
+	StatusError:   3,
+}
+
+// Result is the outcome of a job. Status says how it ended: the program
+// halted, faulted, used up its instructions or its time, or couldn't be
+// assembled or loaded, in which case Error says why. ExitCode gives the
+// same as a process exit status, zero only if the program halted.
+type Result struct {
+	Status    string    `json:"status"`
+	ExitCode  int       `json:"exitCode"`
+	Error     string    `json:"error,omitempty"`
+	Fault     *Fault    `json:"fault,omitempty"`
+	Stdout    string    `json:"stdout"`
+	Stderr    string    `json:"stderr"`
+	Steps     int       `json:"steps"`
+	Registers Registers `json:"registers"`
+	// ElapsedMs is how long the program ran, in milliseconds.
+	ElapsedMs int64 `json:"elapsedMs"`
+}
+
+// Fault describes the instruction at which a program faulted.
+type Fault struct {
+	P        gmachine.Word `json:"P"`
+	Location string        `json:"location"`
+	Reason   string        `json:"reason"`
+}
+
+// Registers holds the machine's registers and flags.
+type Registers = sandbox.Registers
+
+// Limits bound the resources which the server and each job use.
+type Limits struct {
+	// Concurrency is the most jobs run at once.
+	Concurrency int
+	// MemorySize is the largest memory, in words, a job may ask for.
+	MemorySize int
+	// MaxSteps is the most instructions a job may execute.
+	MaxSteps int
+	// Timeout is how long a job may run, in wall-clock time.
+	Timeout time.Duration
+	// MaxOutput is the most bytes kept from each of stdout and stderr.
+	MaxOutput int
+	// MaxRequest is the largest request body accepted, in bytes.
+	MaxRequest int64
+}
+
+// DefaultLimits are the limits of a server whose limits are zero.
+var DefaultLimits = Limits{
+	Concurrency: 4,
+	MemorySize:  1 << 20,
+	MaxSteps:    100000000,
+	Timeout:     10 * time.Second,
+	MaxOutput:   1 << 20,
+	MaxRequest:  16 << 20,
+}
+
+// checkInterval is how many instructions run between checks of the
+// timeout.
+const checkInterval = 1024
+
+// Server runs jobs submitted over HTTP.
+type Server struct {
+	limits Limits
+	slots  chan struct{}
+	mux    *http.ServeMux
+}
+
+// NewServer returns a server with the given limits, taking any which are
+// zero from DefaultLimits.
+func NewServer(limits Limits) *Server {
+	if limits.Concurrency <= 0 {
+		limits.Concurrency = DefaultLimits.Concurrency
+	}
+	if limits.MemorySize <= 0 {
+		limits.MemorySize = DefaultLimits.MemorySize
+	}
+	if limits.MaxSteps <= 0 {
+		limits.MaxSteps = DefaultLimits.MaxSteps
+	}
+	if limits.Timeout <= 0 {
+		limits.Timeout = DefaultLimits.Timeout
+	}
+	if limits.MaxOutput <= 0 {
+		limits.MaxOutput = DefaultLimits.MaxOutput
+	}
+	if limits.MaxRequest <= 0 {
+		limits.MaxRequest = DefaultLimits.MaxRequest
+	}
+	s := &Server{
+		limits: limits,
+		slots:  make(chan struct{}, limits.Concurrency),
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/v1/run", s.handleRun)
+	s.mux.HandleFunc("/v1/limits", s.handleLimits)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Concurrency int   `json:"concurrency"`
+		MemorySize  int   `json:"memorySize"`
+		MaxSteps    int   `json:"maxSteps"`
+		TimeoutMs   int64 `json:"timeoutMs"`
+		MaxOutput   int   `json:"maxOutput"`
+		MaxRequest  int64 `json:"maxRequest"`
+	}{
+		Concurrency: s.limits.Concurrency,
+		MemorySize:  s.limits.MemorySize,
+		MaxSteps:    s.limits.MaxSteps,
+		TimeoutMs:   s.limits.Timeout.Milliseconds(),
+		MaxOutput:   s.limits.MaxOutput,
+		MaxRequest:  s.limits.MaxRequest,
+	})
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	job := Job{}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.limits.MaxRequest))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&job); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid job: %v", err))
+		return
+	}
+	if err := s.check(&job); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("already running %d jobs", s.limits.Concurrency))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.limits.Timeout)
+	defer cancel()
+	result := s.Run(ctx, job)
+	status := http.StatusOK
+	if result.Status == StatusError {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, result)
+}
+
+// check validates a job against the limits, filling in defaults.
+func (s *Server) check(job *Job) error {
+	if (job.Source == "") == (len(job.Binary) == 0) {
+		return errors.New("invalid job: give either source or binary")
+	}
+	if len(job.Files) > 0 && job.Source == "" {
+		return errors.New("invalid job: files are only for source")
+	}
+	if job.MemorySize == 0 {
+		job.MemorySize = gmachine.DefaultMemSize
+	}
+	if job.MemorySize < 0 || job.MemorySize > s.limits.MemorySize {
+		return fmt.Errorf("invalid job: memory size must be between 1 and %d words", s.limits.MemorySize)
+	}
+	if job.MaxSteps == 0 {
+		job.MaxSteps = s.limits.MaxSteps
+	}
+	if job.MaxSteps < 0 || job.MaxSteps > s.limits.MaxSteps {
+		return fmt.Errorf("invalid job: instruction limit must be between 1 and %d", s.limits.MaxSteps)
+	}
+	return nil
+}
+
+// mainFile is the name under which a job's source is assembled.
+const mainFile = "main.gasm"
+
+// load assembles or reads the job's program. Assembly stops as soon as
+// the program outgrows the job's memory.
+func load(job Job) ([]gmachine.Word, *gmachine.DebugInfo, error) {
+	if len(job.Binary) > 0 {
+		return gmachine.ReadBinary(bytes.NewReader(job.Binary))
+	}
+	files := sandbox.Files{}
+	for name, text := range job.Files {
+		files[name] = text
+	}
+	files[mainFile] = job.Source
+	program, err := gmachine.AssembleProgramFSLimit(files, job.MemorySize, mainFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return program.Words, program.DebugInfo(), nil
+}
+
+// Run runs a job within the server's limits, until it ends or ctx is
+// done.
+func (s *Server) Run(ctx context.Context, job Job) Result {
+	if err := s.check(&job); err != nil {
+		return Result{Status: StatusError, ExitCode: exitCodes[StatusError], Error: err.Error()}
+	}
+	words, debug, err := load(job)
+	if err != nil {
+		return Result{Status: StatusError, ExitCode: exitCodes[StatusError], Error: err.Error()}
+	}
+	g := gmachine.New()
+	g.Memory = make([]gmachine.Word, job.MemorySize)
+	if len(words) > len(g.Memory) {
+		err := fmt.Sprintf("program of %d words does not fit in %d words of memory", len(words), len(g.Memory))
+		return Result{Status: StatusError, ExitCode: exitCodes[StatusError], Error: err}
+	}
+	copy(g.Memory, words)
+	g.Debug = debug
+	stdout := &sandbox.LimitedBuffer{Max: s.limits.MaxOutput}
+	stderr := &sandbox.LimitedBuffer{Max: s.limits.MaxOutput}
+	g.Stdin = strings.NewReader(job.Stdin)
+	g.Stdout, g.Stderr = stdout, stderr
+	clk := &clock{ctx: ctx}
+	g.Clock = clk
+	result := Result{Status: StatusLimit}
+	start := time.Now()
+loop:
+	for result.Steps < job.MaxSteps {
+		if (clk.interrupted || result.Steps%checkInterval == 0) && ctx.Err() != nil {
+			result.Status = StatusTimeout
+			break
+		}
+		running, err := g.Step()
+		result.Steps++
+		switch {
+		case err != nil:
+			result.Status = StatusFault
+			var fault *gmachine.Fault
+			if errors.As(err, &fault) {
+				result.Fault = &Fault{P: fault.P, Location: fault.Location, Reason: fault.Reason}
+			}
+			break loop
+		case !running:
+			result.Status = StatusHalted
+			break loop
+		}
+	}
+	result.ElapsedMs = time.Since(start).Milliseconds()
+	result.ExitCode = exitCodes[result.Status]
+	result.Stdout, result.Stderr = stdout.String(), stderr.String()
+	result.Registers = sandbox.RegistersOf(g)
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// clock tells the real time, and sleeps only until the job's time is up.
+type clock struct {
+	ctx context.Context
+	// interrupted is set when a sleep is cut short.
+	interrupted bool
+}
+
+func (c *clock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *clock) Sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.ctx.Done():
+		c.interrupted = true
+	}
+}
@@ -0,0 +1,265 @@
+package runner_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"gmachine"
+	"gmachine/runner"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func newServer(t *testing.T, limits runner.Limits) *httptest.Server {
+	server := httptest.NewServer(runner.NewServer(limits))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// submit posts a job, returning the status code and decoding the response
+// into result.
+func submit(t *testing.T, server *httptest.Server, job interface{}, result interface{}) int {
+	t.Helper()
+	body, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(server.URL+"/v1/run", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestRunSource(t *testing.T) {
+	t.Parallel()
+	server := newServer(t, runner.Limits{})
+	job := runner.Job{
+		Source: ".include \"lib/echo.gasm\"\nHALT\n",
+		Files: map[string]string{
+			"lib/echo.gasm": "BIOS IOREAD, STDIN\nBIOS IOWRITE, STDOUT\nINCA\nBIOS IOWRITE, STDERR\n",
+		},
+		Stdin: "a",
+	}
+	got := runner.Result{}
+	if status := submit(t, server, job, &got); status != http.StatusOK {
+		t.Fatalf("want status 200, got %d", status)
+	}
+	want := runner.Result{
+		Status:    runner.StatusHalted,
+		Stdout:    "a",
+		Stderr:    "b",
+		Steps:     5,
+		Registers: runner.Registers{A: 'b', P: 11},
+	}
+	if !cmp.Equal(want, got, cmpopts.IgnoreFields(runner.Result{}, "ElapsedMs")) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestRunBinaryFault(t *testing.T) {
+	t.Parallel()
+	server := newServer(t, runner.Limits{})
+	program, err := gmachine.AssembleProgram("../testdata/listing/count.gasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := &bytes.Buffer{}
+	if err := gmachine.WriteBinary(binary, program.Words, program.DebugInfo()); err != nil {
+		t.Fatal(err)
+	}
+	got := runner.Result{}
+	if status := submit(t, server, runner.Job{Binary: binary.Bytes()}, &got); status != http.StatusOK {
+		t.Fatalf("want status 200, got %d", status)
+	}
+	if got.Status != runner.StatusFault || got.ExitCode != 1 {
+		t.Errorf("want fault with exit code 1, got %q with %d", got.Status, got.ExitCode)
+	}
+	want := &runner.Fault{P: 9, Location: "../testdata/listing/count.gasm:14 (bad)", Reason: "invalid opcode 99"}
+	if !cmp.Equal(want, got.Fault) {
+		t.Error(cmp.Diff(want, got.Fault))
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	t.Parallel()
+	server := newServer(t, runner.Limits{MaxSteps: 1000, Timeout: 50 * time.Millisecond, MaxOutput: 3})
+	testCases := []struct {
+		desc       string
+		job        runner.Job
+		wantStatus string
+		wantSteps  int
+		wantStdout string
+	}{
+		{
+			desc:       "Instruction limit",
+			job:        runner.Job{Source: "loop: JUMP loop", MaxSteps: 10},
+			wantStatus: runner.StatusLimit,
+			wantSteps:  10,
+		},
+		{
+			desc:       "Server instruction limit",
+			job:        runner.Job{Source: "loop: JUMP loop"},
+			wantStatus: runner.StatusLimit,
+			wantSteps:  1000,
+		},
+		{
+			desc:       "Timeout",
+			job:        runner.Job{Source: "SETA 10000\nBIOS SLEEP, CLOCK\nHALT"},
+			wantStatus: runner.StatusTimeout,
+			wantSteps:  2,
+		},
+		{
+			desc:       "Output limit",
+			job:        runner.Job{Source: "SETA 'x'\nloop: BIOS IOWRITE, STDOUT\nJUMP loop", MaxSteps: 20},
+			wantStatus: runner.StatusLimit,
+			wantSteps:  20,
+			wantStdout: "xxx",
+		},
+	}
+	for _, tC := range testCases {
+		got := runner.Result{}
+		submit(t, server, tC.job, &got)
+		if got.Status != tC.wantStatus || got.ExitCode != 2 || got.Steps != tC.wantSteps || got.Stdout != tC.wantStdout {
+			t.Errorf("%s: want %s after %d steps with output %q, got %s (exit code %d) after %d steps with output %q",
+				tC.desc, tC.wantStatus, tC.wantSteps, tC.wantStdout, got.Status, got.ExitCode, got.Steps, got.Stdout)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	t.Parallel()
+	server := newServer(t, runner.Limits{MemorySize: 2048})
+	testCases := []struct {
+		desc       string
+		job        interface{}
+		wantStatus int
+		wantError  string
+	}{
+		{
+			desc:       "No program",
+			job:        runner.Job{Stdin: "x"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "give either source or binary",
+		},
+		{
+			desc:       "Source and binary",
+			job:        runner.Job{Source: "HALT", Binary: []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "give either source or binary",
+		},
+		{
+			desc:       "Too much memory",
+			job:        runner.Job{Source: "HALT", MemorySize: 4096},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "memory size must be between 1 and 2048 words",
+		},
+		{
+			desc:       "Unknown field",
+			job:        map[string]string{"source": "HALT", "memory": "lots"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  `unknown field "memory"`,
+		},
+		{
+			desc:       "Assembly error",
+			job:        runner.Job{Source: "HALT\nSETA\n"},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "main.gasm:2",
+		},
+		{
+			desc:       "Program too big for memory",
+			job:        runner.Job{Source: "SETA 1\nSETA 2\nHALT", MemorySize: 4},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "program of 5 words does not fit in 4 words of memory",
+		},
+		{
+			desc:       "Space too big for memory",
+			job:        runner.Job{Source: "HALT\n.space 100000000", MemorySize: 1024},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "main.gasm:2: program would be larger than 1024 words",
+		},
+		{
+			desc:       "Include outside files",
+			job:        runner.Job{Source: ".include \"../etc/passwd\""},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "invalid argument",
+		},
+	}
+	for _, tC := range testCases {
+		got := struct{ Error string }{}
+		status := submit(t, server, tC.job, &got)
+		if status != tC.wantStatus || !strings.Contains(got.Error, tC.wantError) {
+			t.Errorf("%s: want status %d with error containing %q, got %d with %q", tC.desc, tC.wantStatus, tC.wantError, status, got.Error)
+		}
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+	server := newServer(t, runner.Limits{Concurrency: 1, Timeout: time.Second})
+	done := make(chan runner.Result)
+	go func() {
+		// Retry until the job gets the slot, rather than one of those
+		// below.
+		for {
+			result := runner.Result{}
+			job := runner.Job{Source: "SETA 60000\nBIOS SLEEP, CLOCK\nHALT"}
+			if submit(t, server, job, &result) != http.StatusServiceUnavailable {
+				done <- result
+				return
+			}
+		}
+	}()
+	// Jobs submitted one at a time can only be refused while the long job
+	// is running.
+	refused := false
+	for i := 0; i < 150 && !refused; i++ {
+		result := struct{ Error string }{}
+		refused = submit(t, server, runner.Job{Source: "HALT"}, &result) == http.StatusServiceUnavailable
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !refused {
+		t.Error("want job refused while another is running")
+	}
+	if result := <-done; result.Status != runner.StatusTimeout {
+		t.Errorf("want long job to time out, got %q", result.Status)
+	}
+	result := runner.Result{}
+	if status := submit(t, server, runner.Job{Source: "HALT"}, &result); status != http.StatusOK {
+		t.Errorf("want job accepted once the slot is free, got status %d", status)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+	server := newServer(t, runner.Limits{Concurrency: 2, Timeout: time.Second})
+	resp, err := http.Get(server.URL + "/v1/limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got := map[string]int64{}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["concurrency"] != 2 || got["timeoutMs"] != 1000 || got["maxSteps"] != int64(runner.DefaultLimits.MaxSteps) {
+		t.Errorf("want configured and default limits, got %v", got)
+	}
+	resp, err = http.Get(server.URL + "/v1/run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /v1/run: want status 405, got %s", resp.Status)
+	}
+}
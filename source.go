@@ -0,0 +1,226 @@
+package gmachine
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sources opens source files and resolves the paths named by `.include`,
+// either on the host or within an fs.FS.
+type sources interface {
+	open(name string) (io.ReadCloser, error)
+	// include returns the path of the file included as name from the file
+	// at from, and a key identifying it for cycle detection.
+	include(from, name string) (path, key string, err error)
+}
+
+type hostSources struct{}
+
+func (hostSources) open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (hostSources) include(from, name string) (string, string, error) {
+	if !filepath.IsAbs(name) && from != "" {
+		name = filepath.Join(filepath.Dir(from), name)
+	}
+	abs, err := filepath.Abs(name)
+	return name, abs, err
+}
+
+type fsSources struct {
+	fsys fs.FS
+}
+
+func (s fsSources) open(name string) (io.ReadCloser, error) {
+	return s.fsys.Open(name)
+}
+
+func (fsSources) include(from, name string) (string, string, error) {
+	if from != "" {
+		name = path.Join(path.Dir(from), name)
+	}
+	name = path.Clean(name)
+	if !fs.ValidPath(name) {
+		return "", "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return name, name, nil
+}
+
+// ErrNoCode is returned for source with no code in it, such as an empty
+// file.
+var ErrNoCode = errors.New("Invalid code. Length is 0")
+
+// errNoFiles reports an include in source which has no files to include
+// from.
+var errNoFiles = errors.New("cannot include files in source read from memory")
+
+// noSources gives source held in memory no files to include, so that it
+// can't read those of the host.
+type noSources struct{}
+
+func (noSources) open(name string) (io.ReadCloser, error) {
+	return nil, errNoFiles
+}
+
+func (noSources) include(from, name string) (string, string, error) {
+	return "", "", errNoFiles
+}
+
+// AssembleFromReader assembles source read from r, such as a request body.
+// The source can't include files; use AssembleProgramFromReader or
+// AssembleFS for that.
+func AssembleFromReader(r io.Reader) ([]Word, error) {
+	program, err := assembleReader(noSources{}, "", r)
+	if err != nil {
+		return nil, err
+	}
+	return program.Words, nil
+}
+
+// AssembleProgramFromReader is like AssembleFromReader, but also returns
+// the listing and symbol map. The name, if any, is used in positions, and
+// files included are read from the host, relative to the directory of the
+// named file, or the current directory if there is no name. It suits
+// source from a trusted user, such as standard input or an editor.
+func AssembleProgramFromReader(name string, r io.Reader) (*Program, error) {
+	return assembleReader(hostSources{}, name, r)
+}
+
+func assembleReader(src sources, name string, r io.Reader) (*Program, error) {
+	tokens, err := tokenize(name, r)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) <= 0 {
+		return nil, ErrNoCode
+	}
+	tokens, err = includeFiles(src, tokens, nil)
+	if err != nil {
+		return nil, err
+	}
+	a := newAssembler()
+	err = a.assemble(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return a.program(), nil
+}
+
+// AssembleFS assembles several source files from fsys together, like
+// AssembleFiles. Included files are also read from fsys.
+func AssembleFS(fsys fs.FS, paths ...string) ([]Word, error) {
+	tokens, err := readFiles(fsSources{fsys: fsys}, paths)
+	if err != nil {
+		return nil, err
+	}
+	return assemble(tokens)
+}
+
+// AssembleProgramFS is like AssembleFS, but also returns the listing and
+// symbol map.
+func AssembleProgramFS(fsys fs.FS, paths ...string) (*Program, error) {
+	return AssembleProgramFSLimit(fsys, MaxProgramSize, paths...)
+}
+
+// AssembleProgramFSLimit is like AssembleProgramFS, but won't let .space
+// and .org make the program larger than maxWords, such as the memory it is
+// to be loaded into, or MaxProgramSize if that is smaller.
+func AssembleProgramFSLimit(fsys fs.FS, maxWords int, paths ...string) (*Program, error) {
+	tokens, err := readFiles(fsSources{fsys: fsys}, paths)
+	if err != nil {
+		return nil, err
+	}
+	a := newAssembler()
+	if maxWords < 0 {
+		maxWords = 0
+	}
+	if maxWords < MaxProgramSize {
+		a.maxSize = Word(maxWords)
+	}
+	err = a.assemble(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return a.program(), nil
+}
+
+// readFiles returns the tokens of the given files in order, with their
+// includes expanded.
+func readFiles(src sources, paths []string) ([]token, error) {
+	tokens := []token{}
+	for _, name := range paths {
+		name, key, err := src.include("", name)
+		if err != nil {
+			return nil, err
+		}
+		source, err := readSource(src, name)
+		if err != nil {
+			return nil, err
+		}
+		source, err = includeFiles(src, source, []string{key})
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, source...)
+	}
+	return tokens, nil
+}
+
+func readSource(src sources, name string) ([]token, error) {
+	file, err := src.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return tokenize(name, file)
+}
+
+// includeFiles replaces each `.include "path"` directive with the tokens of
+// the named file, resolved relative to the including file. The stack holds
+// the keys of the files being included, to detect cycles.
+func includeFiles(src sources, tokens []token, stack []string) ([]token, error) {
+	out := []token{}
+	for pos := 0; pos < len(tokens); pos++ {
+		tok := tokens[pos]
+		if tok.text != ".include" {
+			out = append(out, tok)
+			continue
+		}
+		args := restOfLine(tokens, pos)
+		pos += len(args)
+		if len(args) == 0 {
+			return nil, tok.errorf("usage: .include \"path\"")
+		}
+		name, err := strconv.Unquote(joinTokens(args).text)
+		if err != nil {
+			return nil, args[0].errorf("invalid include path %s", joinTokens(args).text)
+		}
+		name, key, err := src.include(tok.pos.File, name)
+		if err != nil {
+			return nil, tok.wrap(err)
+		}
+		for _, including := range stack {
+			if including == key {
+				cycle := append(append([]string{}, stack...), key)
+				return nil, tok.errorf("include cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		source, err := readSource(src, name)
+		if err != nil {
+			return nil, tok.wrap(err)
+		}
+		source, err = includeFiles(src, source, append(stack[:len(stack):len(stack)], key))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, source...)
+	}
+	return out, nil
+}
@@ -0,0 +1,108 @@
+package gmachine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbol is a label or constant defined by a program.
+type Symbol struct {
+	Name  string
+	Value Word
+	Label bool
+}
+
+// SymbolMap lists the symbols of an assembled program, so that addresses
+// can be shown symbolically. In its text form each line holds the kind
+// ("label" or "const"), the value and the name of one symbol.
+type SymbolMap struct {
+	Symbols []Symbol
+}
+
+func newSymbolMap(symbols []Symbol) *SymbolMap {
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Value != symbols[j].Value {
+			return symbols[i].Value < symbols[j].Value
+		}
+		return symbols[i].Name < symbols[j].Name
+	})
+	return &SymbolMap{Symbols: symbols}
+}
+
+// Lookup returns addr relative to the nearest label at or before it, such
+// as "loop+3", or an empty string if there is no such label.
+func (m *SymbolMap) Lookup(addr Word) string {
+	best := -1
+	for i, sym := range m.Symbols {
+		if sym.Label && sym.Value <= addr {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	sym := m.Symbols[best]
+	if sym.Value == addr {
+		return sym.Name
+	}
+	return fmt.Sprintf("%s+%d", sym.Name, addr-sym.Value)
+}
+
+// Find returns the symbol with the given name.
+func (m *SymbolMap) Find(name string) (Symbol, bool) {
+	for _, sym := range m.Symbols {
+		if sym.Name == name {
+			return sym, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// WriteTo writes the symbol map in its text form.
+func (m *SymbolMap) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, sym := range m.Symbols {
+		kind := "const"
+		if sym.Label {
+			kind = "label"
+		}
+		n, err := fmt.Fprintf(w, "%s %d %s\n", kind, sym.Value, sym.Name)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ReadSymbolMap reads a symbol map in the text form written by WriteTo.
+func ReadSymbolMap(r io.Reader) (*SymbolMap, error) {
+	symbols := []Symbol{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 || (fields[0] != "label" && fields[0] != "const") {
+			return nil, fmt.Errorf("symbol map line %d: want kind, value and name", line)
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("symbol map line %d: %v", line, err)
+		}
+		symbols = append(symbols, Symbol{
+			Name:  fields[2],
+			Value: Word(value),
+			Label: fields[0] == "label",
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return newSymbolMap(symbols), nil
+}
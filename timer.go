@@ -0,0 +1,31 @@
+package gmachine
+
+// IRQTimer is the interrupt line raised by the interval timer. Being line
+// zero, it has the highest priority.
+const IRQTimer = 0
+
+// timerOperation performs a timer BIOS operation. TimerSet starts the
+// timer with a period of A instructions, or stops it if A is zero.
+func (g *GMachine) timerOperation(operation Word) {
+	if operation != TimerSet {
+		g.E = ErrorInvalid
+		return
+	}
+	g.timerPeriod = g.A
+	g.timerRemaining = g.A
+	g.E = ErrorNone
+}
+
+// tickTimer counts down one executed instruction, raising IRQTimer each
+// time the period expires. Because it is driven by the instruction count
+// rather than the host clock, timer interrupts are deterministic.
+func (g *GMachine) tickTimer() {
+	if g.timerPeriod == 0 {
+		return
+	}
+	g.timerRemaining--
+	if g.timerRemaining == 0 {
+		g.timerRemaining = g.timerPeriod
+		g.Interrupt(IRQTimer)
+	}
+}
@@ -0,0 +1,238 @@
+// Package tui is a full-screen terminal debugger for G-machine programs,
+// drawn with ANSI escape codes. It shows the disassembly around P, the
+// registers and flags, memory around I and the program's output, and
+// steps, continues and stops at breakpoints at the press of a key.
+package tui
+
+import (
+	"errors"
+	"gmachine"
+	"io"
+	"strings"
+	"time"
+)
+
+// Help lists the key bindings, as shown on the status line.
+const Help = "s step  n next  c cont  b break  ↑↓ move  PgUp/PgDn memory  i follow I  q quit"
+
+// batchTime is how long a continuing program runs between checks for
+// keys and redraws.
+const batchTime = 20 * time.Millisecond
+
+// Debugger is the state of a debugging session on a machine with its
+// program loaded.
+type Debugger struct {
+	g *gmachine.GMachine
+	// size is the number of words loaded, which the disassembly covers.
+	size        gmachine.Word
+	breakpoints map[gmachine.Word]bool
+	// cursor is the address selected in the disassembly, where b toggles
+	// a breakpoint. It follows P whenever the program stops.
+	cursor gmachine.Word
+	// memoryTop is the first address in the memory view, which is centred
+	// on I when followI is set.
+	memoryTop gmachine.Word
+	followI   bool
+	output    strings.Builder
+	status    string
+	// running is set while the program is continuing, until it reaches
+	// until, if set, or a breakpoint.
+	running  bool
+	until    *gmachine.Word
+	finished bool
+	// Width and Height are the size of the screen in characters.
+	Width, Height int
+}
+
+// New returns a debugger for g, whose first size words of memory hold the
+// program. The program's output is captured for the output pane.
+func New(g *gmachine.GMachine, size int) *Debugger {
+	d := &Debugger{
+		g:           g,
+		size:        gmachine.Word(size),
+		breakpoints: map[gmachine.Word]bool{},
+		followI:     true,
+		status:      "stopped at entry",
+		Width:       80,
+		Height:      24,
+	}
+	g.Stdout = outputWriter{d}
+	g.Stderr = outputWriter{d}
+	return d
+}
+
+type outputWriter struct {
+	d *Debugger
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	return w.d.output.Write(p)
+}
+
+// Running reports whether the program is continuing.
+func (d *Debugger) Running() bool {
+	return d.running
+}
+
+// HandleKey carries out the command bound to a key, as named by ReadKeys,
+// and reports whether the debugger should quit. Any key pauses a
+// continuing program.
+func (d *Debugger) HandleKey(key string) (quit bool) {
+	if d.running {
+		d.stop("paused")
+		return false
+	}
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "s", " ":
+		d.step()
+	case "n":
+		if d.g.P >= gmachine.Word(len(d.g.Memory)) || d.g.Memory[d.g.P] != gmachine.CALL {
+			d.step()
+			break
+		}
+		ret := d.g.P + 2
+		d.until = &ret
+		d.resume()
+	case "c":
+		d.until = nil
+		d.resume()
+	case "b":
+		if d.breakpoints[d.cursor] {
+			delete(d.breakpoints, d.cursor)
+		} else {
+			d.breakpoints[d.cursor] = true
+		}
+	case "up", "k":
+		starts := d.instructions(d.cursor)
+		for i, addr := range starts {
+			if addr == d.cursor && i > 0 {
+				d.cursor = starts[i-1]
+				break
+			}
+		}
+	case "down", "j":
+		_, size := gmachine.Disassemble(d.g.Memory, d.cursor)
+		if next := d.cursor + gmachine.Word(size); next < gmachine.Word(len(d.g.Memory)) {
+			d.cursor = next
+		}
+	case "pgup":
+		d.followI = false
+		d.memoryTop -= gmachine.Word(memoryRows * wordsPerRow)
+		if d.memoryTop > gmachine.Word(len(d.g.Memory)) {
+			d.memoryTop = 0
+		}
+	case "pgdn":
+		d.followI = false
+		d.memoryTop += gmachine.Word(memoryRows * wordsPerRow)
+		if last := d.lastMemoryTop(); d.memoryTop > last {
+			d.memoryTop = last
+		}
+	case "i":
+		d.followI = true
+	}
+	return false
+}
+
+// step executes one instruction.
+func (d *Debugger) step() {
+	if d.finished {
+		return
+	}
+	if d.execute() {
+		d.stop("stepped")
+	}
+}
+
+func (d *Debugger) resume() {
+	if d.finished {
+		return
+	}
+	d.running = true
+	d.status = "running; press any key to pause"
+}
+
+// Continue runs a continuing program for a short while, until it stops or
+// it is time to check for keys again.
+func (d *Debugger) Continue() {
+	deadline := time.Now().Add(batchTime)
+	for i := 0; d.running; i++ {
+		if !d.execute() {
+			return
+		}
+		switch {
+		case d.until != nil && d.g.P == *d.until:
+			d.stop("stepped over call")
+		case d.breakpoints[d.g.P]:
+			d.stop("breakpoint")
+		case i%1024 == 0 && time.Now().After(deadline):
+			return
+		}
+	}
+}
+
+// execute executes one instruction, reporting whether the program can go
+// on.
+func (d *Debugger) execute() bool {
+	running, err := d.g.Step()
+	switch {
+	case err != nil:
+		var fault *gmachine.Fault
+		if errors.As(err, &fault) {
+			d.g.P = fault.P
+		}
+		d.finish(err.Error())
+		return false
+	case !running:
+		d.finish("halted")
+		return false
+	}
+	return true
+}
+
+func (d *Debugger) stop(status string) {
+	d.running = false
+	d.until = nil
+	d.status = status
+	d.cursor = d.g.P
+}
+
+func (d *Debugger) finish(status string) {
+	d.stop(status)
+	d.finished = true
+}
+
+// Run draws the debugger on out and handles keys from in, as ReadKeys
+// reads them, until the user quits or in ends. in should be a terminal in
+// raw mode.
+func (d *Debugger) Run(in io.Reader, out io.Writer) error {
+	keys := make(chan string)
+	go func() {
+		ReadKeys(in, keys)
+		close(keys)
+	}()
+	io.WriteString(out, enterScreen)
+	defer io.WriteString(out, leaveScreen)
+	for {
+		if err := d.Render(out); err != nil {
+			return err
+		}
+		if d.running {
+			select {
+			case key, ok := <-keys:
+				if !ok {
+					return nil
+				}
+				d.HandleKey(key)
+			default:
+				d.Continue()
+			}
+			continue
+		}
+		key, ok := <-keys
+		if !ok || d.HandleKey(key) {
+			return nil
+		}
+	}
+}
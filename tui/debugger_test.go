@@ -0,0 +1,193 @@
+package tui_test
+
+import (
+	"bytes"
+	"gmachine"
+	"gmachine/tui"
+	"strings"
+	"testing"
+)
+
+const hello = `start:  SETI message
+        CALL print
+        HALT
+print:  SETA [I]
+        CMPA 0
+        JEQ more
+        RETN
+more:   BIOS IOWRITE, STDOUT
+        INCI
+        JUMP print
+message:
+        .stringz "Hi"
+`
+
+func newDebugger(t *testing.T, source string) (*tui.Debugger, *gmachine.GMachine) {
+	t.Helper()
+	program, err := gmachine.AssembleProgramFromReader("hello.gasm", strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gmachine.New()
+	copy(g.Memory, program.Words)
+	g.Debug = program.DebugInfo()
+	return tui.New(g, len(program.Words)), g
+}
+
+// keys presses each key in turn, letting the program run after each one
+// until it stops.
+func keys(d *tui.Debugger, keys ...string) {
+	for _, key := range keys {
+		d.HandleKey(key)
+		for d.Running() {
+			d.Continue()
+		}
+	}
+}
+
+func render(t *testing.T, d *tui.Debugger) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := d.Render(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// line returns the line of the screen containing text.
+func line(screen, text string) string {
+	for _, l := range strings.Split(screen, "\r\n") {
+		if strings.Contains(l, text) {
+			return l
+		}
+	}
+	return ""
+}
+
+func TestStep(t *testing.T) {
+	t.Parallel()
+	d, g := newDebugger(t, hello)
+	screen := render(t, d)
+	if l := line(screen, "SETI 17"); !strings.HasPrefix(l, "\x1b[7m >    0  start      SETI 17") {
+		t.Errorf("want first instruction highlighted, got %q", l)
+	}
+	if !strings.Contains(screen, "hello.gasm:1 (start)") {
+		t.Errorf("want source location in title, got %q", line(screen, "G-machine debugger"))
+	}
+	keys(d, "s", " ")
+	if g.P != 5 {
+		t.Fatalf("want P=5 after two steps, got %d", g.P)
+	}
+	screen = render(t, d)
+	if l := line(screen, "SETA [I]"); !strings.HasPrefix(l, "\x1b[7m >    5  print      SETA [I]") {
+		t.Errorf("want instruction at P highlighted, got %q", l)
+	}
+	if l := line(screen, " I  "); !strings.Contains(l, " I  17") {
+		t.Errorf("want register I=17, got %q", l)
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	t.Parallel()
+	d, g := newDebugger(t, hello)
+	keys(d, "down", "down", "down", "down", "down", "down", "down", "b")
+	if l := line(render(t, d), "BIOS 0 1"); !strings.HasPrefix(l, "*>   11  more") {
+		t.Errorf("want breakpoint marked at cursor, got %q", l)
+	}
+	for _, want := range []gmachine.Word{'H', 'i'} {
+		keys(d, "c")
+		if g.P != 11 || g.A != want {
+			t.Errorf("want stop at breakpoint with A=%d, got P=%d A=%d", want, g.P, g.A)
+		}
+	}
+	keys(d, "b", "c")
+	screen := render(t, d)
+	if !strings.Contains(screen, " halted  |") {
+		t.Errorf("want halted status, got %q", line(screen, "|"))
+	}
+	if l := line(screen, "Output"); l == "" || !strings.Contains(screen, "\r\nHi ") {
+		t.Errorf("want output Hi, got %q", screen)
+	}
+}
+
+func TestNext(t *testing.T) {
+	t.Parallel()
+	d, g := newDebugger(t, hello)
+	keys(d, "n", "n")
+	if g.P != 4 || g.I != 19 {
+		t.Errorf("want call stepped over to P=4 with I=19, got P=%d I=%d", g.P, g.I)
+	}
+	keys(d, "n")
+	if !strings.Contains(render(t, d), " halted  |") {
+		t.Error("want halted")
+	}
+}
+
+func TestMemoryView(t *testing.T) {
+	t.Parallel()
+	d, _ := newDebugger(t, "SETI 40\nHALT\n.word 0, 72")
+	keys(d, "s")
+	screen := render(t, d)
+	if !strings.Contains(screen, "Memory at 28, following I") {
+		t.Errorf("want memory centred on I, got %q", line(screen, "Memory"))
+	}
+	if l := line(screen, "   40 "); !strings.HasPrefix(l, "   40  \x1b[7m0000000000000000\x1b[0m") {
+		t.Errorf("want word at I highlighted, got %q", l)
+	}
+	keys(d, "pgup", "pgup")
+	screen = render(t, d)
+	if !strings.Contains(screen, "Memory at 0 ") {
+		t.Errorf("want memory scrolled to the top, got %q", line(screen, "Memory"))
+	}
+	if l := line(screen, "    4  0000000000000048"); !strings.HasSuffix(strings.TrimRight(l, " "), "H...") {
+		t.Errorf("want characters shown, got %q", l)
+	}
+	keys(d, "i")
+	if !strings.Contains(render(t, d), "Memory at 28, following I") {
+		t.Error("want memory following I again")
+	}
+}
+
+func TestFault(t *testing.T) {
+	t.Parallel()
+	d, g := newDebugger(t, "NOOP\n.word 99\n")
+	keys(d, "c")
+	if g.P != 1 {
+		t.Errorf("want P at faulting instruction, got %d", g.P)
+	}
+	if screen := render(t, d); !strings.Contains(screen, "fault at hello.gasm:2: invalid opcode 99") {
+		t.Errorf("want fault in status, got %q", line(screen, "|"))
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	d, g := newDebugger(t, hello)
+	out := &bytes.Buffer{}
+	if err := d.Run(strings.NewReader("ss\x1b[Bq"), out); err != nil {
+		t.Fatal(err)
+	}
+	if g.P != 5 {
+		t.Errorf("want two steps before quitting, got P=%d", g.P)
+	}
+	if screen := out.String(); !strings.HasPrefix(screen, "\x1b[?1049h") || !strings.HasSuffix(screen, "\x1b[?1049l") {
+		t.Error("want alternate screen entered and left")
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	t.Parallel()
+	keys := make(chan string)
+	go func() {
+		tui.ReadKeys(strings.NewReader("a\x1b[A\x1b[6~\x1bOB \x03\r\x1b"), keys)
+		close(keys)
+	}()
+	got := []string{}
+	for key := range keys {
+		got = append(got, key)
+	}
+	want := []string{"a", "up", "pgdn", "down", " ", "ctrl-c", "enter", "esc"}
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		t.Errorf("want keys %q, got %q", want, got)
+	}
+}
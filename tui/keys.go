@@ -0,0 +1,65 @@
+package tui
+
+import (
+	"bufio"
+	"io"
+)
+
+// escapes names the keys which terminals send as escape sequences.
+var escapes = map[string]string{
+	"[A":  "up",
+	"[B":  "down",
+	"[C":  "right",
+	"[D":  "left",
+	"OA":  "up",
+	"OB":  "down",
+	"[5~": "pgup",
+	"[6~": "pgdn",
+	"[H":  "home",
+	"[F":  "end",
+}
+
+// ReadKeys reads keys typed on a terminal in raw mode from r, sending
+// each to keys by name: printable characters as themselves, and others as
+// names such as "up", "pgdn", "esc" or "ctrl-c". It returns when r ends.
+func ReadKeys(r io.Reader, keys chan<- string) {
+	br := bufio.NewReader(r)
+	for {
+		c, _, err := br.ReadRune()
+		if err != nil {
+			return
+		}
+		switch {
+		case c == 0x1b:
+			keys <- escape(br)
+		case c == 3:
+			keys <- "ctrl-c"
+		case c == '\r' || c == '\n':
+			keys <- "enter"
+		case c < ' ' || c == 0x7f:
+			// Other control characters aren't bound to anything.
+		default:
+			keys <- string(c)
+		}
+	}
+}
+
+// escape reads the rest of an escape sequence, which arrives all at once,
+// unlike a lone press of the escape key.
+func escape(br *bufio.Reader) string {
+	seq := []byte{}
+	for br.Buffered() > 0 {
+		b, _ := br.ReadByte()
+		seq = append(seq, b)
+		if len(seq) > 1 && (b >= 'A' && b <= 'Z' || b == '~') {
+			break
+		}
+	}
+	if len(seq) == 0 {
+		return "esc"
+	}
+	if name, ok := escapes[string(seq)]; ok {
+		return name
+	}
+	return "unknown"
+}
@@ -0,0 +1,283 @@
+package tui
+
+import (
+	"fmt"
+	"gmachine"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI escape sequences.
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	home        = "\x1b[H"
+	reverse     = "\x1b[7m"
+	bold        = "\x1b[1m"
+	reset       = "\x1b[0m"
+)
+
+// The layout of the screen: below the code and registers, memoryRows rows
+// of memory with wordsPerRow words each, then outputRows lines of output.
+const (
+	memoryRows     = 6
+	wordsPerRow    = 4
+	outputRows     = 3
+	registersWidth = 24
+	// minWidth fits a row of memory.
+	minWidth = 80
+)
+
+// Render draws the whole screen.
+func (d *Debugger) Render(w io.Writer) error {
+	width, height := d.Width, d.Height
+	if width < minWidth {
+		width = minWidth
+	}
+	codeRows := height - 2 - (memoryRows + 1) - (outputRows + 1)
+	if codeRows < 4 {
+		codeRows = 4
+	}
+	lines := []string{}
+	title := " G-machine debugger"
+	if d.g.P < gmachine.Word(len(d.g.Memory)) {
+		title += "  " + d.g.Location(d.g.P)
+	}
+	lines = append(lines, style(pad(title, width), reverse))
+	code := d.code(width-registersWidth, codeRows)
+	registers := d.registers(codeRows)
+	for i := range code {
+		lines = append(lines, code[i]+registers[i])
+	}
+	lines = append(lines, d.memory(width)...)
+	lines = append(lines, d.outputPane(width)...)
+	lines = append(lines, style(pad(" "+d.status+"  |  "+Help, width), reverse))
+	_, err := io.WriteString(w, home+strings.Join(lines, "\r\n"))
+	return err
+}
+
+// code returns the disassembly pane, rows lines high, with the current
+// instruction highlighted.
+func (d *Debugger) code(width, rows int) []string {
+	lines := []string{style(pad("Code", width), bold)}
+	starts := d.instructions(d.cursor)
+	first := 0
+	for i, addr := range starts {
+		if addr == d.cursor {
+			first = i - (rows-1)/3
+		}
+	}
+	if first > len(starts)-(rows-1) {
+		first = len(starts) - (rows - 1)
+	}
+	if first < 0 {
+		first = 0
+	}
+	for i := first; i < len(starts) && len(lines) < rows; i++ {
+		addr := starts[i]
+		marker := ' '
+		if d.breakpoints[addr] {
+			marker = '*'
+		}
+		cursor := ' '
+		if addr == d.cursor {
+			cursor = '>'
+		}
+		text, _ := gmachine.Disassemble(d.g.Memory, addr)
+		line := pad(fmt.Sprintf("%c%c%5d  %-10s %s", marker, cursor, addr, d.label(addr), text), width)
+		if addr == d.g.P && !d.running {
+			line = style(line, reverse)
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) < rows {
+		lines = append(lines, pad("", width))
+	}
+	return lines
+}
+
+// label returns the label at addr, if there is one.
+func (d *Debugger) label(addr gmachine.Word) string {
+	symbols := d.g.Symbols
+	if symbols == nil && d.g.Debug != nil {
+		symbols = d.g.Debug.Symbols
+	}
+	if symbols == nil {
+		return ""
+	}
+	if name := symbols.Lookup(addr); !strings.Contains(name, "+") {
+		return name
+	}
+	return ""
+}
+
+// instructions returns the addresses of the instructions in the program,
+// found by disassembling from address zero, and resynchronising at P and
+// at anchor so that they are always among them.
+func (d *Debugger) instructions(anchor gmachine.Word) []gmachine.Word {
+	end := d.size
+	for _, addr := range []gmachine.Word{d.g.P, anchor} {
+		if addr+1 > end {
+			end = addr + 1
+		}
+	}
+	if end > gmachine.Word(len(d.g.Memory)) {
+		end = gmachine.Word(len(d.g.Memory))
+	}
+	starts := []gmachine.Word{}
+	for addr := gmachine.Word(0); addr < end; {
+		starts = append(starts, addr)
+		_, size := gmachine.Disassemble(d.g.Memory, addr)
+		next := addr + gmachine.Word(size)
+		for _, sync := range []gmachine.Word{d.g.P, anchor} {
+			if sync > addr && sync < next {
+				next = sync
+			}
+		}
+		addr = next
+	}
+	return starts
+}
+
+// registers returns the registers pane, rows lines high.
+func (d *Debugger) registers(rows int) []string {
+	g := d.g
+	lines := []string{
+		"Registers",
+		fmt.Sprintf("A  %d", g.A),
+		fmt.Sprintf("I  %d", g.I),
+		fmt.Sprintf("N  %d", g.N),
+		fmt.Sprintf("P  %d", g.P),
+		fmt.Sprintf("E  %d", g.E),
+		fmt.Sprintf("V  %d  M  %d", g.V, g.M),
+		fmt.Sprintf("Z  %s  I  %s", flag(g.FlagZ), flag(g.FlagI)),
+		fmt.Sprintf("Cycles  %d", g.Cycles),
+	}
+	result := make([]string, rows)
+	for i := range result {
+		if i < len(lines) {
+			result[i] = pad(" "+lines[i], registersWidth)
+		} else {
+			result[i] = pad("", registersWidth)
+		}
+	}
+	result[0] = style(result[0], bold)
+	return result
+}
+
+func flag(set bool) string {
+	if set {
+		return "1"
+	}
+	return "0"
+}
+
+// lastMemoryTop returns the highest address at which the memory view can
+// start.
+func (d *Debugger) lastMemoryTop() gmachine.Word {
+	size := gmachine.Word(len(d.g.Memory))
+	view := gmachine.Word(memoryRows * wordsPerRow)
+	if size <= view {
+		return 0
+	}
+	return (size - view + wordsPerRow - 1) / wordsPerRow * wordsPerRow
+}
+
+// memory returns the memory pane, with each word in hex and as a
+// character, and the word at I highlighted.
+func (d *Debugger) memory(width int) []string {
+	if d.followI {
+		top := d.g.I / wordsPerRow * wordsPerRow
+		offset := gmachine.Word(memoryRows / 2 * wordsPerRow)
+		if top < offset {
+			top = 0
+		} else {
+			top -= offset
+		}
+		if last := d.lastMemoryTop(); top > last {
+			top = last
+		}
+		d.memoryTop = top
+	}
+	header := fmt.Sprintf("Memory at %d", d.memoryTop)
+	if d.followI {
+		header += ", following I"
+	}
+	lines := []string{style(pad(header, width), bold)}
+	for row := 0; row < memoryRows; row++ {
+		b := strings.Builder{}
+		chars := strings.Builder{}
+		start := d.memoryTop + gmachine.Word(row*wordsPerRow)
+		fmt.Fprintf(&b, "%5d ", start)
+		visible := 6
+		for i := gmachine.Word(0); i < wordsPerRow; i++ {
+			addr := start + i
+			if addr >= gmachine.Word(len(d.g.Memory)) {
+				b.WriteString(strings.Repeat(" ", 17))
+				visible += 17
+				continue
+			}
+			word := fmt.Sprintf("%016x", d.g.Memory[addr])
+			if addr == d.g.I {
+				word = style(word, reverse)
+			}
+			b.WriteString(" " + word)
+			visible += 17
+			chars.WriteRune(printable(d.g.Memory[addr]))
+		}
+		b.WriteString("  " + chars.String())
+		visible += 2 + chars.Len()
+		line := b.String()
+		if visible < width {
+			line += strings.Repeat(" ", width-visible)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// printable returns the character a word holds, or '.' if it isn't a
+// printable ASCII character.
+func printable(w gmachine.Word) rune {
+	if w >= ' ' && w < 0x7f {
+		return rune(w)
+	}
+	return '.'
+}
+
+// outputPane returns the last lines of the program's output.
+func (d *Debugger) outputPane(width int) []string {
+	text := strings.TrimSuffix(d.output.String(), "\n")
+	all := strings.Split(text, "\n")
+	if len(all) > outputRows {
+		all = all[len(all)-outputRows:]
+	}
+	lines := []string{style(pad("Output", width), bold)}
+	for i := 0; i < outputRows; i++ {
+		line := ""
+		if i < len(all) {
+			line = strings.Map(func(r rune) rune {
+				if r < ' ' || r == 0x7f {
+					return '.'
+				}
+				return r
+			}, all[i])
+		}
+		lines = append(lines, pad(line, width))
+	}
+	return lines
+}
+
+// pad truncates or pads s with spaces to width characters.
+func pad(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		return string([]rune(s)[:width])
+	}
+	return s + strings.Repeat(" ", width-n)
+}
+
+func style(s, code string) string {
+	return code + s + reset
+}
@@ -0,0 +1,215 @@
+package gmachine
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Diagnostic is a problem found by Vet in an assembled program.
+type Diagnostic struct {
+	Addr    Word
+	Pos     Position
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// portNames gives the assembly names of the BIOS ports.
+var portNames = []string{"STDIN", "STDOUT", "STDERR", "DISK", "FILE", "CLOCK", "RNG", "TIMER"}
+
+// portOperations lists the BIOS operations which each port supports.
+var portOperations = map[Word][]Word{
+	PortStdin:  {IORead},
+	PortStdout: {IOWrite},
+	PortStderr: {IOWrite},
+	PortDisk:   {DiskSeek, DiskRead, DiskWrite},
+	PortFile:   {FileOpen, FileRead, FileWrite, FileClose},
+	PortClock:  {ClockTime, ClockCycles, ClockSleep},
+	PortRandom: {RandomSeed, RandomNext},
+	PortTimer:  {TimerSet},
+}
+
+type vetter struct {
+	p *Program
+	// starts maps the address of each instruction to its size.
+	starts      map[Word]Word
+	visited     map[Word]bool
+	debug       *DebugInfo
+	diagnostics []Diagnostic
+}
+
+// Vet analyses the control flow of an assembled program, following JUMP,
+// JEQ, CALL and RETN from address zero, and reports likely mistakes:
+// execution falling off the end of the program or into data, jumps outside
+// the program or into the middle of an instruction, unreachable code, and
+// BIOS calls with unknown operations or ports.
+//
+// Addresses stored as data, such as interrupt vectors, are also taken as
+// entry points when they are labels of instructions.
+func Vet(p *Program) []Diagnostic {
+	v := &vetter{
+		p:       p,
+		starts:  map[Word]Word{},
+		visited: map[Word]bool{},
+		debug:   p.DebugInfo(),
+	}
+	for _, addr := range p.Instructions {
+		_, size := Disassemble(p.Words, addr)
+		v.starts[addr] = Word(size)
+	}
+	queue := []Word{}
+	if len(p.Words) > 0 {
+		queue = append(queue, 0)
+	}
+	queue = append(queue, v.dataEntryPoints()...)
+	for len(queue) > 0 {
+		addr := queue[0]
+		queue = queue[1:]
+		if v.visited[addr] {
+			continue
+		}
+		v.visited[addr] = true
+		queue = append(queue, v.successors(addr)...)
+	}
+	v.unreachable()
+	sort.SliceStable(v.diagnostics, func(i, j int) bool {
+		return v.diagnostics[i].Addr < v.diagnostics[j].Addr
+	})
+	return v.diagnostics
+}
+
+func (v *vetter) report(addr Word, format string, args ...interface{}) {
+	pos, _ := v.debug.Line(addr)
+	v.diagnostics = append(v.diagnostics, Diagnostic{
+		Addr:    addr,
+		Pos:     pos,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// dataEntryPoints returns the labels of instructions whose addresses
+// appear in data.
+func (v *vetter) dataEntryPoints() []Word {
+	labels := map[Word]bool{}
+	for _, sym := range v.p.Symbols.Symbols {
+		if _, ok := v.starts[sym.Value]; ok && sym.Label {
+			labels[sym.Value] = true
+		}
+	}
+	operands := map[Word]bool{}
+	for addr, size := range v.starts {
+		for i := Word(0); i < size; i++ {
+			operands[addr+i] = true
+		}
+	}
+	entries := []Word{}
+	for addr, word := range v.p.Words {
+		if !operands[Word(addr)] && labels[word] {
+			entries = append(entries, word)
+		}
+	}
+	return entries
+}
+
+// successors checks the instruction at addr, and returns the addresses
+// which execution can continue at.
+func (v *vetter) successors(addr Word) []Word {
+	size := v.starts[addr]
+	operand := func(i Word) Word {
+		if addr+i >= Word(len(v.p.Words)) {
+			return 0
+		}
+		return v.p.Words[addr+i]
+	}
+	next := addr + size
+	targets := []Word{}
+	fallThrough := true
+	switch v.p.Words[addr] {
+	case HALT, RETN, IRET:
+		fallThrough = false
+	case JUMP:
+		targets = append(targets, operand(1))
+		fallThrough = false
+	case JEQ, CALL:
+		targets = append(targets, operand(1))
+	case BIOS:
+		v.checkBIOS(addr, operand(1), operand(2))
+	}
+	successors := []Word{}
+	for _, target := range targets {
+		if v.checkTarget(addr, target) {
+			successors = append(successors, target)
+		}
+	}
+	if !fallThrough {
+		return successors
+	}
+	switch _, ok := v.starts[next]; {
+	case ok:
+		successors = append(successors, next)
+	case next >= Word(len(v.p.Words)):
+		v.report(addr, "execution falls through past the end of the program; missing HALT?")
+	default:
+		v.report(addr, "execution falls through into data at %s", v.address(next))
+	}
+	return successors
+}
+
+// checkTarget reports a jump or call to anything other than the start of
+// an instruction.
+func (v *vetter) checkTarget(addr, target Word) bool {
+	if _, ok := v.starts[target]; ok {
+		return true
+	}
+	if target >= Word(len(v.p.Words)) {
+		v.report(addr, "jump target %d is outside the program", target)
+		return false
+	}
+	for start, size := range v.starts {
+		if target > start && target < start+size {
+			v.report(addr, "jump target %s is in the middle of the instruction at %s", v.address(target), v.address(start))
+			return false
+		}
+	}
+	v.report(addr, "jump target %s is data, not an instruction", v.address(target))
+	return false
+}
+
+func (v *vetter) checkBIOS(addr, operation, port Word) {
+	operations, ok := portOperations[port]
+	if !ok {
+		v.report(addr, "BIOS call to unknown port %d", port)
+		return
+	}
+	for _, supported := range operations {
+		if operation == supported {
+			return
+		}
+	}
+	v.report(addr, "BIOS operation %d is not supported by port %s", operation, portNames[port])
+}
+
+// unreachable reports each run of instructions which execution never
+// reaches, at its first instruction.
+func (v *vetter) unreachable() {
+	reachable := true
+	for _, addr := range v.p.Instructions {
+		switch {
+		case v.visited[addr]:
+			reachable = true
+		case reachable:
+			v.report(addr, "unreachable code")
+			reachable = false
+		}
+	}
+}
+
+// address formats an address with its symbolic form, if any.
+func (v *vetter) address(addr Word) string {
+	if name := v.p.Symbols.Lookup(addr); name != "" {
+		return fmt.Sprintf("%d (%s)", addr, name)
+	}
+	return fmt.Sprintf("%d", addr)
+}